@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"log/slog"
+	"net/http"
+)
+
+// ChatReply is the response body of the non-streaming chat endpoint.
+type ChatReply struct {
+	Reply     string     `json:"reply"`
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"` // Tools the model asks the client to call
+	Title     string     `json:"title,omitempty"`      // Set once, when the session was just named
+	// InvalidJSON is set when the request's format asked for JSON but the
+	// reply doesn't parse
+	InvalidJSON bool `json:"invalid_json,omitempty"`
+}
+
+// ModelList is the response body of the models endpoint.
+type ModelList struct {
+	Models  []ModelInfo `json:"models"`
+	Default string      `json:"default"` // The model used when a request doesn't pick one
+}
+
+// HealthStatus is the response body of the health check endpoint.
+type HealthStatus struct {
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// writeJSON sends v as a JSON response with the given status code.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// handleChatAPI answers a ChatRequest with the full assistant reply in one
+// JSON response, for clients that can't use WebSockets. History is kept
+// only when the request carries a session_id.
+func handleChatAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req ChatRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Message == "" {
+		http.Error(w, "message is required", http.StatusBadRequest)
+		return
+	}
+
+	if req.SessionID != "" && !validSessionID(req.SessionID) {
+		http.Error(w, "invalid session_id", http.StatusBadRequest)
+		return
+	}
+	model, err := resolveModel(req.Model)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validateImages(r.Context(), model, req.Images); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	opts, err := req.options()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var history []OllamaMessage
+	if req.SessionID != "" {
+		history = sessions.Load(req.SessionID)
+	}
+
+	// A client that aborts the request cancels r.Context(), which stops the generation
+	reply, err := chatOllama(r.Context(), req.prompt(), &history, model, opts)
+	if err != nil {
+		if r.Context().Err() != nil {
+			slog.Info("Chat request cancelled by the client", "session", req.SessionID, "model", model)
+			return
+		}
+		ollamaFailures.WithLabelValues(model).Inc()
+		slog.Error("Ollama error", "session", req.SessionID, "model", model, "error", err)
+		status := http.StatusBadGateway
+		if errors.Is(err, ErrTimeout) {
+			status = http.StatusGatewayTimeout
+		}
+		http.Error(w, "Ollama request failed: "+err.Error(), status)
+		return
+	}
+
+	if req.SessionID != "" {
+		sessions.Save(req.SessionID, history)
+	}
+	writeJSON(w, http.StatusOK, ChatReply{
+		Reply:       reply,
+		ToolCalls:   history[len(history)-1].ToolCalls,
+		Title:       titleSession(r.Context(), req.SessionID, model, history),
+		InvalidJSON: invalidJSON(opts.Format, model, reply),
+	})
+}
+
+// handleModels lists the models available in Ollama.
+func handleModels(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	models, err := availableModels.Get(r.Context())
+	if err != nil {
+		log.Println("Could not list models:", err)
+		http.Error(w, "Ollama is unreachable: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	writeJSON(w, http.StatusOK, ModelList{Models: models, Default: OllamaModel})
+}
+
+// handleHealthz reports 200 when Ollama is reachable and 503 otherwise, so
+// orchestrators and load balancers know when the service is actually ready.
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	if err := pingOllama(r.Context()); err != nil {
+		writeJSON(w, http.StatusServiceUnavailable, HealthStatus{Status: "unavailable", Reason: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, HealthStatus{Status: "ok"})
+}
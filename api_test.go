@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestChatAPI verifies that the REST endpoint returns the full reply as JSON.
+func TestChatAPI(t *testing.T) {
+	mockOllama := mockOllamaServer()
+	defer mockOllama.Close()
+
+	oldURL := OllamaAPIURL
+	OllamaAPIURL = mockOllama.URL
+	defer func() { OllamaAPIURL = oldURL }()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/chat", strings.NewReader(`{"message": "Hi"}`))
+	rr := httptest.NewRecorder()
+	handleChatAPI(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", rr.Code, http.StatusOK, rr.Body.String())
+	}
+	var reply ChatReply
+	if err := json.NewDecoder(rr.Body).Decode(&reply); err != nil {
+		t.Fatalf("could not decode reply: %v", err)
+	}
+	if reply.Reply != "Hello World" {
+		t.Errorf("got reply %q, want %q", reply.Reply, "Hello World")
+	}
+}
+
+// TestChatAPIErrors verifies the status codes for bad input and an unreachable Ollama.
+func TestChatAPIErrors(t *testing.T) {
+	// A server that is closed immediately refuses every connection.
+	deadOllama := httptest.NewServer(http.NotFoundHandler())
+	deadOllama.Close()
+
+	oldURL, oldRetries := OllamaAPIURL, OllamaRetries
+	OllamaAPIURL, OllamaRetries = deadOllama.URL, 0
+	defer func() { OllamaAPIURL, OllamaRetries = oldURL, oldRetries }()
+
+	cases := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"bad json", `{"message":`, http.StatusBadRequest},
+		{"empty message", `{"message": ""}`, http.StatusBadRequest},
+		{"ollama down", `{"message": "Hi"}`, http.StatusBadGateway},
+	}
+
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodPost, "/api/chat", strings.NewReader(tc.body))
+		rr := httptest.NewRecorder()
+		handleChatAPI(rr, req)
+
+		if rr.Code != tc.want {
+			t.Errorf("%s: got status %d, want %d", tc.name, rr.Code, tc.want)
+		}
+	}
+}
+
+// TestModelsAPI verifies that the model list is simplified and cached.
+func TestModelsAPI(t *testing.T) {
+	var hits int32
+	mockOllama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/tags" {
+			http.NotFound(w, r)
+			return
+		}
+		atomic.AddInt32(&hits, 1)
+		w.Write([]byte(`{"models": [{"name": "gemma3:1b", "model": "gemma3:1b", "size": 815319791, "digest": "abc"}]}`))
+	}))
+	defer mockOllama.Close()
+
+	oldURL, oldCache := OllamaAPIURL, availableModels
+	OllamaAPIURL, availableModels = mockOllama.URL+"/api/chat", &modelCache{ttl: time.Minute}
+	defer func() { OllamaAPIURL, availableModels = oldURL, oldCache }()
+
+	for i := 0; i < 2; i++ {
+		rr := httptest.NewRecorder()
+		handleModels(rr, httptest.NewRequest(http.MethodGet, "/api/models", nil))
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("got status %d, want %d", rr.Code, http.StatusOK)
+		}
+		var list ModelList
+		if err := json.NewDecoder(rr.Body).Decode(&list); err != nil {
+			t.Fatalf("could not decode model list: %v", err)
+		}
+		if len(list.Models) != 1 || list.Models[0].Name != "gemma3:1b" || list.Models[0].Size != 815319791 {
+			t.Errorf("got models %+v", list.Models)
+		}
+	}
+
+	if n := atomic.LoadInt32(&hits); n != 1 {
+		t.Errorf("Ollama was asked %d times, want 1 thanks to the cache", n)
+	}
+}
+
+// TestModelsAPIUnreachable verifies that an unreachable Ollama is reported as a bad gateway.
+func TestModelsAPIUnreachable(t *testing.T) {
+	deadOllama := httptest.NewServer(http.NotFoundHandler())
+	deadOllama.Close()
+
+	oldURL, oldCache := OllamaAPIURL, availableModels
+	OllamaAPIURL, availableModels = deadOllama.URL, &modelCache{ttl: time.Minute}
+	defer func() { OllamaAPIURL, availableModels = oldURL, oldCache }()
+
+	rr := httptest.NewRecorder()
+	handleModels(rr, httptest.NewRequest(http.MethodGet, "/api/models", nil))
+
+	if rr.Code != http.StatusBadGateway {
+		t.Errorf("got status %d, want %d", rr.Code, http.StatusBadGateway)
+	}
+}
+
+// TestChatAPIModelOverride verifies that a model given in the request is sent to Ollama.
+func TestChatAPIModelOverride(t *testing.T) {
+	var gotModel string
+	mockOllama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req OllamaRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		gotModel = req.Model
+		w.Write([]byte(`{"message": {"role": "assistant", "content": "ok"}, "done": true}`))
+	}))
+	defer mockOllama.Close()
+
+	oldURL := OllamaAPIURL
+	OllamaAPIURL = mockOllama.URL
+	defer func() { OllamaAPIURL = oldURL }()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/chat", strings.NewReader(`{"message": "Hi", "model": "llama3.2"}`))
+	rr := httptest.NewRecorder()
+	handleChatAPI(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", rr.Code, http.StatusOK)
+	}
+	if gotModel != "llama3.2" {
+		t.Errorf("Ollama received model %q, want %q", gotModel, "llama3.2")
+	}
+}
+
+// TestChatAPISeed verifies that a seed given in the request reaches
+// Ollama's options, and that none is sent otherwise.
+func TestChatAPISeed(t *testing.T) {
+	var gotOptions map[string]interface{}
+	mockOllama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/tags" {
+			http.NotFound(w, r)
+			return
+		}
+		var req OllamaRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		gotOptions = req.Options
+		w.Write([]byte(`{"message": {"role": "assistant", "content": "ok"}, "done": true}`))
+	}))
+	defer mockOllama.Close()
+
+	oldURL := OllamaAPIURL
+	OllamaAPIURL = mockOllama.URL
+	defer func() { OllamaAPIURL = oldURL }()
+
+	for body, want := range map[string]interface{}{
+		`{"message": "Hi", "seed": 42}`: float64(42),
+		`{"message": "Hi", "seed": 0}`:  float64(0),
+		`{"message": "Hi"}`:             nil,
+	} {
+		rr := httptest.NewRecorder()
+		handleChatAPI(rr, httptest.NewRequest(http.MethodPost, "/api/chat", strings.NewReader(body)))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s: got status %d, want %d", body, rr.Code, http.StatusOK)
+		}
+		if got := gotOptions["seed"]; got != want {
+			t.Errorf("%s: Ollama received seed %v, want %v", body, got, want)
+		}
+	}
+}
+
+// TestHealthz verifies the health check status for a healthy, broken and hung Ollama.
+func TestHealthz(t *testing.T) {
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"models": []}`))
+	}))
+	defer healthy.Close()
+
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "boom", http.StatusInternalServerError)
+	}))
+	defer broken.Close()
+
+	hung := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer hung.Close()
+
+	cases := []struct {
+		name string
+		url  string
+		want int
+	}{
+		{"healthy", healthy.URL, http.StatusOK},
+		{"server error", broken.URL, http.StatusServiceUnavailable},
+		{"hung", hung.URL, http.StatusServiceUnavailable},
+	}
+
+	oldURL, oldTimeout := OllamaAPIURL, HealthCheckTimeout
+	HealthCheckTimeout = 200 * time.Millisecond
+	defer func() { OllamaAPIURL, HealthCheckTimeout = oldURL, oldTimeout }()
+
+	for _, tc := range cases {
+		OllamaAPIURL = tc.url + "/api/chat"
+		rr := httptest.NewRecorder()
+		start := time.Now()
+		handleHealthz(rr, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+		if rr.Code != tc.want {
+			t.Errorf("%s: got status %d, want %d", tc.name, rr.Code, tc.want)
+		}
+		if elapsed := time.Since(start); elapsed > HealthCheckTimeout+time.Second {
+			t.Errorf("%s: health check took %v", tc.name, elapsed)
+		}
+	}
+}
+
+// TestChatAPICancelCancelsOllama verifies that a client aborting a REST
+// chat, OpenAI-style or not, cancels the Ollama request and saves nothing.
+func TestChatAPICancelCancelsOllama(t *testing.T) {
+	started, cancelled := make(chan struct{}), make(chan struct{})
+	mockOllama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/tags" {
+			http.NotFound(w, r)
+			return
+		}
+		// Reading the body lets the server notice the connection closing
+		io.Copy(io.Discard, r.Body)
+		started <- struct{}{}
+		select {
+		case <-r.Context().Done():
+			cancelled <- struct{}{}
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer mockOllama.Close()
+
+	oldURL, oldSessions := OllamaAPIURL, sessions
+	OllamaAPIURL, sessions = mockOllama.URL, NewSessionStore(time.Minute, nil)
+	defer func() { OllamaAPIURL, sessions = oldURL, oldSessions }()
+
+	cases := []struct {
+		name    string
+		handler http.HandlerFunc
+		body    string
+	}{
+		{"rest", handleChatAPI, `{"message": "Hi", "session_id": "aborted"}`},
+		{"openai", handleOpenAIChat, `{"messages": [{"role": "user", "content": "Hi"}]}`},
+	}
+	for _, tc := range cases {
+		server := httptest.NewServer(tc.handler)
+		ctx, cancel := context.WithCancel(context.Background())
+		req, _ := http.NewRequestWithContext(ctx, http.MethodPost, server.URL, strings.NewReader(tc.body))
+		errs := make(chan error, 1)
+		go func() {
+			_, err := http.DefaultClient.Do(req)
+			errs <- err
+		}()
+
+		select {
+		case <-started:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%s: the request never reached Ollama", tc.name)
+		}
+		cancel()
+		select {
+		case <-cancelled:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%s: Ollama request was not cancelled after the client aborted", tc.name)
+		}
+		if err := <-errs; !errors.Is(err, context.Canceled) {
+			t.Errorf("%s: client got %v, want context.Canceled", tc.name, err)
+		}
+		server.Close()
+	}
+	if history := sessions.Load("aborted"); len(history) != 0 {
+		t.Errorf("aborted chat saved %+v", history)
+	}
+}
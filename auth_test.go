@@ -0,0 +1,125 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// TestRequireAuth verifies that the token gate accepts the bearer header or
+// query parameter and rejects everything else with 401.
+func TestRequireAuth(t *testing.T) {
+	oldToken := AuthToken
+	AuthToken = "s3cret"
+	defer func() { AuthToken = oldToken }()
+
+	handler := requireAuth(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	cases := []struct {
+		name   string
+		target string
+		header string
+		want   int
+	}{
+		{"no token", "/ws", "", http.StatusUnauthorized},
+		{"wrong bearer", "/ws", "Bearer nope", http.StatusUnauthorized},
+		{"bearer header", "/ws", "Bearer s3cret", http.StatusOK},
+		{"query param", "/ws?token=s3cret", "", http.StatusOK},
+		{"wrong query param", "/ws?token=s3cre", "", http.StatusUnauthorized},
+	}
+
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodGet, tc.target, nil)
+		if tc.header != "" {
+			req.Header.Set("Authorization", tc.header)
+		}
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+
+		if rr.Code != tc.want {
+			t.Errorf("%s: got status %d, want %d", tc.name, rr.Code, tc.want)
+		}
+	}
+}
+
+// TestRequireAuthDisabled verifies that everything is allowed when no token is configured.
+func TestRequireAuthDisabled(t *testing.T) {
+	oldToken := AuthToken
+	AuthToken = ""
+	defer func() { AuthToken = oldToken }()
+
+	handler := requireAuth(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	rr := httptest.NewRecorder()
+	handler(rr, httptest.NewRequest(http.MethodGet, "/ws", nil))
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("got status %d, want %d", rr.Code, http.StatusOK)
+	}
+}
+
+// TestRequireAuthWebSocket verifies that a WebSocket without the token is
+// closed with a policy violation, which browsers can show, not refused.
+func TestRequireAuthWebSocket(t *testing.T) {
+	oldToken := AuthToken
+	AuthToken = "s3cret"
+	defer func() { AuthToken = oldToken }()
+
+	server := httptest.NewServer(requireAuth(handleWebSocket))
+	defer server.Close()
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	ws, _, err := websocket.DefaultDialer.Dial(wsURL+"?token=nope", nil)
+	if err != nil {
+		t.Fatalf("could not open websocket connection: %v", err)
+	}
+	defer ws.Close()
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, _, err = ws.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+		t.Errorf("got %v, want a policy violation close error", err)
+	}
+}
+
+// TestCheckOrigin verifies the WebSocket origin policy for exposed modes.
+func TestCheckOrigin(t *testing.T) {
+	oldAll, oldAllowed := AllowAllOrigins, AllowedOrigins
+	AllowAllOrigins, AllowedOrigins = false, []string{"https://friend.example/"}
+	defer func() { AllowAllOrigins, AllowedOrigins = oldAll, oldAllowed }()
+
+	cases := []struct {
+		origin string
+		want   bool
+	}{
+		{"", true},
+		{"https://chat.example", true},
+		{"https://friend.example", true},
+		{"https://evil.example", false},
+		{"https://chat.example.evil.example", false},
+	}
+
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodGet, "https://chat.example/ws", nil)
+		if tc.origin != "" {
+			req.Header.Set("Origin", tc.origin)
+		}
+		if got := checkOrigin(req); got != tc.want {
+			t.Errorf("origin %q: got %v, want %v", tc.origin, got, tc.want)
+		}
+	}
+
+	// Local mode allows everything.
+	AllowAllOrigins = true
+	req := httptest.NewRequest(http.MethodGet, "http://localhost:8080/ws", nil)
+	req.Header.Set("Origin", "https://evil.example")
+	if !checkOrigin(req) {
+		t.Error("local mode should allow any origin")
+	}
+}
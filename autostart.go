@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"runtime"
+	"time"
+)
+
+// OllamaStartTimeout is how long -autostart waits for `ollama serve` to
+// answer before giving up.
+var OllamaStartTimeout = 30 * time.Second
+
+// ollamaStopTimeout is how long a started Ollama gets to exit on its own
+// before it is killed.
+const ollamaStopTimeout = 5 * time.Second
+
+// startOllama runs `<binary> serve` as a child process listening where
+// OllamaAPIURL points, logs its output, and waits until it answers. The
+// returned function stops it again; call it on exit.
+func startOllama(ctx context.Context, binary string) (func(), error) {
+	host, err := ollamaListenHost()
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command(binary, "serve")
+	cmd.Env = append(os.Environ(), "OLLAMA_HOST="+host)
+	output, w := io.Pipe()
+	cmd.Stdout, cmd.Stderr = w, w
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	slog.Info("🦙 Started ollama serve", "pid", cmd.Process.Pid, "host", host)
+
+	go func() {
+		scanner := bufio.NewScanner(output)
+		for scanner.Scan() {
+			slog.Info("🦙 ollama", "output", scanner.Text())
+		}
+	}()
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+		w.Close()
+	}()
+
+	stop := func() {
+		// Windows has no interrupt signal to send; there it is just killed
+		if runtime.GOOS == "windows" || cmd.Process.Signal(os.Interrupt) != nil {
+			cmd.Process.Kill()
+		}
+		select {
+		case <-exited:
+		case <-time.After(ollamaStopTimeout):
+			cmd.Process.Kill()
+			<-exited
+		}
+		slog.Info("🦙 Stopped ollama serve")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, OllamaStartTimeout)
+	defer cancel()
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+	for pingOllama(ctx) != nil {
+		select {
+		case err := <-exited:
+			return nil, fmt.Errorf("ollama serve exited before answering: %v", err)
+		case <-ctx.Done():
+			stop()
+			return nil, fmt.Errorf("ollama serve did not answer within %v", OllamaStartTimeout)
+		case <-ticker.C:
+		}
+	}
+	return stop, nil
+}
+
+// ollamaListenHost returns the host:port for OLLAMA_HOST that makes a
+// started Ollama answer at OllamaAPIURL. Only a server on this machine can
+// be started.
+func ollamaListenHost() (string, error) {
+	u, err := url.Parse(OllamaAPIURL)
+	if err != nil {
+		return "", err
+	}
+	host := u.Hostname()
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return "", fmt.Errorf("ollama is expected at %s, not on this machine", u.Host)
+	}
+	port := u.Port()
+	if port == "" {
+		port = "11434"
+	}
+	return net.JoinHostPort(host, port), nil
+}
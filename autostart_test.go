@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestStartOllama verifies that startOllama runs the binary's serve command
+// on the host OllamaAPIURL points to, waits for it to answer, and stops it;
+// and that it gives up on one that exits or isn't local.
+func TestStartOllama(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script as the ollama binary")
+	}
+	mockOllama := httptest.NewServer(mockOllamaHandler())
+	defer mockOllama.Close()
+
+	oldURL, oldTimeout := OllamaAPIURL, OllamaStartTimeout
+	OllamaAPIURL, OllamaStartTimeout = mockOllama.URL+"/api/chat", 2*time.Second
+	defer func() { OllamaAPIURL, OllamaStartTimeout = oldURL, oldTimeout }()
+
+	dir := t.TempDir()
+	script := func(name, body string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body+"\n"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	hostFile := filepath.Join(dir, "host")
+
+	stop, err := startOllama(context.Background(), script("serving", `echo "$1 $OLLAMA_HOST" > `+hostFile+`; exec sleep 30`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The mock already answers, so wait for the script to have run
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if _, err := os.Stat(hostFile); err == nil {
+			break
+		}
+	}
+	done := make(chan struct{})
+	go func() {
+		stop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(ollamaStopTimeout + time.Second):
+		t.Fatal("stop did not return")
+	}
+	got, _ := os.ReadFile(hostFile)
+	if want := "serve " + strings.TrimPrefix(mockOllama.URL, "http://"); strings.TrimSpace(string(got)) != want {
+		t.Errorf("ran with %q, want %q", got, want)
+	}
+
+	mockOllama.Close()
+	if _, err := startOllama(context.Background(), script("failing", "exit 3")); err == nil || !strings.Contains(err.Error(), "exited") {
+		t.Errorf("exiting binary: got %v", err)
+	}
+
+	OllamaAPIURL = "http://gpu-box:11434/api/chat"
+	if _, err := startOllama(context.Background(), script("remote", "exit 0")); err == nil {
+		t.Error("remote Ollama: got no error")
+	}
+}
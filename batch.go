@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// MaxBatchJobs caps the prompts times models of one batch request, and
+// BatchParallelism how many of its jobs run at once. Jobs also wait for
+// generation slots like any other reply, so a batch can't starve the chat
+// beyond BatchParallelism slots. They are set once in main.
+var (
+	MaxBatchJobs     = 32
+	BatchParallelism = 4
+)
+
+// BatchRequest is the request body of the batch endpoint: every prompt is
+// sent to every model, each as a fresh conversation. The other fields
+// apply to all of them, as in a ChatRequest.
+type BatchRequest struct {
+	Prompts     []string `json:"prompts"`
+	Models      []string `json:"models,omitempty"` // Empty means OllamaModel
+	System      string   `json:"system,omitempty"`
+	Temperature *float64 `json:"temperature,omitempty"`
+	MaxTokens   int      `json:"max_tokens,omitempty"`
+	Stop        []string `json:"stop,omitempty"`
+}
+
+// BatchResult is the outcome of one prompt and model. Prompt and Model
+// index into the request's lists; a failed job has Error set instead of
+// Reply.
+type BatchResult struct {
+	Index      int    `json:"index"`
+	Prompt     int    `json:"prompt"`
+	Model      string `json:"model"`
+	Reply      string `json:"reply,omitempty"`
+	Error      string `json:"error,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+// BatchReply is the response body of the batch endpoint. Results are in
+// index order: all models for the first prompt, then for the second, and
+// so on.
+type BatchReply struct {
+	Results []BatchResult `json:"results"`
+}
+
+// jobs returns the ChatRequest of each prompt and model, in index order.
+func (req BatchRequest) jobs() ([]ChatRequest, error) {
+	if len(req.Prompts) == 0 {
+		return nil, fmt.Errorf("prompts is required")
+	}
+	models := req.Models
+	if len(models) == 0 {
+		models = []string{""}
+	}
+	if n := len(req.Prompts) * len(models); n > MaxBatchJobs {
+		return nil, fmt.Errorf("a batch may run at most %d prompts times models, got %d", MaxBatchJobs, n)
+	}
+	var jobs []ChatRequest
+	for _, prompt := range req.Prompts {
+		if prompt == "" {
+			return nil, fmt.Errorf("prompts must not be empty")
+		}
+		for _, model := range models {
+			jobs = append(jobs, ChatRequest{
+				Message:     prompt,
+				Model:       model,
+				System:      req.System,
+				Temperature: req.Temperature,
+				MaxTokens:   req.MaxTokens,
+				Stop:        req.Stop,
+			})
+		}
+	}
+	return jobs, nil
+}
+
+// handleBatch runs every prompt of a BatchRequest against every model,
+// without history, and answers with all the replies at once, for
+// comparing prompts or models side by side. Jobs that fail don't fail the
+// batch; their result carries the error.
+func handleBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req BatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	jobs, err := req.jobs()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	results := make([]BatchResult, len(jobs))
+	models := make([]string, len(jobs))
+	opts := make([]SamplingOptions, len(jobs))
+	for i, job := range jobs {
+		if models[i], err = resolveModel(job.Model); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if opts[i], err = job.options(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		results[i] = BatchResult{Index: i, Prompt: i / max(len(req.Models), 1), Model: models[i]}
+	}
+
+	// The jobs go through the same non-streaming call as /api/chat, so a
+	// client that aborts the request stops the ones still running
+	parallel := make(chan struct{}, BatchParallelism)
+	var wg sync.WaitGroup
+	for i, job := range jobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case parallel <- struct{}{}:
+			case <-r.Context().Done():
+				results[i].Error = r.Context().Err().Error()
+				return
+			}
+			defer func() { <-parallel }()
+
+			start := time.Now()
+			var history []OllamaMessage
+			reply, err := chatOllama(r.Context(), job.prompt(), &history, models[i], opts[i])
+			results[i].DurationMS = time.Since(start).Milliseconds()
+			if err != nil {
+				if r.Context().Err() == nil {
+					ollamaFailures.WithLabelValues(models[i]).Inc()
+					slog.Warn("Batch job failed", "index", i, "model", models[i], "error", err)
+				}
+				results[i].Error = err.Error()
+				return
+			}
+			results[i].Reply = reply
+		}()
+	}
+	wg.Wait()
+
+	if r.Context().Err() != nil {
+		slog.Info("Batch request cancelled by the client", "jobs", len(jobs))
+		return
+	}
+	writeJSON(w, http.StatusOK, BatchReply{Results: results})
+}
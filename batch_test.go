@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestBatch verifies that every prompt goes to every model, with results
+// in index order, failures kept per job and no more than BatchParallelism
+// jobs running at once.
+func TestBatch(t *testing.T) {
+	var running, peak atomic.Int32
+	mockOllama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/tags" {
+			http.NotFound(w, r)
+			return
+		}
+		n := running.Add(1)
+		defer running.Add(-1)
+		for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+		}
+		time.Sleep(20 * time.Millisecond)
+
+		var req OllamaRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Model == "broken" {
+			http.Error(w, `{"error": "model failed to load"}`, http.StatusInternalServerError)
+			return
+		}
+		prompt := req.Messages[len(req.Messages)-1].Content
+		json.NewEncoder(w).Encode(map[string]interface{}{"message": OllamaMessage{Role: "assistant", Content: req.Model + ": " + prompt}, "done": true})
+	}))
+	defer mockOllama.Close()
+
+	oldURL, oldParallel, oldSlots := OllamaAPIURL, BatchParallelism, generationSlots
+	OllamaAPIURL, BatchParallelism, generationSlots = mockOllama.URL, 2, make(chan struct{}, 8)
+	defer func() { OllamaAPIURL, BatchParallelism, generationSlots = oldURL, oldParallel, oldSlots }()
+
+	rr := httptest.NewRecorder()
+	body := `{"prompts": ["red", "green", "blue"], "models": ["a", "broken"]}`
+	handleBatch(rr, httptest.NewRequest(http.MethodPost, "/api/batch", strings.NewReader(body)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", rr.Code, rr.Body)
+	}
+	var got BatchReply
+	json.NewDecoder(rr.Body).Decode(&got)
+	if len(got.Results) != 6 {
+		t.Fatalf("got %d results, want 6", len(got.Results))
+	}
+	for i, prompt := range []string{"red", "green", "blue"} {
+		ok, failed := got.Results[2*i], got.Results[2*i+1]
+		if ok.Index != 2*i || ok.Prompt != i || ok.Model != "a" || ok.Reply != "a: "+prompt || ok.Error != "" {
+			t.Errorf("result %d: got %+v", 2*i, ok)
+		}
+		if failed.Prompt != i || failed.Model != "broken" || failed.Reply != "" || !strings.Contains(failed.Error, "model failed to load") {
+			t.Errorf("result %d: got %+v", 2*i+1, failed)
+		}
+	}
+	if p := peak.Load(); p > 2 {
+		t.Errorf("%d jobs ran at once, want at most 2", p)
+	}
+}
+
+// TestBatchLimits verifies that empty and oversized batches are refused.
+func TestBatchLimits(t *testing.T) {
+	oldMax := MaxBatchJobs
+	MaxBatchJobs = 4
+	defer func() { MaxBatchJobs = oldMax }()
+
+	for _, body := range []string{
+		`{"prompts": []}`,
+		`{"prompts": ["a", ""]}`,
+		`{"prompts": ["a", "b", "c"], "models": ["x", "y"]}`,
+		`{"prompts": ["a"], "models": ["bad model!"]}`,
+	} {
+		rr := httptest.NewRecorder()
+		handleBatch(rr, httptest.NewRequest(http.MethodPost, "/api/batch", strings.NewReader(body)))
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: got %d, want 400", body, rr.Code)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is what a -config file sets: flag values by flag name, such as
+// "model" or "port", and the mode, which is otherwise the first argument.
+// Settings given as lists are joined with commas, and their items are also
+// kept in Lists for repeatable flags.
+type Config struct {
+	Mode     string
+	Settings map[string]string
+	Lists    map[string][]string
+}
+
+// repeatableFlag is a flag value that collects every use of the flag, like
+// headerList, rather than taking a comma-separated list.
+type repeatableFlag interface {
+	flag.Value
+	repeatable()
+}
+
+// Where a setting's value came from, as printed at startup.
+const (
+	SourceFlag    = "flag"
+	SourceEnv     = "env"
+	SourceFile    = "file"
+	SourceDefault = "default"
+)
+
+// loadConfig reads a config file in YAML or JSON, which YAML includes. Keys
+// are flag names; values are scalars, or lists for comma-separated flags
+// like allowed-origins and repeatable ones like ollama-header.
+func loadConfig(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+	var raw map[string]interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return Config{}, err
+	}
+
+	cfg := Config{Settings: make(map[string]string), Lists: make(map[string][]string)}
+	for key, value := range raw {
+		var s string
+		switch v := value.(type) {
+		case map[string]interface{}:
+			return Config{}, fmt.Errorf("%s: expected a value, got a mapping", key)
+		case []interface{}:
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = fmt.Sprint(item)
+			}
+			s = strings.Join(items, ",")
+			cfg.Lists[key] = items
+		case nil:
+			continue
+		default:
+			s = fmt.Sprint(v)
+		}
+		switch key {
+		case "mode":
+			cfg.Mode = s
+		case "config":
+			return Config{}, fmt.Errorf("a config file cannot load another one")
+		default:
+			cfg.Settings[key] = s
+		}
+	}
+	return cfg, nil
+}
+
+// flagEnvPattern finds the environment variable a flag's usage mentions.
+var flagEnvPattern = regexp.MustCompile(`env: ([A-Z0-9_]+)`)
+
+// applyConfig sets the flags of fs that cfg has values for, unless they
+// were given on the command line or through their environment variable,
+// which take precedence. It returns where every flag's value came from.
+func applyConfig(fs *flag.FlagSet, cfg Config) (map[string]string, error) {
+	sources := make(map[string]string)
+	fs.VisitAll(func(f *flag.Flag) {
+		sources[f.Name] = SourceDefault
+		if m := flagEnvPattern.FindStringSubmatch(f.Usage); m != nil && os.Getenv(m[1]) != "" {
+			sources[f.Name] = SourceEnv
+		}
+	})
+	fs.Visit(func(f *flag.Flag) { sources[f.Name] = SourceFlag })
+
+	for name, value := range cfg.Settings {
+		source, ok := sources[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown setting %q", name)
+		}
+		if source != SourceDefault {
+			continue
+		}
+		values := []string{value}
+		if items, ok := cfg.Lists[name]; ok {
+			if _, ok := fs.Lookup(name).Value.(repeatableFlag); ok {
+				values = items
+			}
+		}
+		for _, value := range values {
+			if err := fs.Set(name, value); err != nil {
+				return nil, fmt.Errorf("%s: %v", name, err)
+			}
+		}
+		sources[name] = SourceFile
+	}
+	return sources, nil
+}
+
+// logSettings prints the settings that differ from the built-in defaults,
+// with where each came from. Secrets are masked.
+func logSettings(fs *flag.FlagSet, sources map[string]string) {
+	var names []string
+	for name, source := range sources {
+		if source != SourceDefault {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value := fs.Lookup(name).Value.String()
+		if strings.Contains(name, "token") || strings.Contains(name, "auth") {
+			value = "********"
+		}
+		slog.Info("⚙️ Setting", "name", name, "value", value, "source", sources[name])
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestLoadConfig verifies that YAML and JSON files load into the same
+// Config, and that malformed ones are rejected.
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	files := map[string]string{
+		"config.yaml": "mode: lan\nmodel: llama3.2\nport: 9000\ntemp: 0.5\nallowed-origins:\n  - https://a.example\n  - https://b.example\n",
+		"config.json": `{"mode": "lan", "model": "llama3.2", "port": 9000, "temp": 0.5, "allowed-origins": ["https://a.example", "https://b.example"]}`,
+	}
+	for name, content := range files {
+		cfg, err := loadConfig(write(name, content))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		want := map[string]string{"model": "llama3.2", "port": "9000", "temp": "0.5", "allowed-origins": "https://a.example,https://b.example"}
+		if cfg.Mode != "lan" || len(cfg.Settings) != len(want) {
+			t.Errorf("%s: got %+v", name, cfg)
+		}
+		for key, value := range want {
+			if cfg.Settings[key] != value {
+				t.Errorf("%s: %s = %q, want %q", name, key, cfg.Settings[key], value)
+			}
+		}
+	}
+
+	for name, content := range map[string]string{
+		"bad.yaml":    "model: [unclosed",
+		"nested.yaml": "model:\n  name: llama3.2\n",
+		"loop.yaml":   "config: other.yaml\n",
+	} {
+		if _, err := loadConfig(write(name, content)); err == nil {
+			t.Errorf("%s: got no error", name)
+		}
+	}
+	if _, err := loadConfig(filepath.Join(dir, "missing.yaml")); err == nil {
+		t.Error("missing file: got no error")
+	}
+}
+
+// TestApplyConfigPrecedence verifies that the command line and environment
+// variables override the config file, which overrides the defaults.
+func TestApplyConfigPrecedence(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	model := fs.String("model", envOr("TEST_CONFIG_MODEL", "gemma3:1b"), "Model (env: TEST_CONFIG_MODEL)")
+	port := fs.Int("port", 8080, "Port")
+	temp := fs.Float64("temp", 0.7, "Temperature")
+	window := fs.Int("window", 20, "Window")
+	if err := fs.Parse([]string{"-port", "9001"}); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEST_CONFIG_MODEL", "from-env")
+	*model = "from-env" // As envOr would have set it
+
+	cfg := Config{Settings: map[string]string{"model": "from-file", "port": "9000", "temp": "0.2"}}
+	sources, err := applyConfig(fs, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *model != "from-env" || *port != 9001 || *temp != 0.2 || *window != 20 {
+		t.Errorf("got model %q, port %d, temp %v, window %d", *model, *port, *temp, *window)
+	}
+	want := map[string]string{"model": SourceEnv, "port": SourceFlag, "temp": SourceFile, "window": SourceDefault}
+	for name, source := range want {
+		if sources[name] != source {
+			t.Errorf("%s: got source %q, want %q", name, sources[name], source)
+		}
+	}
+
+	if _, err := applyConfig(fs, Config{Settings: map[string]string{"no-such-flag": "1"}}); err == nil {
+		t.Error("unknown setting: got no error")
+	}
+	if _, err := applyConfig(fs, Config{Settings: map[string]string{"window": "many"}}); err == nil {
+		t.Error("invalid value: got no error")
+	}
+}
+
+// TestApplyConfigRepeatable verifies that a list given for a repeatable
+// flag sets it once per item rather than joined with commas.
+func TestApplyConfigRepeatable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("ollama-header:\n  - \"Authorization: Bearer x\"\n  - \"X-Org: y\"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	var headers headerList
+	fs.Var(&headers, "ollama-header", "Header")
+	if _, err := applyConfig(fs, cfg); err != nil {
+		t.Fatal(err)
+	}
+	header, err := parseHeaders(headers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Get("Authorization") != "Bearer x" || header.Get("X-Org") != "y" {
+		t.Errorf("got headers %v", header)
+	}
+}
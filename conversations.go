@@ -0,0 +1,47 @@
+package main
+
+import "fmt"
+
+// MaxConversations is how many conversations one WebSocket connection may
+// keep apart with conversation_id.
+const MaxConversations = 32
+
+// conversationWriter stamps its conversation's id on the StreamResponse
+// frames it passes on, so a client multiplexing conversations over one
+// connection knows where each belongs.
+type conversationWriter struct {
+	out FrameWriter
+	id  string
+}
+
+func (w conversationWriter) WriteJSON(v interface{}) error {
+	if frame, ok := v.(StreamResponse); ok {
+		frame.ConversationID = w.id
+		v = frame
+	}
+	return w.out.WriteJSON(v)
+}
+
+// withConversation returns out tagging frames with the conversation id, or
+// out itself for the default conversation.
+func withConversation(out FrameWriter, id string) FrameWriter {
+	if id == "" {
+		return out
+	}
+	return conversationWriter{out: out, id: id}
+}
+
+// checkConversation rejects a malformed conversation id, and a new one
+// once a connection has MaxConversations.
+func checkConversation(conversations map[string][]OllamaMessage, id string) error {
+	if id == "" {
+		return nil
+	}
+	if !validSessionID(id) {
+		return fmt.Errorf("invalid conversation_id")
+	}
+	if _, ok := conversations[id]; !ok && len(conversations) >= MaxConversations {
+		return fmt.Errorf("at most %d conversations per connection", MaxConversations)
+	}
+	return nil
+}
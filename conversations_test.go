@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// TestConversationsOnOneConnection verifies that messages with different
+// conversation ids keep separate histories on one WebSocket, and that every
+// frame answering one carries its id.
+func TestConversationsOnOneConnection(t *testing.T) {
+	var mu sync.Mutex
+	var last OllamaRequest
+	mockOllama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/tags" {
+			http.NotFound(w, r)
+			return
+		}
+		var req OllamaRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		last = req
+		mu.Unlock()
+		w.Write([]byte(`{"message": {"content": "Noted"}, "done": false}` + "\n"))
+		w.Write([]byte(`{"done": true}` + "\n"))
+	}))
+	defer mockOllama.Close()
+
+	oldURL := OllamaAPIURL
+	OllamaAPIURL = mockOllama.URL
+	defer func() { OllamaAPIURL = oldURL }()
+
+	server := httptest.NewServer(http.HandlerFunc(handleWebSocket))
+	defer server.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+	ws, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("could not open websocket connection: %v", err)
+	}
+	defer ws.Close()
+
+	// send writes req, checks that every frame of the answer carries its
+	// conversation id, and returns the messages Ollama got for it.
+	send := func(req ChatRequest) []OllamaMessage {
+		if err := ws.WriteJSON(req); err != nil {
+			t.Fatalf("could not write json: %v", err)
+		}
+		ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+		for {
+			var resp StreamResponse
+			if err := ws.ReadJSON(&resp); err != nil {
+				t.Fatalf("Read failed or timed out: %v", err)
+			}
+			if resp.ConversationID != req.ConversationID {
+				t.Errorf("frame %+v answering conversation %q", resp, req.ConversationID)
+			}
+			if resp.Done || resp.Code != "" {
+				break
+			}
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		return last.Messages
+	}
+	contents := func(messages []OllamaMessage) string {
+		var parts []string
+		for _, m := range messages[1:] { // Skip the system prompt
+			parts = append(parts, m.Content)
+		}
+		return strings.Join(parts, "|")
+	}
+
+	send(ChatRequest{Message: "I am Ann", ConversationID: "tab-a"})
+	send(ChatRequest{Message: "I am Bob", ConversationID: "tab-b"})
+	send(ChatRequest{Message: "I am nobody"})
+	if got := contents(send(ChatRequest{Message: "Who am I?", ConversationID: "tab-a"})); got != "I am Ann|Noted|Who am I?" {
+		t.Errorf("tab-a: Ollama got %q", got)
+	}
+
+	send(ChatRequest{Type: MessageTypeReset, ConversationID: "tab-b"})
+	if got := contents(send(ChatRequest{Message: "Who am I?", ConversationID: "tab-b"})); got != "Who am I?" {
+		t.Errorf("tab-b after reset: Ollama got %q", got)
+	}
+	if got := contents(send(ChatRequest{Message: "Who am I?"})); got != "I am nobody|Noted|Who am I?" {
+		t.Errorf("default conversation: Ollama got %q", got)
+	}
+
+	if err := ws.WriteJSON(ChatRequest{Message: "Hi", ConversationID: "not valid!"}); err != nil {
+		t.Fatalf("could not write json: %v", err)
+	}
+	var resp StreamResponse
+	if err := ws.ReadJSON(&resp); err != nil || resp.Code != CodeInvalidRequest {
+		t.Errorf("invalid conversation_id: got %+v, err %v", resp, err)
+	}
+}
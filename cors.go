@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// CORSOrigins are the origins, such as "https://app.example.com", whose
+// pages may call the HTTP API from a browser; "*" allows any. Empty keeps
+// the API same-origin. It is set once in main.
+var CORSOrigins []string
+
+// CORSMethods and CORSHeaders are the methods and request headers that
+// preflight responses allow cross-origin calls to use. They are set once in
+// main.
+var (
+	CORSMethods = []string{http.MethodGet, http.MethodPost, http.MethodDelete}
+	CORSHeaders = []string{"Authorization", "Content-Type"}
+)
+
+// corsAllowed reports whether pages from origin may call the API.
+func corsAllowed(origin string) bool {
+	for _, allowed := range CORSOrigins {
+		if allowed == "*" || strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowCORS adds CORS headers to responses for allowed origins and answers
+// their preflight OPTIONS requests itself, before they reach requireAuth,
+// since browsers send preflights without the Authorization header. Other
+// origins get no CORS headers, so browsers keep blocking them.
+func allowCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		w.Header().Add("Vary", "Origin")
+		if origin == "" || !corsAllowed(origin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(CORSMethods, ", "))
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(CORSHeaders, ", "))
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
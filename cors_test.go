@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestCORS verifies that allowed origins get CORS headers and preflight
+// answers even on token-protected routes, and that others get neither.
+func TestCORS(t *testing.T) {
+	oldOrigins, oldToken := CORSOrigins, AuthToken
+	CORSOrigins, AuthToken = []string{"https://app.example.com"}, "secret"
+	defer func() { CORSOrigins, AuthToken = oldOrigins, oldToken }()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/models", requireAuth(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	handler := allowCORS(mux)
+
+	send := func(method, origin, auth string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/models", nil)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		if method == http.MethodOptions {
+			req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+			req.Header.Set("Access-Control-Request-Headers", "authorization")
+		}
+		if auth != "" {
+			req.Header.Set("Authorization", "Bearer "+auth)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := send(http.MethodOptions, "https://app.example.com", "")
+	if rr.Code != http.StatusNoContent || rr.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" ||
+		rr.Header().Get("Access-Control-Allow-Headers") != "Authorization, Content-Type" {
+		t.Errorf("preflight: got %d with headers %v", rr.Code, rr.Header())
+	}
+
+	rr = send(http.MethodGet, "https://app.example.com", "secret")
+	if rr.Code != http.StatusOK || rr.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" {
+		t.Errorf("allowed origin: got %d with headers %v", rr.Code, rr.Header())
+	}
+
+	for _, origin := range []string{"https://evil.example", ""} {
+		rr = send(http.MethodOptions, origin, "")
+		if rr.Header().Get("Access-Control-Allow-Origin") != "" || rr.Code != http.StatusUnauthorized {
+			t.Errorf("origin %q: got %d with headers %v, want no CORS", origin, rr.Code, rr.Header())
+		}
+	}
+
+	CORSOrigins = []string{"*"}
+	if rr = send(http.MethodGet, "https://anyone.example", "secret"); rr.Header().Get("Access-Control-Allow-Origin") != "https://anyone.example" {
+		t.Errorf("wildcard: got headers %v", rr.Header())
+	}
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Docs is the document store chats draw context from, or nil when -docs is
+// not set. It is set once in main.
+var Docs *DocStore
+
+// DocsTopK is how many passages are added to the system prompt of each
+// turn. It is set once in main.
+var DocsTopK = 3
+
+// EmbedModel is the Ollama model documents and messages are embedded with.
+// It is set once in main.
+var EmbedModel = "nomic-embed-text"
+
+// DocChunkSize is roughly how many bytes of a document go into one passage.
+// Passages break between paragraphs where they can.
+const DocChunkSize = 1000
+
+// MaxDocumentBytes caps the text of one uploaded document.
+const MaxDocumentBytes = 1 << 20
+
+// Document describes an uploaded document.
+type Document struct {
+	ID       string `json:"id"`
+	Title    string `json:"title"`
+	Passages int    `json:"passages"`
+}
+
+// DocumentRequest is the request body for uploading a document.
+type DocumentRequest struct {
+	Title string `json:"title"`
+	Text  string `json:"text"`
+}
+
+// DocumentList is the response body of listing documents.
+type DocumentList struct {
+	Documents []Document `json:"documents"`
+}
+
+// passage is a piece of a document with its embedding.
+type passage struct {
+	DocID     string    `json:"doc_id"`
+	Text      string    `json:"text"`
+	Embedding []float64 `json:"embedding"`
+}
+
+// DocStore keeps documents split into embedded passages, saved as one JSON
+// file so they survive a restart.
+type DocStore struct {
+	mu       sync.Mutex
+	path     string
+	docs     []Document
+	passages []passage
+}
+
+// docsFile is the layout of a DocStore's file.
+type docsFile struct {
+	Documents []Document `json:"documents"`
+	Passages  []passage  `json:"passages"`
+}
+
+// OpenDocStore returns the store saved at path, which need not exist yet.
+func OpenDocStore(path string) (*DocStore, error) {
+	s := &DocStore{path: path}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var file docsFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("decoding %s: %w", path, err)
+	}
+	s.docs, s.passages = file.Documents, file.Passages
+	return s, nil
+}
+
+// Add splits text into passages, embeds each with EmbedModel and saves the
+// document. Nothing is kept if any passage can't be embedded.
+func (s *DocStore) Add(ctx context.Context, title, text string) (Document, error) {
+	chunks := splitPassages(text, DocChunkSize)
+	if len(chunks) == 0 {
+		return Document{}, fmt.Errorf("the document has no text")
+	}
+	doc := Document{ID: newDocID(), Title: title, Passages: len(chunks)}
+	added := make([]passage, len(chunks))
+	for i, chunk := range chunks {
+		embedding, err := embedOllama(ctx, EmbedModel, chunk)
+		if err != nil {
+			return Document{}, err
+		}
+		added[i] = passage{DocID: doc.ID, Text: chunk, Embedding: embedding}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.docs = append(s.docs, doc)
+	s.passages = append(s.passages, added...)
+	if err := s.save(); err != nil {
+		s.docs, s.passages = s.docs[:len(s.docs)-1], s.passages[:len(s.passages)-len(added)]
+		return Document{}, err
+	}
+	return doc, nil
+}
+
+// List returns the stored documents, oldest first.
+func (s *DocStore) List() []Document {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.docs)
+}
+
+// Search returns the text of the k passages most similar to query.
+func (s *DocStore) Search(ctx context.Context, query string, k int) ([]string, error) {
+	s.mu.Lock()
+	empty := len(s.passages) == 0
+	s.mu.Unlock()
+	if empty || k <= 0 {
+		return nil, nil
+	}
+	embedding, err := embedOllama(ctx, EmbedModel, query)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	type scored struct {
+		text  string
+		score float64
+	}
+	ranked := make([]scored, len(s.passages))
+	for i, p := range s.passages {
+		ranked[i] = scored{p.Text, cosineSimilarity(embedding, p.Embedding)}
+	}
+	slices.SortStableFunc(ranked, func(a, b scored) int { return cmp.Compare(b.score, a.score) })
+
+	texts := make([]string, 0, k)
+	for _, r := range ranked[:min(k, len(ranked))] {
+		texts = append(texts, r.text)
+	}
+	return texts, nil
+}
+
+// save writes the store atomically, like FileStore.Save. The caller holds s.mu.
+func (s *DocStore) save() error {
+	data, err := json.Marshal(docsFile{Documents: s.docs, Passages: s.passages})
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // No-op once the rename succeeded
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// splitPassages cuts text into pieces of about size bytes, keeping whole
+// paragraphs together where they fit and splitting longer ones at spaces.
+func splitPassages(text string, size int) []string {
+	var passages []string
+	var current strings.Builder
+	flush := func() {
+		if current.Len() > 0 {
+			passages = append(passages, current.String())
+			current.Reset()
+		}
+	}
+	for _, para := range strings.Split(text, "\n\n") {
+		para = strings.TrimSpace(para)
+		for len(para) > size {
+			cut := strings.LastIndexByte(para[:size], ' ')
+			if cut <= 0 {
+				cut = size
+				for !utf8.RuneStart(para[cut]) {
+					cut-- // Don't split a character
+				}
+			}
+			flush()
+			passages = append(passages, strings.TrimSpace(para[:cut]))
+			para = strings.TrimSpace(para[cut:])
+		}
+		if para == "" {
+			continue
+		}
+		if current.Len() > 0 && current.Len()+len(para)+2 > size {
+			flush()
+		}
+		if current.Len() > 0 {
+			current.WriteString("\n\n")
+		}
+		current.WriteString(para)
+	}
+	flush()
+	return passages
+}
+
+// cosineSimilarity is 1 for vectors pointing the same way, 0 for unrelated
+// ones. Vectors of different lengths, from different models, score 0.
+func cosineSimilarity(a, b []float64) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += a[i] * b[i]
+		normA += a[i] * a[i]
+		normB += b[i] * b[i]
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(normA*normB)
+}
+
+// newDocID returns a random id for a document.
+func newDocID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// withDocuments adds the DocsTopK passages most relevant to prompt to
+// opts' system prompt. Without a document store, or if retrieval fails,
+// opts is returned as it was so the chat goes on without them.
+func withDocuments(ctx context.Context, opts SamplingOptions, prompt OllamaMessage) SamplingOptions {
+	if Docs == nil || prompt.Content == "" {
+		return opts
+	}
+	passages, err := Docs.Search(ctx, prompt.Content, DocsTopK)
+	if err != nil {
+		slog.Warn("📚 Could not search the documents", "error", err)
+		return opts
+	}
+	if len(passages) > 0 {
+		opts.Documents = "Use these passages from the user's documents if they help answer:\n\n" +
+			strings.Join(passages, "\n\n---\n\n")
+	}
+	return opts
+}
+
+// handleDocs lists the documents on GET and adds one on POST.
+func handleDocs(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, DocumentList{Documents: Docs.List()})
+	case http.MethodPost:
+		var req DocumentRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, MaxDocumentBytes+4096)).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if strings.TrimSpace(req.Text) == "" {
+			http.Error(w, "text is required", http.StatusBadRequest)
+			return
+		}
+		if len(req.Text) > MaxDocumentBytes {
+			http.Error(w, fmt.Sprintf("text is too long: %d bytes, at most %d", len(req.Text), MaxDocumentBytes), http.StatusBadRequest)
+			return
+		}
+
+		doc, err := Docs.Add(r.Context(), req.Title, sanitizeContent(req.Text))
+		switch {
+		case err == nil:
+			slog.Info("📚 Added document", "id", doc.ID, "title", doc.Title, "passages", doc.Passages)
+			writeJSON(w, http.StatusCreated, doc)
+		case errors.Is(err, ErrModelNotFound), errors.Is(err, ErrNoEmbedding), errors.Is(err, ErrOllamaFailed):
+			http.Error(w, fmt.Sprintf("could not embed the document with %s (%v); run `ollama pull %s` or pick another -embed-model", EmbedModel, err, EmbedModel),
+				http.StatusBadGateway)
+		default:
+			slog.Error("Document error", "error", err)
+			http.Error(w, "Could not add the document: "+err.Error(), http.StatusBadGateway)
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestSplitPassages verifies that short paragraphs are grouped, long ones
+// are split at spaces, and no words are lost.
+func TestSplitPassages(t *testing.T) {
+	long := strings.Repeat("word ", 50) // 250 bytes
+	text := "First.\n\nSecond.\n\n" + long + "\n\nLast."
+	passages := splitPassages(text, 100)
+
+	if passages[0] != "First.\n\nSecond." {
+		t.Errorf("first passage %q, want the short paragraphs together", passages[0])
+	}
+	for _, p := range passages {
+		if len(p) > 100 {
+			t.Errorf("passage of %d bytes, want at most 100", len(p))
+		}
+	}
+	if got, want := strings.Fields(strings.Join(passages, " ")), strings.Fields(text); len(got) != len(want) {
+		t.Errorf("got %d words, want %d", len(got), len(want))
+	}
+	if p := splitPassages(strings.Repeat("é", 80), 101); len(p) != 2 || !strings.HasPrefix(p[1], "é") {
+		t.Errorf("split %q inside a character", p)
+	}
+}
+
+// TestDocsAddRelevantPassages verifies that uploaded documents are listed,
+// survive reopening the store, and that a chat gets the passage closest to
+// its message added to the system prompt.
+func TestDocsAddRelevantPassages(t *testing.T) {
+	var mu sync.Mutex
+	var system string
+	mockOllama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/embeddings":
+			// One dimension per topic, so similarity follows the words used
+			var req struct{ Prompt string }
+			json.NewDecoder(r.Body).Decode(&req)
+			text := strings.ToLower(req.Prompt)
+			vector := []float64{float64(strings.Count(text, "cat")), float64(strings.Count(text, "rocket")), 0.1}
+			json.NewEncoder(w).Encode(map[string]interface{}{"embedding": vector})
+		case "/api/chat":
+			var req OllamaRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			mu.Lock()
+			system = req.Messages[0].Content
+			mu.Unlock()
+			w.Write([]byte(`{"message": {"content": "OK"}, "done": true}` + "\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer mockOllama.Close()
+
+	path := filepath.Join(t.TempDir(), "docs.json")
+	store, err := OpenDocStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldURL, oldDocs, oldTopK := OllamaAPIURL, Docs, DocsTopK
+	OllamaAPIURL, Docs, DocsTopK = mockOllama.URL+"/api/chat", store, 1
+	defer func() { OllamaAPIURL, Docs, DocsTopK = oldURL, oldDocs, oldTopK }()
+
+	for _, body := range []string{
+		`{"title": "Pets", "text": "Our cat sleeps all day. The cat likes fish."}`,
+		`{"title": "Space", "text": "The rocket launched at dawn. Rocket engines burn fuel."}`,
+	} {
+		rr := httptest.NewRecorder()
+		handleDocs(rr, httptest.NewRequest(http.MethodPost, "/api/docs", strings.NewReader(body)))
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("upload: got %d %s", rr.Code, rr.Body)
+		}
+	}
+
+	Docs, err = OpenDocStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	handleDocs(rr, httptest.NewRequest(http.MethodGet, "/api/docs", nil))
+	var list DocumentList
+	json.NewDecoder(rr.Body).Decode(&list)
+	if len(list.Documents) != 2 || list.Documents[1].Title != "Space" || list.Documents[1].Passages != 1 {
+		t.Errorf("after reopening: got %+v", list.Documents)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	var history []OllamaMessage
+	if _, err := chatOllama(ctx, OllamaMessage{Role: "user", Content: "When did the rocket go up?"}, &history, "m", Sampling); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !strings.HasPrefix(system, SystemPrompt) || !strings.Contains(system, "rocket launched") || strings.Contains(system, "cat") {
+		t.Errorf("got system prompt %q, want the default plus only the rocket passage", system)
+	}
+}
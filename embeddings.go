@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+)
+
+// MaxEmbeddingInput caps the text accepted for one embedding, in bytes.
+// Embedding models have small context windows, so longer input would be
+// silently truncated anyway.
+const MaxEmbeddingInput = 32 * 1024
+
+// ErrNoEmbedding means the model answered but is not an embedding model.
+var ErrNoEmbedding = errors.New("model did not produce an embedding")
+
+// EmbeddingRequest is the request body of the embeddings endpoint.
+type EmbeddingRequest struct {
+	Model string `json:"model"`
+	Input string `json:"input"`
+}
+
+// EmbeddingReply is the response body of the embeddings endpoint.
+type EmbeddingReply struct {
+	Model     string    `json:"model"`
+	Embedding []float64 `json:"embedding"`
+}
+
+// embedOllama asks Ollama's /api/embeddings endpoint for the vector of input.
+func embedOllama(ctx context.Context, model, input string) ([]float64, error) {
+	payload, _ := json.Marshal(map[string]string{"model": model, "prompt": input})
+	req, err := http.NewRequestWithContext(ctx, "POST", ollamaEndpoint("/api/embeddings"), bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := ollamaClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrOllamaUnreachable, err)
+	}
+	defer resp.Body.Close()
+	if err := checkOllamaStatus(resp); err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Embedding []float64 `json:"embedding"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("decoding embedding: %w", err)
+	}
+	if len(result.Embedding) == 0 {
+		return nil, ErrNoEmbedding
+	}
+	return result.Embedding, nil
+}
+
+// handleEmbeddings returns the embedding vector of the input text, for
+// building semantic search on top of this server.
+func handleEmbeddings(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req EmbeddingRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Input == "" {
+		http.Error(w, "input is required", http.StatusBadRequest)
+		return
+	}
+	if len(req.Input) > MaxEmbeddingInput {
+		http.Error(w, fmt.Sprintf("input is too long: %d bytes, at most %d", len(req.Input), MaxEmbeddingInput), http.StatusBadRequest)
+		return
+	}
+	model, err := resolveModel(req.Model)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	embedding, err := embedOllama(r.Context(), model, req.Input)
+	switch {
+	case err == nil:
+		writeJSON(w, http.StatusOK, EmbeddingReply{Model: model, Embedding: embedding})
+	case errors.Is(err, ErrModelNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrNoEmbedding), errors.Is(err, ErrOllamaFailed):
+		// Ollama answers chat-only models with an error or an empty vector
+		http.Error(w, fmt.Sprintf("%s can't produce embeddings (%v); try an embedding model such as nomic-embed-text", model, err),
+			http.StatusBadRequest)
+	default:
+		slog.Error("Embedding error", "model", model, "error", err)
+		http.Error(w, "Ollama request failed: "+err.Error(), http.StatusBadGateway)
+	}
+}
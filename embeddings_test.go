@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestEmbeddingsAPI verifies that the vector is proxied from Ollama's
+// embeddings endpoint with the input as its prompt.
+func TestEmbeddingsAPI(t *testing.T) {
+	var gotPath, gotPrompt string
+	mockOllama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Prompt string `json:"prompt"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		gotPath, gotPrompt = r.URL.Path, req.Prompt
+		w.Write([]byte(`{"embedding": [0.1, -0.2, 0.3]}`))
+	}))
+	defer mockOllama.Close()
+
+	oldURL := OllamaAPIURL
+	OllamaAPIURL = mockOllama.URL + "/api/chat"
+	defer func() { OllamaAPIURL = oldURL }()
+
+	body := `{"model": "nomic-embed-text", "input": "hello world"}`
+	rr := httptest.NewRecorder()
+	handleEmbeddings(rr, httptest.NewRequest(http.MethodPost, "/api/embeddings", strings.NewReader(body)))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", rr.Code, http.StatusOK, rr.Body.String())
+	}
+	var reply EmbeddingReply
+	if err := json.NewDecoder(rr.Body).Decode(&reply); err != nil {
+		t.Fatalf("could not decode reply: %v", err)
+	}
+	if len(reply.Embedding) != 3 || reply.Model != "nomic-embed-text" {
+		t.Errorf("got reply %+v", reply)
+	}
+	if gotPath != "/api/embeddings" || gotPrompt != "hello world" {
+		t.Errorf("Ollama got path %q and prompt %q", gotPath, gotPrompt)
+	}
+}
+
+// TestEmbeddingsAPIErrors verifies the status codes for bad input and for a
+// model that doesn't produce embeddings.
+func TestEmbeddingsAPIErrors(t *testing.T) {
+	mockOllama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"embedding": []}`))
+	}))
+	defer mockOllama.Close()
+
+	oldURL := OllamaAPIURL
+	OllamaAPIURL = mockOllama.URL
+	defer func() { OllamaAPIURL = oldURL }()
+
+	cases := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"bad json", `{`, http.StatusBadRequest},
+		{"empty input", `{"input": ""}`, http.StatusBadRequest},
+		{"input too long", `{"input": "` + strings.Repeat("a", MaxEmbeddingInput+1) + `"}`, http.StatusBadRequest},
+		{"not an embedding model", `{"model": "gemma3:1b", "input": "hi"}`, http.StatusBadRequest},
+	}
+	for _, tc := range cases {
+		rr := httptest.NewRecorder()
+		handleEmbeddings(rr, httptest.NewRequest(http.MethodPost, "/api/embeddings", strings.NewReader(tc.body)))
+		if rr.Code != tc.want {
+			t.Errorf("%s: got status %d, want %d", tc.name, rr.Code, tc.want)
+		}
+	}
+}
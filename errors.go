@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Errors returned by the Ollama calls. They wrap the underlying cause, so
+// check them with errors.Is.
+var (
+	ErrOllamaUnreachable = errors.New("ollama is unreachable")
+	ErrModelNotFound     = errors.New("model not found")
+	ErrStreamInterrupted = errors.New("stream interrupted")
+	ErrOllamaFailed      = errors.New("ollama request failed")
+	ErrTimeout           = errors.New("ollama timed out")
+	ErrClientGone        = errors.New("client stopped taking frames")
+)
+
+// Codes sent in the code field of WebSocket error frames, so the frontend
+// can tell failures apart without parsing the message.
+const (
+	CodeOllamaUnreachable = "ollama_unreachable"
+	CodeModelNotFound     = "model_not_found"
+	CodeStreamInterrupted = "stream_interrupted"
+	CodeOllamaError       = "ollama_error"
+	CodeTimeout           = "timeout"
+	CodeInvalidRequest    = "invalid_request"
+	CodeRateLimited       = "rate_limited"
+	CodeMessageTooLarge   = "message_too_large"
+	CodeBusy              = "busy"
+)
+
+// errorCode returns the frame code for an error from the Ollama calls.
+func errorCode(err error) string {
+	switch {
+	case errors.Is(err, ErrOllamaUnreachable):
+		return CodeOllamaUnreachable
+	case errors.Is(err, ErrModelNotFound):
+		return CodeModelNotFound
+	case errors.Is(err, ErrTimeout):
+		return CodeTimeout
+	case errors.Is(err, ErrStreamInterrupted):
+		return CodeStreamInterrupted
+	default:
+		return CodeOllamaError
+	}
+}
+
+// errorFrame is the final frame of a turn that failed.
+func errorFrame(code, message string) StreamResponse {
+	return StreamResponse{Chunk: "Error: " + message, Done: true, Code: code}
+}
+
+// busyFrame refuses a message sent while its conversation's reply is still
+// being generated, or while too many others are queued.
+func busyFrame() StreamResponse {
+	return errorFrame(CodeBusy, "a reply is still being generated; wait for it to finish or send stop first")
+}
+
+// ollamaErrorFrame is the error frame for a failed Ollama call, suggesting
+// another model when the requested one is missing.
+func ollamaErrorFrame(err error) StreamResponse {
+	frame := errorFrame(errorCode(err), err.Error())
+	var missing *MissingModelError
+	if errors.As(err, &missing) {
+		frame.Suggestion = missing.Suggestion
+	}
+	return frame
+}
+
+// checkOllamaStatus turns a non-200 Ollama response into an error, using
+// the message from Ollama's {"error": "..."} body when there is one.
+func checkOllamaStatus(resp *http.Response) error {
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	message := strings.TrimSpace(string(body))
+	var apiErr struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(body, &apiErr) == nil && apiErr.Error != "" {
+		message = apiErr.Error
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: %s", ErrModelNotFound, message)
+	}
+	return fmt.Errorf("%w: ollama returned %s: %s", ErrOllamaFailed, resp.Status, message)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// closedServerURL returns the address of a server that no longer listens.
+func closedServerURL() string {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+	return server.URL
+}
+
+// TestOllamaErrorTypes verifies that each failure mode maps to its typed error.
+func TestOllamaErrorTypes(t *testing.T) {
+	oldURL, oldRetries := OllamaAPIURL, OllamaRetries
+	OllamaRetries = 0
+	defer func() { OllamaAPIURL, OllamaRetries = oldURL, oldRetries }()
+
+	notFound := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error": "model 'nope' not found"}`, http.StatusNotFound)
+	}))
+	defer notFound.Close()
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "boom", http.StatusInternalServerError)
+	}))
+	defer broken.Close()
+
+	cases := []struct {
+		name string
+		url  string
+		want error
+	}{
+		{"unreachable", closedServerURL(), ErrOllamaUnreachable},
+		{"model not found", notFound.URL, ErrModelNotFound},
+		{"server error", broken.URL, ErrOllamaFailed},
+	}
+	for _, tc := range cases {
+		OllamaAPIURL = tc.url
+		var history []OllamaMessage
+		_, err := chatOllama(context.Background(), OllamaMessage{Role: "user", Content: "Hi"}, &history, "nope", Sampling)
+		if !errors.Is(err, tc.want) {
+			t.Errorf("%s: got error %v, want %v", tc.name, err, tc.want)
+		}
+	}
+}
+
+// TestWebSocketErrorCodes verifies that error frames carry the code of the
+// failure, including a stream Ollama cut off before its final line.
+func TestWebSocketErrorCodes(t *testing.T) {
+	oldURL, oldRetries := OllamaAPIURL, OllamaRetries
+	OllamaRetries = 0
+	defer func() { OllamaAPIURL, OllamaRetries = oldURL, oldRetries }()
+
+	notFound := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error": "model not found"}`, http.StatusNotFound)
+	}))
+	defer notFound.Close()
+	cutOff := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"message": {"content": "Hel"}}` + "\n")) // No done line follows
+	}))
+	defer cutOff.Close()
+
+	server := httptest.NewServer(http.HandlerFunc(handleWebSocket))
+	defer server.Close()
+
+	cases := []struct {
+		name string
+		url  string
+		want string
+	}{
+		{"unreachable", closedServerURL(), CodeOllamaUnreachable},
+		{"model not found", notFound.URL, CodeModelNotFound},
+		{"interrupted", cutOff.URL, CodeStreamInterrupted},
+	}
+	for _, tc := range cases {
+		OllamaAPIURL = tc.url
+
+		wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+		ws, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+		if err != nil {
+			t.Fatalf("could not open websocket connection: %v", err)
+		}
+		if err := ws.WriteJSON(ChatRequest{Message: "Hi"}); err != nil {
+			t.Fatalf("could not write json: %v", err)
+		}
+
+		ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+		var resp StreamResponse
+		for !resp.Done {
+			if err := ws.ReadJSON(&resp); err != nil {
+				t.Fatalf("%s: read failed: %v", tc.name, err)
+			}
+		}
+		ws.Close()
+
+		if resp.Code != tc.want {
+			t.Errorf("%s: got code %q, want %q", tc.name, resp.Code, tc.want)
+		}
+	}
+}
+
+// TestStreamReportsOllamaStatus verifies that a 404 or 500 from Ollama fails
+// the stream with Ollama's own message instead of feeding the error body to
+// the chunk parser, and that no frames reach the client.
+func TestStreamReportsOllamaStatus(t *testing.T) {
+	oldURL := OllamaAPIURL
+	defer func() { OllamaAPIURL = oldURL }()
+
+	cases := []struct {
+		name   string
+		status int
+		body   string
+		want   error
+		text   string
+	}{
+		{"not found", http.StatusNotFound, `{"error": "model \"llama9\" not found, try pulling it first"}`, ErrModelNotFound, `model "llama9" not found, try pulling it first`},
+		{"server error", http.StatusInternalServerError, "out of memory", ErrOllamaFailed, "500 Internal Server Error: out of memory"},
+	}
+	for _, tc := range cases {
+		mockOllama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, tc.body, tc.status)
+		}))
+		OllamaAPIURL = mockOllama.URL
+
+		var frames int
+		out := frameFunc(func(v interface{}) error { frames++; return nil })
+		var history []OllamaMessage
+		err := streamOllama(context.Background(), out, OllamaMessage{Role: "user", Content: "Hi"}, &history, "llama9", Sampling)
+		mockOllama.Close()
+
+		if !errors.Is(err, tc.want) || !strings.Contains(err.Error(), tc.text) {
+			t.Errorf("%s: got error %v, want %v with %q", tc.name, err, tc.want, tc.text)
+		}
+		if frames != 0 || len(history) != 0 {
+			t.Errorf("%s: sent %d frames and kept %d messages, want none", tc.name, frames, len(history))
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// SessionExport is the JSON form of an exported conversation.
+type SessionExport struct {
+	SessionID string    `json:"session_id"`
+	Messages  []Message `json:"messages"`
+}
+
+// roleHeadings are the Markdown headings for each message role.
+var roleHeadings = map[string]string{
+	"user":      "🧑 User",
+	"assistant": "🤖 Assistant",
+	"system":    "⚙️ System",
+}
+
+// renderMarkdown writes history as a Markdown transcript. Message content is
+// kept as is, so the model's own code fences survive; a fence a cut-off reply
+// left open is closed so it doesn't swallow the messages after it.
+func renderMarkdown(id string, history []OllamaMessage) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Chat %s\n", id)
+
+	for _, msg := range history {
+		heading, ok := roleHeadings[msg.Role]
+		if !ok {
+			heading = msg.Role
+		}
+		if !msg.Time.IsZero() {
+			heading += " · " + msg.Time.Format(time.DateTime)
+		}
+		fmt.Fprintf(&b, "\n## %s\n\n", heading)
+		if len(msg.Images) > 0 {
+			fmt.Fprintf(&b, "*%d image(s) attached*\n\n", len(msg.Images))
+		}
+
+		content := strings.TrimRight(msg.Content, "\n")
+		b.WriteString(content)
+		b.WriteString("\n")
+		if openFence(content) {
+			b.WriteString("```\n")
+		}
+	}
+	return b.String()
+}
+
+// openFence reports whether text has an odd number of ``` fence lines.
+func openFence(text string) bool {
+	open := false
+	for _, line := range strings.Split(text, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			open = !open
+		}
+	}
+	return open
+}
+
+// handleExport downloads a session's conversation as Markdown (format=md,
+// the default) or JSON (format=json).
+func handleExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := r.URL.Query().Get("session_id")
+	if !validSessionID(id) {
+		http.Error(w, "a valid session_id is required", http.StatusBadRequest)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "md"
+	}
+	if format != "md" && format != "json" {
+		http.Error(w, "format must be md or json", http.StatusBadRequest)
+		return
+	}
+
+	history := sessions.Full(id)
+	if len(history) == 0 {
+		http.Error(w, "session not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="chat-%s.%s"`, id, format))
+	if format == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(SessionExport{SessionID: id, Messages: timedMessages(history)})
+		return
+	}
+	w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+	fmt.Fprint(w, renderMarkdown(id, history))
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestExportMarkdown verifies role headings, untouched code fences and that
+// a fence left open by a cut-off reply is closed.
+func TestExportMarkdown(t *testing.T) {
+	history := []OllamaMessage{
+		{Role: "user", Content: "Show me Go"},
+		{Role: "assistant", Content: "Here:\n```go\nfmt.Println(1)\n```\n"},
+		{Role: "user", Content: "More"},
+		{Role: "assistant", Content: "```go\nfmt.Println("},
+	}
+	md := renderMarkdown("abc", history)
+
+	for _, want := range []string{"# Chat abc\n", "## 🧑 User\n\nShow me Go\n", "```go\nfmt.Println(1)\n```\n", "## 🤖 Assistant"} {
+		if !strings.Contains(md, want) {
+			t.Errorf("markdown is missing %q:\n%s", want, md)
+		}
+	}
+	if !strings.HasSuffix(md, "fmt.Println(\n```\n") {
+		t.Errorf("open fence was not closed:\n%s", md)
+	}
+}
+
+// TestExportAPI verifies both formats are served as downloads, and the
+// errors for missing sessions and bad formats.
+func TestExportAPI(t *testing.T) {
+	oldSessions := sessions
+	sessions = NewSessionStore(time.Minute, nil)
+	defer func() { sessions = oldSessions }()
+	sessions.Save("abc", []OllamaMessage{{Role: "user", Content: "Hi"}, {Role: "assistant", Content: "Yo"}})
+
+	rr := httptest.NewRecorder()
+	handleExport(rr, httptest.NewRequest(http.MethodGet, "/api/export?session_id=abc&format=json", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", rr.Code, http.StatusOK)
+	}
+	if got := rr.Header().Get("Content-Disposition"); got != `attachment; filename="chat-abc.json"` {
+		t.Errorf("got Content-Disposition %q", got)
+	}
+	var export SessionExport
+	if err := json.NewDecoder(rr.Body).Decode(&export); err != nil || len(export.Messages) != 2 {
+		t.Errorf("got export %+v, err %v", export, err)
+	}
+
+	rr = httptest.NewRecorder()
+	handleExport(rr, httptest.NewRequest(http.MethodGet, "/api/export?session_id=abc", nil))
+	if !strings.HasPrefix(rr.Header().Get("Content-Type"), "text/markdown") || !strings.Contains(rr.Body.String(), "Yo") {
+		t.Errorf("default format is not Markdown: %s", rr.Body.String())
+	}
+
+	cases := []struct {
+		name   string
+		target string
+		want   int
+	}{
+		{"no session", "/api/export", http.StatusBadRequest},
+		{"unknown session", "/api/export?session_id=nope", http.StatusNotFound},
+		{"bad format", "/api/export?session_id=abc&format=pdf", http.StatusBadRequest},
+	}
+	for _, tc := range cases {
+		rr := httptest.NewRecorder()
+		handleExport(rr, httptest.NewRequest(http.MethodGet, tc.target, nil))
+		if rr.Code != tc.want {
+			t.Errorf("%s: got status %d, want %d", tc.name, rr.Code, tc.want)
+		}
+	}
+}
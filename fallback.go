@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+)
+
+// FallbackModels are tried in order when a reply's model fails before
+// answering, because it is missing or Ollama can't run it, e.g. for lack
+// of memory. It is set once in main.
+var FallbackModels []string
+
+// modelChain returns model followed by the FallbackModels to try after it.
+func modelChain(model string) []string {
+	chain := []string{model}
+	for _, m := range FallbackModels {
+		if !slices.ContainsFunc(chain, func(c string) bool { return sameModel(c, m) }) {
+			chain = append(chain, m)
+		}
+	}
+	return chain
+}
+
+// installedChain returns the modelChain of model without the models
+// requireModel knows to be missing, so those fail over before the reply
+// queues for a generation slot. With none left, it returns the error for
+// model.
+func installedChain(ctx context.Context, model string) ([]string, error) {
+	var installed []string
+	var firstErr error
+	for _, m := range modelChain(model) {
+		err := requireModel(ctx, m)
+		if err == nil {
+			installed = append(installed, m)
+			continue
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		if m != model {
+			slog.Warn("↪️  Fallback model is not installed, skipping it", "model", m)
+		}
+	}
+	if len(installed) == 0 {
+		return nil, firstErr
+	}
+	if installed[0] != model {
+		slog.Warn("↪️  Model is not installed, falling back", "model", model, "fallback", installed[0])
+	}
+	return installed, nil
+}
+
+// canFallBack reports whether a model that failed with err should give way
+// to the next one in the chain: it wasn't found, or Ollama answered with an
+// error instead of a reply. Other failures, such as Ollama being
+// unreachable, would fail every model alike.
+func canFallBack(err error) bool {
+	return errors.Is(err, ErrModelNotFound) || errors.Is(err, ErrOllamaFailed)
+}
+
+// validateModelNames checks names the way resolveModel checks a request's model.
+func validateModelNames(names []string) error {
+	for _, name := range names {
+		if !modelNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid model name %q", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fallbackOllamaServer lists installed as its models and answers chats
+// with "From <model>", except that "oom" fails with a 500 and "flaky"
+// breaks off after its first chunk. It records the models asked to chat.
+func fallbackOllamaServer(installed []string, mu *sync.Mutex, asked *[]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/tags" {
+			var list struct {
+				Models []ModelInfo `json:"models"`
+			}
+			for _, name := range installed {
+				list.Models = append(list.Models, ModelInfo{Name: name})
+			}
+			json.NewEncoder(w).Encode(list)
+			return
+		}
+		var req OllamaRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		*asked = append(*asked, req.Model)
+		mu.Unlock()
+		switch req.Model {
+		case "oom":
+			http.Error(w, `{"error": "model requires more system memory than is available"}`, http.StatusInternalServerError)
+		case "flaky":
+			w.Write([]byte(`{"message": {"content": "Half"}}` + "\n"))
+		default:
+			json.NewEncoder(w).Encode(map[string]interface{}{"message": OllamaMessage{Role: "assistant", Content: "From " + req.Model}, "done": true})
+		}
+	}))
+}
+
+// TestModelChain verifies that fallbacks follow the model, without repeats.
+func TestModelChain(t *testing.T) {
+	oldFallbacks := FallbackModels
+	FallbackModels = []string{"llama3.2:latest", "qwen3", "qwen3"}
+	defer func() { FallbackModels = oldFallbacks }()
+
+	if got, want := modelChain("llama3.2"), []string{"llama3.2", "qwen3"}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+// TestStreamFallsBack verifies that a reply moves down the chain past
+// models that are missing or fail before answering, and that the final
+// frame names the model that answered.
+func TestStreamFallsBack(t *testing.T) {
+	var mu sync.Mutex
+	var asked []string
+	mockOllama := fallbackOllamaServer([]string{"oom:latest", "small:latest"}, &mu, &asked)
+	defer mockOllama.Close()
+
+	oldURL, oldCache, oldFallbacks := OllamaAPIURL, availableModels, FallbackModels
+	OllamaAPIURL, availableModels, FallbackModels = mockOllama.URL, &modelCache{ttl: time.Minute}, []string{"missing", "small"}
+	defer func() { OllamaAPIURL, availableModels, FallbackModels = oldURL, oldCache, oldFallbacks }()
+
+	var final StreamResponse
+	out := frameFunc(func(v interface{}) error {
+		if frame := v.(StreamResponse); frame.Done {
+			final = frame
+		}
+		return nil
+	})
+	var history []OllamaMessage
+	err := streamOllama(context.Background(), out, OllamaMessage{Role: "user", Content: "Hi"}, &history, "oom", Sampling)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if final.Model != "small" || history[len(history)-1].Content != "From small" {
+		t.Errorf("got final frame %+v and history %+v, want the reply from small", final, history)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []string{"oom", "small"}; !slices.Equal(asked, want) {
+		t.Errorf("asked %v to chat, want %v", asked, want)
+	}
+}
+
+// TestStreamNoFallbackMidStream verifies that a reply that broke off after
+// sending text fails rather than continuing with another model.
+func TestStreamNoFallbackMidStream(t *testing.T) {
+	var mu sync.Mutex
+	var asked []string
+	mockOllama := fallbackOllamaServer(nil, &mu, &asked)
+	defer mockOllama.Close()
+
+	oldURL, oldCache, oldFallbacks, oldRetries := OllamaAPIURL, availableModels, FallbackModels, StreamRetries
+	OllamaAPIURL, availableModels, FallbackModels, StreamRetries = mockOllama.URL, &modelCache{ttl: time.Minute}, []string{"small"}, 0
+	defer func() {
+		OllamaAPIURL, availableModels, FallbackModels, StreamRetries = oldURL, oldCache, oldFallbacks, oldRetries
+	}()
+
+	var history []OllamaMessage
+	err := streamOllama(context.Background(), frameFunc(func(interface{}) error { return nil }), OllamaMessage{Role: "user", Content: "Hi"}, &history, "flaky", Sampling)
+	if !errors.Is(err, ErrStreamInterrupted) {
+		t.Errorf("got %v, want ErrStreamInterrupted", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []string{"flaky"}; !slices.Equal(asked, want) {
+		t.Errorf("asked %v to chat, want %v", asked, want)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FileStore persists each session's history as <dir>/<session id>.json so
+// conversations survive a server restart.
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore returns a store writing to dir, creating it if needed.
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("creating history directory: %w", err)
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+func (f *FileStore) path(id string) string {
+	return filepath.Join(f.dir, id+".json")
+}
+
+// Save writes history atomically: it goes to a temp file that is renamed
+// over the old one, so a crash mid-write never leaves a truncated file.
+func (f *FileStore) Save(id string, history []OllamaMessage) error {
+	data, err := json.Marshal(timedMessages(history))
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(f.dir, id+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // No-op once the rename succeeded
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.path(id))
+}
+
+// Load reads the history for id. A session that was never saved has no
+// history and no error.
+func (f *FileStore) Load(id string) ([]OllamaMessage, error) {
+	data, err := os.ReadFile(f.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var history []Message
+	if err := json.Unmarshal(data, &history); err != nil {
+		return nil, fmt.Errorf("decoding %s: %w", f.path(id), err)
+	}
+	return untimedMessages(history), nil
+}
+
+// Append rewrites the session file with msgs added to the end.
+func (f *FileStore) Append(id string, msgs ...OllamaMessage) error {
+	history, err := f.Load(id)
+	if err != nil {
+		return err
+	}
+	return f.Save(id, append(history, msgs...))
+}
+
+// DropLast rewrites the session file without its last n messages.
+func (f *FileStore) DropLast(id string, n int) error {
+	history, err := f.Load(id)
+	if err != nil {
+		return err
+	}
+	return f.Save(id, history[:max(len(history)-n, 0)])
+}
+
+// Delete removes the session file. Deleting an unknown session is not an error.
+func (f *FileStore) Delete(id string) error {
+	err := os.Remove(f.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// LoadAll reads every saved session, keyed by session id.
+func (f *FileStore) LoadAll() (map[string][]OllamaMessage, error) {
+	files, err := filepath.Glob(filepath.Join(f.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	all := make(map[string][]OllamaMessage, len(files))
+	for _, file := range files {
+		id := strings.TrimSuffix(filepath.Base(file), ".json")
+		history, err := f.Load(id)
+		if err != nil {
+			return nil, err
+		}
+		all[id] = history
+	}
+	return all, nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestFileStorePersistsAcrossRestart verifies that a session saved by one
+// store is restored by a fresh store pointed at the same directory.
+func TestFileStorePersistsAcrossRestart(t *testing.T) {
+	dir := t.TempDir()
+
+	fs, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := NewSessionStore(time.Minute, fs)
+	before.Save("abc", []OllamaMessage{
+		{Role: "user", Content: "hi"},
+		{Role: "assistant", Content: "yo"},
+	})
+
+	// No temp files should be left behind by the atomic write.
+	if tmps, _ := filepath.Glob(filepath.Join(dir, "*.tmp")); len(tmps) != 0 {
+		t.Errorf("leftover temp files: %v", tmps)
+	}
+
+	// "Restart" with a new store over the same directory.
+	after := NewSessionStore(time.Minute, fs)
+	n, err := after.Restore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("restored %d sessions, want 1", n)
+	}
+	if got := after.Load("abc"); len(got) != 2 || got[1].Content != "yo" {
+		t.Errorf("restored history %+v, want the saved messages", got)
+	}
+}
+
+// TestSessionStoreReloadsEvictedSession verifies that a session evicted from
+// memory is read back from disk on its next use.
+func TestSessionStoreReloadsEvictedSession(t *testing.T) {
+	fs, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewSessionStore(time.Minute, fs)
+	store.Save("abc", []OllamaMessage{{Role: "user", Content: "hi"}})
+
+	store.evictIdle(time.Now().Add(2 * time.Minute))
+	if store.Len() != 0 {
+		t.Fatal("session should have been evicted from memory")
+	}
+
+	if got := store.Load("abc"); len(got) != 1 {
+		t.Errorf("got %d messages after eviction, want 1 from disk", len(got))
+	}
+}
+
+// TestFileStoreLoadMissing verifies that an unknown session has no history and no error.
+func TestFileStoreLoadMissing(t *testing.T) {
+	fs, err := NewFileStore(filepath.Join(t.TempDir(), "nested"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(fs.dir); err != nil {
+		t.Errorf("directory was not created: %v", err)
+	}
+
+	history, err := fs.Load("missing")
+	if err != nil || history != nil {
+		t.Errorf("Load(missing) = %v, %v; want nil, nil", history, err)
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+)
+
+// FormatJSON asks Ollama for any valid JSON. A request's format can instead
+// be a JSON Schema object the reply must follow.
+const FormatJSON = `"json"`
+
+// validateFormat checks that a request's format, if set, is "json" or a
+// JSON Schema object.
+func validateFormat(format json.RawMessage) error {
+	if len(format) == 0 || string(format) == "null" || string(format) == FormatJSON {
+		return nil
+	}
+	var schema map[string]json.RawMessage
+	if err := json.Unmarshal(format, &schema); err != nil || schema == nil {
+		return fmt.Errorf(`format must be "json" or a JSON Schema object, got %s`, format)
+	}
+	return nil
+}
+
+// invalidJSON reports whether a reply generated with format set fails to
+// parse, as happens when it is cut off by the token limit or stopped early.
+func invalidJSON(format json.RawMessage, model, reply string) bool {
+	if len(format) == 0 || json.Valid([]byte(reply)) {
+		return false
+	}
+	slog.Warn("🧾 Reply asked to be JSON does not parse", "model", model, "length", len(reply))
+	return true
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestValidateFormat verifies that only "json" and schema objects are
+// accepted as a format.
+func TestValidateFormat(t *testing.T) {
+	for _, format := range []string{``, `null`, `"json"`, `{"type": "object", "properties": {"name": {"type": "string"}}}`} {
+		if err := validateFormat(json.RawMessage(format)); err != nil {
+			t.Errorf("format %s: %v", format, err)
+		}
+	}
+	for _, format := range []string{`"xml"`, `42`, `["json"]`} {
+		if err := validateFormat(json.RawMessage(format)); err == nil {
+			t.Errorf("format %s: got no error", format)
+		}
+	}
+}
+
+// TestFormatJSON verifies that a request's format reaches Ollama and that
+// replies that don't parse as JSON are flagged, streamed or not.
+func TestFormatJSON(t *testing.T) {
+	var format json.RawMessage
+	reply := `{"name": "Ann"}`
+	mockOllama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/tags" {
+			http.NotFound(w, r)
+			return
+		}
+		var req OllamaRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		format = req.Format
+		json.NewEncoder(w).Encode(map[string]interface{}{"message": OllamaMessage{Role: "assistant", Content: reply}, "done": true})
+	}))
+	defer mockOllama.Close()
+
+	oldURL := OllamaAPIURL
+	OllamaAPIURL = mockOllama.URL
+	defer func() { OllamaAPIURL = oldURL }()
+
+	schema := `{"type":"object","properties":{"name":{"type":"string"}}}`
+	rr := httptest.NewRecorder()
+	handleChatAPI(rr, httptest.NewRequest(http.MethodPost, "/api/chat", strings.NewReader(`{"message": "Who?", "format": `+schema+`}`)))
+	var got ChatReply
+	json.NewDecoder(rr.Body).Decode(&got)
+	if rr.Code != http.StatusOK || got.Reply != reply || got.InvalidJSON {
+		t.Errorf("valid reply: got %d %+v", rr.Code, got)
+	}
+	if string(format) != schema {
+		t.Errorf("Ollama got format %s, want %s", format, schema)
+	}
+
+	reply = `{"name": "An`
+	req := ChatRequest{Message: "Who?", Format: json.RawMessage(FormatJSON)}
+	opts, err := req.options()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var final StreamResponse
+	out := frameFunc(func(v interface{}) error {
+		if frame := v.(StreamResponse); frame.Done {
+			final = frame
+		}
+		return nil
+	})
+	var history []OllamaMessage
+	if err := streamOllama(context.Background(), out, req.prompt(), &history, "m", opts); err != nil {
+		t.Fatal(err)
+	}
+	if !final.InvalidJSON {
+		t.Errorf("cut-off reply: got final frame %+v, want it flagged", final)
+	}
+	if string(format) != FormatJSON {
+		t.Errorf("Ollama got format %s, want %s", format, FormatJSON)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// GenerateRequest is the request body of the completion endpoint: a raw
+// prompt with no chat history, system prompt or session.
+type GenerateRequest struct {
+	Prompt    string   `json:"prompt"`
+	Model     string   `json:"model,omitempty"`  // Overrides OllamaModel
+	System    string   `json:"system,omitempty"` // Optional; none is sent by default
+	Stop      []string `json:"stop,omitempty"`
+	MaxTokens int      `json:"max_tokens,omitempty"`
+}
+
+// OllamaGenerateRequest is the payload for Ollama's /api/generate endpoint.
+type OllamaGenerateRequest struct {
+	Model   string                 `json:"model"`
+	Prompt  string                 `json:"prompt"`
+	System  string                 `json:"system,omitempty"`
+	Stream  bool                   `json:"stream"`
+	Options map[string]interface{} `json:"options,omitempty"`
+	// KeepAlive is how long the model stays loaded afterwards; see KeepAlive
+	KeepAlive interface{} `json:"keep_alive,omitempty"`
+}
+
+// OllamaGenerateChunk is one line of Ollama's streamed /api/generate response.
+type OllamaGenerateChunk struct {
+	Response   string `json:"response"`
+	Done       bool   `json:"done"`
+	DoneReason string `json:"done_reason,omitempty"`
+	GenerationStats
+}
+
+// handleGenerate streams a single-shot completion of the prompt as
+// newline-delimited StreamResponse frames, the same frames the WebSocket
+// sends. It suits code completion and templating, where chat framing and
+// history only get in the way.
+func handleGenerate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req GenerateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Prompt == "" {
+		http.Error(w, "prompt is required", http.StatusBadRequest)
+		return
+	}
+	model, err := resolveModel(req.Model)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	opts, err := requestOptions(req.Stop, req.MaxTokens)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	release, err := acquireGeneration(ctx, nil)
+	if err != nil {
+		return // The client went away while queued
+	}
+	defer release()
+
+	genCtx, touch, cancel := generationContext(ctx)
+	defer cancel()
+
+	messagesTotal.WithLabelValues(model).Inc()
+	start := time.Now()
+	resp, err := postOllamaTo(genCtx, ollamaEndpoint("/api/generate"), OllamaGenerateRequest{
+		Model:     model,
+		Prompt:    req.Prompt,
+		System:    req.System,
+		Stream:    true,
+		Options:   opts.Map(),
+		KeepAlive: KeepAlive,
+	})
+	if err == nil {
+		defer resp.Body.Close()
+		err = checkOllamaStatus(resp)
+	}
+	if err != nil {
+		if timeout := timeoutCause(genCtx); timeout != nil {
+			err = timeout
+		}
+		if ctx.Err() == nil {
+			ollamaFailures.WithLabelValues(model).Inc()
+			slog.Error("Ollama error", "model", model, "error", err)
+		}
+		status := http.StatusBadGateway
+		if errors.Is(err, ErrModelNotFound) {
+			status = http.StatusNotFound
+		} else if errors.Is(err, ErrTimeout) {
+			status = http.StatusGatewayTimeout
+		}
+		http.Error(w, "Ollama request failed: "+err.Error(), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+	send := func(frame StreamResponse) {
+		enc.Encode(frame)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), MaxStreamLine)
+	for scanner.Scan() {
+		touch()
+		var chunk OllamaGenerateChunk
+		if err := json.Unmarshal(scanner.Bytes(), &chunk); err != nil {
+			continue
+		}
+		if chunk.Response != "" {
+			send(StreamResponse{Chunk: chunk.Response})
+		}
+		if chunk.Done {
+			generationSeconds.WithLabelValues(model).Observe(time.Since(start).Seconds())
+			send(StreamResponse{Done: true, Stats: &chunk.GenerationStats, Truncated: chunk.DoneReason == "length"})
+			return
+		}
+	}
+
+	if ctx.Err() != nil {
+		return
+	}
+	if err = timeoutCause(genCtx); err == nil {
+		if err = scanner.Err(); err == nil {
+			err = io.ErrUnexpectedEOF
+		}
+		err = fmt.Errorf("%w: %w", ErrStreamInterrupted, err)
+	}
+	ollamaFailures.WithLabelValues(model).Inc()
+	slog.Error("Ollama error", "model", model, "error", err)
+	send(ollamaErrorFrame(err))
+}
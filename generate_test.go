@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestGenerateAPI verifies that the raw prompt goes to Ollama's
+// /api/generate without history and that the response streams back as
+// frames ending with a done frame.
+func TestGenerateAPI(t *testing.T) {
+	var got OllamaGenerateRequest
+	var gotPath string
+	mockOllama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Write([]byte(`{"response": "func main() "}` + "\n"))
+		w.Write([]byte(`{"response": "{}"}` + "\n"))
+		w.Write([]byte(`{"response": "", "done": true, "done_reason": "stop", "eval_count": 2}` + "\n"))
+	}))
+	defer mockOllama.Close()
+
+	oldURL := OllamaAPIURL
+	OllamaAPIURL = mockOllama.URL + "/api/chat"
+	defer func() { OllamaAPIURL = oldURL }()
+
+	body := `{"prompt": "package main\n\n", "stop": ["\n\n"]}`
+	rr := httptest.NewRecorder()
+	handleGenerate(rr, httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(body)))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", rr.Code, http.StatusOK, rr.Body.String())
+	}
+	if gotPath != "/api/generate" || got.Prompt != "package main\n\n" || got.System != "" {
+		t.Errorf("Ollama got path %q and request %+v", gotPath, got)
+	}
+
+	var text strings.Builder
+	var last StreamResponse
+	scanner := bufio.NewScanner(rr.Body)
+	for scanner.Scan() {
+		last = StreamResponse{}
+		if err := json.Unmarshal(scanner.Bytes(), &last); err != nil {
+			t.Fatalf("invalid frame %q: %v", scanner.Text(), err)
+		}
+		text.WriteString(last.Chunk)
+	}
+	if text.String() != "func main() {}" {
+		t.Errorf("got completion %q", text.String())
+	}
+	if !last.Done || last.Stats == nil || last.Stats.EvalCount != 2 {
+		t.Errorf("got final frame %+v", last)
+	}
+}
+
+// TestGenerateAPIErrors verifies the status codes for bad input.
+func TestGenerateAPIErrors(t *testing.T) {
+	cases := []struct {
+		name   string
+		method string
+		body   string
+		want   int
+	}{
+		{"wrong method", http.MethodGet, "", http.StatusMethodNotAllowed},
+		{"bad json", http.MethodPost, `{`, http.StatusBadRequest},
+		{"empty prompt", http.MethodPost, `{"prompt": ""}`, http.StatusBadRequest},
+		{"bad model", http.MethodPost, `{"prompt": "hi", "model": "../etc"}`, http.StatusBadRequest},
+	}
+	for _, tc := range cases {
+		rr := httptest.NewRecorder()
+		handleGenerate(rr, httptest.NewRequest(tc.method, "/api/generate", strings.NewReader(tc.body)))
+		if rr.Code != tc.want {
+			t.Errorf("%s: got status %d, want %d", tc.name, rr.Code, tc.want)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// OllamaHeaders are added to every request to Ollama, for deployments
+// behind a proxy that wants an API key or similar. It is set once in main.
+var OllamaHeaders http.Header
+
+// headerNamePattern matches the characters RFC 9110 allows in a field name.
+var headerNamePattern = regexp.MustCompile("^[!#$%&'*+.^_`|~0-9A-Za-z-]+$")
+
+// headerList is the repeatable -ollama-header flag. It shows only the
+// header names, since the values are usually credentials.
+type headerList []string
+
+func (h *headerList) String() string {
+	if h == nil {
+		return ""
+	}
+	var masked []string
+	for _, line := range *h {
+		name, _, _ := strings.Cut(line, ":")
+		masked = append(masked, strings.TrimSpace(name)+": ********")
+	}
+	return strings.Join(masked, ", ")
+}
+
+func (h *headerList) Set(line string) error {
+	*h = append(*h, line)
+	return nil
+}
+
+// repeatable makes a list in a config file set the flag once per item.
+func (h *headerList) repeatable() {}
+
+// parseHeaders turns "Name: value" lines into a header, rejecting names
+// that aren't valid and values that would split the request.
+func parseHeaders(lines []string) (http.Header, error) {
+	header := make(http.Header)
+	for _, line := range lines {
+		name, value, ok := strings.Cut(line, ":")
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		if !ok || !headerNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("expected \"Name: value\", got %q", line)
+		}
+		if strings.ContainsAny(value, "\r\n\x00") {
+			return nil, fmt.Errorf("the value of %s must not contain line breaks", name)
+		}
+		header.Add(name, value)
+	}
+	return header, nil
+}
+
+// headerTransport adds header to every request it sends through base, or
+// through http.DefaultTransport when base is nil.
+type headerTransport struct {
+	base   http.RoundTripper
+	header http.Header
+}
+
+func (t headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// A RoundTripper must not change the caller's request
+	req = req.Clone(req.Context())
+	for name, values := range t.header {
+		req.Header[name] = values
+	}
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(req)
+}
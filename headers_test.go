@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestParseHeaders verifies that only "Name: value" lines with a valid
+// name are accepted.
+func TestParseHeaders(t *testing.T) {
+	header, err := parseHeaders([]string{"Authorization: Bearer abc:def", " x-api-key :k1", "X-Api-Key: k2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := header.Get("Authorization"); got != "Bearer abc:def" {
+		t.Errorf("Authorization = %q", got)
+	}
+	if got := header.Values("X-Api-Key"); len(got) != 2 || got[0] != "k1" {
+		t.Errorf("X-Api-Key = %q, want both values", got)
+	}
+	for _, line := range []string{"Authorization", "Bad Name: x", ": x", "X-Evil: a\r\nHost: b"} {
+		if _, err := parseHeaders([]string{line}); err == nil {
+			t.Errorf("%q: got no error", line)
+		}
+	}
+
+	list := headerList{"Authorization: Bearer abc"}
+	if s := list.String(); strings.Contains(s, "abc") || !strings.Contains(s, "Authorization") {
+		t.Errorf("String() = %q, want the name without the value", s)
+	}
+}
+
+// TestOllamaHeaders verifies that the headers reach Ollama with a reply's
+// request.
+func TestOllamaHeaders(t *testing.T) {
+	var got http.Header
+	mockOllama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/tags" {
+			http.NotFound(w, r)
+			return
+		}
+		got = r.Header.Clone()
+		json.NewEncoder(w).Encode(map[string]interface{}{"message": OllamaMessage{Role: "assistant", Content: "Hi"}, "done": true})
+	}))
+	defer mockOllama.Close()
+
+	header, _ := parseHeaders([]string{"Authorization: Bearer s3cret"})
+	oldURL, oldTransport := OllamaAPIURL, ollamaClient.Transport
+	OllamaAPIURL, ollamaClient.Transport = mockOllama.URL, headerTransport{header: header}
+	defer func() { OllamaAPIURL, ollamaClient.Transport = oldURL, oldTransport }()
+
+	var history []OllamaMessage
+	if err := streamOllama(context.Background(), frameFunc(func(interface{}) error { return nil }), OllamaMessage{Role: "user", Content: "Hi"}, &history, "m", Sampling); err != nil {
+		t.Fatal(err)
+	}
+	if got.Get("Authorization") != "Bearer s3cret" || got.Get("Content-Type") != "application/json" {
+		t.Errorf("Ollama got headers %v", got)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+// DefaultHistoryPage and MaxHistoryPage are how many messages /api/history
+// returns when the request doesn't say, and at most.
+const (
+	DefaultHistoryPage = 100
+	MaxHistoryPage     = 1000
+)
+
+// HistoryPage is the response body of the history endpoint: the messages
+// Offset to Offset+len(Messages) of a session's Total. Earlier messages
+// are fetched by passing Offset back as before.
+type HistoryPage struct {
+	SessionID string    `json:"session_id"`
+	Title     string    `json:"title,omitempty"`
+	Messages  []Message `json:"messages"`
+	Offset    int       `json:"offset"`
+	Total     int       `json:"total"`
+}
+
+// historyPage returns the at most limit messages of history that come
+// right before index before, and the index of the first of them.
+func historyPage(history []OllamaMessage, before, limit int) ([]OllamaMessage, int) {
+	end := min(before, len(history))
+	start := max(end-limit, 0)
+	return history[start:end], start
+}
+
+// pageParam parses the query parameter name as a non-negative number,
+// returning fallback when it is missing.
+func pageParam(r *http.Request, name string, fallback int) (int, bool) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return fallback, true
+	}
+	n, err := strconv.Atoi(v)
+	return n, err == nil && n >= 0
+}
+
+// handleHistory returns a session's stored messages, newest last, so a UI
+// that reloads can restore the conversation. Long histories come a page at
+// a time: the latest limit messages (default DefaultHistoryPage), or those
+// before the index given as before. DELETE forgets the session instead.
+func handleHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := r.URL.Query().Get("session_id")
+	if !validSessionID(id) {
+		http.Error(w, "a valid session_id is required", http.StatusBadRequest)
+		return
+	}
+	if r.Method == http.MethodDelete {
+		deleteHistory(w, id)
+		return
+	}
+	limit, ok := pageParam(r, "limit", DefaultHistoryPage)
+	if !ok || limit < 1 || limit > MaxHistoryPage {
+		http.Error(w, "limit must be between 1 and "+strconv.Itoa(MaxHistoryPage), http.StatusBadRequest)
+		return
+	}
+
+	history := sessions.Full(id)
+	if len(history) == 0 {
+		http.Error(w, "session not found", http.StatusNotFound)
+		return
+	}
+	before, ok := pageParam(r, "before", len(history))
+	if !ok {
+		http.Error(w, "before must be a message index", http.StatusBadRequest)
+		return
+	}
+
+	page, offset := historyPage(history, before, limit)
+	writeJSON(w, http.StatusOK, HistoryPage{
+		SessionID: id,
+		Title:     sessions.Title(id),
+		Messages:  timedMessages(page),
+		Offset:    offset,
+		Total:     len(history),
+	})
+}
+
+// deleteHistory removes session id from memory and storage, answering 204
+// whether or not it existed, so retrying a delete is harmless.
+func deleteHistory(w http.ResponseWriter, id string) {
+	if err := sessions.Delete(id); err != nil {
+		slog.Error("Could not delete session", "session", id, "error", err)
+		http.Error(w, "could not delete the session: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	slog.Info("🗑️  Session deleted", "session", id)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestHistoryAPI verifies that a session's history comes back a page at a
+// time, newest last, and the errors for unknown sessions and bad pages.
+func TestHistoryAPI(t *testing.T) {
+	oldSessions := sessions
+	sessions = NewSessionStore(time.Minute, nil)
+	defer func() { sessions = oldSessions }()
+
+	var history []OllamaMessage
+	for i := range 5 {
+		history = append(history, OllamaMessage{Role: "user", Content: fmt.Sprint(i), Time: time.Unix(int64(i), 0)})
+	}
+	sessions.Save("abc", history)
+
+	get := func(query string) (*httptest.ResponseRecorder, HistoryPage) {
+		rr := httptest.NewRecorder()
+		handleHistory(rr, httptest.NewRequest(http.MethodGet, "/api/history?"+query, nil))
+		var page HistoryPage
+		json.NewDecoder(rr.Body).Decode(&page)
+		return rr, page
+	}
+
+	rr, page := get("session_id=abc")
+	if rr.Code != http.StatusOK || len(page.Messages) != 5 || page.Total != 5 || page.Offset != 0 || !page.Messages[4].Time.Equal(time.Unix(4, 0)) {
+		t.Errorf("whole history: got %d %+v", rr.Code, page)
+	}
+
+	rr, page = get("session_id=abc&limit=2")
+	if rr.Code != http.StatusOK || len(page.Messages) != 2 || page.Offset != 3 || page.Messages[0].Content != "3" {
+		t.Errorf("latest page: got %d %+v", rr.Code, page)
+	}
+	rr, page = get(fmt.Sprintf("session_id=abc&limit=2&before=%d", page.Offset))
+	if rr.Code != http.StatusOK || len(page.Messages) != 2 || page.Offset != 1 || page.Messages[0].Content != "1" {
+		t.Errorf("earlier page: got %d %+v", rr.Code, page)
+	}
+
+	for query, want := range map[string]int{
+		"session_id=nope":           http.StatusNotFound,
+		"session_id=../x":           http.StatusBadRequest,
+		"session_id=abc&limit=0":    http.StatusBadRequest,
+		"session_id=abc&limit=5000": http.StatusBadRequest,
+		"session_id=abc&before=-1":  http.StatusBadRequest,
+	} {
+		if rr, _ := get(query); rr.Code != want {
+			t.Errorf("%s: got %d, want %d", query, rr.Code, want)
+		}
+	}
+}
+
+// TestDeleteHistory verifies that DELETE removes a session from memory and
+// storage, and that deleting it again still succeeds.
+func TestDeleteHistory(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldSessions := sessions
+	sessions = NewSessionStore(time.Minute, store)
+	defer func() { sessions = oldSessions }()
+	sessions.Save("abc", []OllamaMessage{{Role: "user", Content: "Forget me"}})
+	sessions.SetTitle("abc", "Secrets")
+
+	for range 2 {
+		rr := httptest.NewRecorder()
+		handleHistory(rr, httptest.NewRequest(http.MethodDelete, "/api/history?session_id=abc", nil))
+		if rr.Code != http.StatusNoContent {
+			t.Errorf("got %d, want 204", rr.Code)
+		}
+	}
+	if stored, err := store.Load("abc"); err != nil || len(stored) != 0 {
+		t.Errorf("storage still has %+v (%v)", stored, err)
+	}
+	if sessions.Len() != 0 || sessions.Title("abc") != "" {
+		t.Errorf("session is still in memory")
+	}
+	rr := httptest.NewRecorder()
+	handleHistory(rr, httptest.NewRequest(http.MethodGet, "/api/history?session_id=abc", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("after delete: got %d, want 404", rr.Code)
+	}
+}
+
+// TestHistoryBeyondMaxHistory verifies that with storage, the history and
+// its export include the messages MaxHistory dropped from memory.
+func TestHistoryBeyondMaxHistory(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldSessions, oldMax := sessions, MaxHistory
+	sessions, MaxHistory = NewSessionStore(time.Minute, store), 3
+	defer func() { sessions, MaxHistory = oldSessions, oldMax }()
+
+	for i := range 5 {
+		sessions.Save("abc", append(sessions.Load("abc"), OllamaMessage{Role: "user", Content: fmt.Sprint(i)}))
+	}
+	if n := len(sessions.Load("abc")); n != 3 {
+		t.Fatalf("memory kept %d messages, want 3", n)
+	}
+
+	rr := httptest.NewRecorder()
+	handleHistory(rr, httptest.NewRequest(http.MethodGet, "/api/history?session_id=abc", nil))
+	var page HistoryPage
+	json.NewDecoder(rr.Body).Decode(&page)
+	if page.Total != 5 || len(page.Messages) != 5 || page.Messages[0].Content != "0" {
+		t.Errorf("got %+v, want all 5 messages", page)
+	}
+
+	rr = httptest.NewRecorder()
+	handleExport(rr, httptest.NewRequest(http.MethodGet, "/api/export?session_id=abc&format=json", nil))
+	var export SessionExport
+	json.NewDecoder(rr.Body).Decode(&export)
+	if len(export.Messages) != 5 {
+		t.Errorf("export has %d messages, want 5", len(export.Messages))
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+// fallbackHomeHTML is served in place of HomeTemplateFile when that file is
+// missing, e.g. when only the binary was copied somewhere. It is a bare
+// chat with none of the extras, rendered with the same HomeData.
+const fallbackHomeHTML = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="UTF-8">
+<meta name="viewport" content="width=device-width, initial-scale=1.0">
+<title>chatOllama</title>
+<style>
+    body { font-family: sans-serif; max-width: 800px; margin: 0 auto; padding: 10px; }
+    #messages { white-space: pre-wrap; }
+    .user { color: #007d9c; margin-top: 12px; }
+    .bot { margin-top: 4px; }
+    .error { color: #c0392b; }
+    form { display: flex; gap: 8px; margin-top: 16px; }
+    input { flex: 1; padding: 8px; }
+</style>
+</head>
+<body>
+<h3>chatOllama <small>{{.Model}} · {{.Mode}}</small></h3>
+<p><small>Basic page: index.html was not found next to the server.</small></p>
+<div id="messages"></div>
+<form id="form">
+    <input id="input" placeholder="Type a message..." autocomplete="off">
+    <button>Send</button>
+</form>
+<script>
+    const token = new URLSearchParams(window.location.search).get('token');
+    const protocol = window.location.protocol === "https:" ? "wss://" : "ws://";
+    const socket = new WebSocket(protocol + window.location.host + {{.WSPath}} +
+        (token ? "?token=" + encodeURIComponent(token) : ""));
+    const messages = document.getElementById('messages');
+    const input = document.getElementById('input');
+    let bubble = null;
+
+    function add(cls, text) {
+        const div = document.createElement('div');
+        div.className = cls;
+        div.textContent = text;
+        messages.appendChild(div);
+        window.scrollTo(0, document.body.scrollHeight);
+        return div;
+    }
+
+    socket.onmessage = (event) => {
+        const data = JSON.parse(event.data);
+        if (data.status) return;
+        if (!bubble) bubble = add('bot', '');
+        if (data.code) bubble.classList.add('error');
+        bubble.textContent += data.chunk;
+        if (data.done) bubble = null;
+    };
+    socket.onclose = () => add('error', 'Disconnected. Reload the page to reconnect.');
+
+    document.getElementById('form').onsubmit = (e) => {
+        e.preventDefault();
+        const text = input.value.trim();
+        if (!text) return;
+        add('user', text);
+        socket.send(JSON.stringify({ message: text }));
+        input.value = '';
+    };
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// MaxImages is how many images a single message may carry.
+const MaxImages = 4
+
+// prompt is the message req asks the model to answer: the user's, or a
+// tool's output.
+func (req ChatRequest) prompt() OllamaMessage {
+	content := sanitizeContent(req.Message)
+	if req.Role == RoleTool {
+		return OllamaMessage{Role: RoleTool, Content: content, ToolName: req.ToolName, Time: time.Now()}
+	}
+	return OllamaMessage{Role: "user", Content: content, Images: req.Images, Time: time.Now()}
+}
+
+// validateImages checks that images are base64 and that model can see them.
+// If Ollama doesn't say what the model supports, the images are sent anyway
+// with a warning.
+func validateImages(ctx context.Context, model string, images []string) error {
+	if len(images) == 0 {
+		return nil
+	}
+	if len(images) > MaxImages {
+		return fmt.Errorf("at most %d images per message, got %d", MaxImages, len(images))
+	}
+	for i, img := range images {
+		if _, err := base64.StdEncoding.DecodeString(img); err != nil {
+			return fmt.Errorf("image %d is not valid base64", i+1)
+		}
+	}
+
+	capabilities, err := modelCapabilities(ctx, model)
+	if err != nil || capabilities == nil {
+		slog.Warn("⚠️  Could not check whether the model supports images", "model", model, "error", err)
+		return nil
+	}
+	if !slices.Contains(capabilities, "vision") {
+		return fmt.Errorf("model %s does not support images", model)
+	}
+	return nil
+}
+
+// modelCapabilities asks Ollama's /api/show endpoint what model can do, such
+// as "completion" or "vision". Older Ollama versions don't report it, which
+// gives a nil slice.
+func modelCapabilities(ctx context.Context, model string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, HealthCheckTimeout)
+	defer cancel()
+
+	payload, _ := json.Marshal(map[string]string{"model": model})
+	req, err := http.NewRequestWithContext(ctx, "POST", ollamaEndpoint("/api/show"), bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := ollamaClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := checkOllamaStatus(resp); err != nil {
+		return nil, err
+	}
+
+	var show struct {
+		Capabilities []string `json:"capabilities"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&show); err != nil {
+		return nil, fmt.Errorf("decoding /api/show response: %w", err)
+	}
+	return show.Capabilities, nil
+}
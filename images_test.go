@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// pixelPNG is a 1x1 transparent PNG.
+const pixelPNG = "iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAQAAAC1HAwCAAAAC0lEQVR42mNkYAAAAAYAAjCB0C8AAAAASUVORK5CYII="
+
+// visionOllamaServer reports capabilities on /api/show and records the
+// messages of the last chat request in got.
+func visionOllamaServer(capabilities string, got *[]OllamaMessage) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/show" {
+			w.Write([]byte(`{"capabilities": ` + capabilities + `}`))
+			return
+		}
+		var req OllamaRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		*got = req.Messages
+		w.Write([]byte(`{"message": {"role": "assistant", "content": "A pixel"}, "done": true}`))
+	}))
+}
+
+// TestImagesForwardedToOllama verifies that images sent with a message reach
+// Ollama on that user message.
+func TestImagesForwardedToOllama(t *testing.T) {
+	var got []OllamaMessage
+	mockOllama := visionOllamaServer(`["completion", "vision"]`, &got)
+	defer mockOllama.Close()
+
+	oldURL := OllamaAPIURL
+	OllamaAPIURL = mockOllama.URL + "/api/chat"
+	defer func() { OllamaAPIURL = oldURL }()
+
+	body := `{"message": "What is this?", "images": ["` + pixelPNG + `"]}`
+	rr := httptest.NewRecorder()
+	handleChatAPI(rr, httptest.NewRequest(http.MethodPost, "/api/chat", strings.NewReader(body)))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", rr.Code, http.StatusOK, rr.Body.String())
+	}
+	last := got[len(got)-1]
+	if last.Role != "user" || len(last.Images) != 1 || last.Images[0] != pixelPNG {
+		t.Errorf("Ollama received last message %+v", last)
+	}
+}
+
+// TestImagesRejected verifies that bad image data and models without vision
+// are refused before anything is generated.
+func TestImagesRejected(t *testing.T) {
+	var got []OllamaMessage
+	mockOllama := visionOllamaServer(`["completion"]`, &got)
+	defer mockOllama.Close()
+
+	oldURL := OllamaAPIURL
+	OllamaAPIURL = mockOllama.URL + "/api/chat"
+	defer func() { OllamaAPIURL = oldURL }()
+
+	cases := []struct {
+		name   string
+		images string
+	}{
+		{"text-only model", `["` + pixelPNG + `"]`},
+		{"not base64", `["not base64!"]`},
+	}
+	for _, tc := range cases {
+		body := `{"message": "What is this?", "images": ` + tc.images + `}`
+		rr := httptest.NewRecorder()
+		handleChatAPI(rr, httptest.NewRequest(http.MethodPost, "/api/chat", strings.NewReader(body)))
+
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: got status %d, want %d", tc.name, rr.Code, http.StatusBadRequest)
+		}
+	}
+	if got != nil {
+		t.Error("Ollama was asked to generate despite invalid images")
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// PingInterval is how often a WebSocket client is pinged. A client that
+// hasn't answered within twice the interval is considered gone and its
+// connection closed. Zero disables pinging. It is set once in main.
+var PingInterval = 30 * time.Second
+
+// keepAlive pings conn every interval until ctx is done, and makes reads
+// on conn fail once no pong has arrived for two intervals. Call the
+// returned heardFrom after reading a message, which also proves the client
+// is alive. Without this a connection dropped without a close frame, as
+// NATs and tunnels do when idle, lingers until the OS gives up on it.
+func keepAlive(ctx context.Context, conn *websocket.Conn, interval time.Duration) (heardFrom func()) {
+	if interval <= 0 {
+		return func() {}
+	}
+	extend := func() {
+		conn.SetReadDeadline(time.Now().Add(2 * interval))
+	}
+	extend()
+	conn.SetPongHandler(func(string) error {
+		extend()
+		return nil
+	})
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				// WriteControl is safe to call alongside the handler's own writes
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(interval)); err != nil {
+					return
+				}
+			}
+		}
+	}()
+	return extend
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// TestKeepAliveClosesSilentClients verifies that a client answering pings
+// stays connected while one that doesn't is disconnected.
+func TestKeepAliveClosesSilentClients(t *testing.T) {
+	old := PingInterval
+	PingInterval = 50 * time.Millisecond
+	defer func() { PingInterval = old }()
+
+	for _, answers := range []bool{true, false} {
+		done := make(chan struct{})
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handleWebSocket(w, r)
+			close(done)
+		}))
+
+		wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+		ws, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+		if err != nil {
+			t.Fatalf("could not open websocket connection: %v", err)
+		}
+		if answers {
+			// Reading is what makes the client answer pings with pongs
+			go func() {
+				for {
+					if _, _, err := ws.ReadMessage(); err != nil {
+						return
+					}
+				}
+			}()
+		}
+
+		select {
+		case <-done:
+			if answers {
+				t.Error("a client answering pings was disconnected")
+			}
+		case <-time.After(500 * time.Millisecond):
+			if !answers {
+				t.Error("a client not answering pings was not disconnected")
+			}
+		}
+		ws.Close()
+		server.Close()
+	}
+}
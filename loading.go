@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// StatusLoading tells the client Ollama is loading the model into memory
+// before it can generate, which after a cold start can take many seconds.
+// StatusGenerating follows once the model is ready.
+const StatusLoading = "loading"
+
+// LoadProbeDelay is how long Ollama may take to answer a chat request
+// before it is asked whether it is loading the model. A loaded model
+// usually answers sooner, so most requests are never probed.
+var LoadProbeDelay = time.Second
+
+// probeLoading sends ws a StatusLoading frame if Ollama hasn't answered
+// within LoadProbeDelay and model isn't in memory yet. Calling the returned
+// function, once Ollama answers, stops the probe and waits for it to finish,
+// so the frame never comes after what follows.
+func probeLoading(ctx context.Context, ws FrameWriter, model string) (stop func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(LoadProbeDelay):
+		}
+		if !modelLoaded(ctx, model) && ctx.Err() == nil {
+			// A failed write shows up on the next one
+			ws.WriteJSON(StreamResponse{Status: StatusLoading})
+		}
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// modelLoaded asks Ollama's /api/ps endpoint whether model is in memory. If
+// Ollama can't say, the model is taken to be loaded, so the client is never
+// told about a load that may not happen.
+func modelLoaded(ctx context.Context, model string) bool {
+	ctx, cancel := context.WithTimeout(ctx, HealthCheckTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", ollamaEndpoint("/api/ps"), nil)
+	if err != nil {
+		return true
+	}
+	resp, err := ollamaClient.Do(req)
+	if err != nil {
+		return true
+	}
+	defer resp.Body.Close()
+
+	var ps struct {
+		Models *[]ModelInfo `json:"models"`
+	}
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&ps) != nil || ps.Models == nil {
+		return true
+	}
+	for _, m := range *ps.Models {
+		if sameModel(m.Name, model) {
+			return true
+		}
+	}
+	return false
+}
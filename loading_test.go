@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+)
+
+// TestLoadingStatus verifies that a reply slow to start is announced as
+// loading when Ollama doesn't have the model in memory, followed by
+// generating, and not when the model is loaded.
+func TestLoadingStatus(t *testing.T) {
+	oldURL, oldDelay := OllamaAPIURL, LoadProbeDelay
+	LoadProbeDelay = 10 * time.Millisecond
+	defer func() { OllamaAPIURL, LoadProbeDelay = oldURL, oldDelay }()
+
+	for _, tc := range []struct {
+		loaded []ModelInfo
+		want   []string
+	}{
+		{[]ModelInfo{}, []string{StatusLoading, StatusGenerating}},
+		{[]ModelInfo{{Name: "m:latest"}}, []string{StatusGenerating}},
+	} {
+		mockOllama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/api/ps":
+				json.NewEncoder(w).Encode(map[string]interface{}{"models": tc.loaded})
+			case "/api/chat":
+				time.Sleep(100 * time.Millisecond) // Loading, or not
+				w.Write([]byte(`{"message": {"role": "assistant", "content": "Hi"}, "done": false}` + "\n"))
+				w.Write([]byte(`{"message": {"role": "assistant", "content": ""}, "done": true}` + "\n"))
+			default:
+				http.NotFound(w, r)
+			}
+		}))
+		OllamaAPIURL = mockOllama.URL + "/api/chat"
+
+		var statuses []string
+		out := frameFunc(func(v interface{}) error {
+			if frame := v.(StreamResponse); frame.Status != "" {
+				statuses = append(statuses, frame.Status)
+			} else if frame.Chunk != "" && len(statuses) == 0 {
+				t.Error("got text before any status")
+			}
+			return nil
+		})
+		var history []OllamaMessage
+		err := streamOllama(context.Background(), out, OllamaMessage{Role: "user", Content: "Hi"}, &history, "m", Sampling)
+		mockOllama.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(statuses, tc.want) {
+			t.Errorf("loaded %v: got statuses %v, want %v", tc.loaded, statuses, tc.want)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+// setupLogging selects the log format. "text" keeps the default human
+// friendly output. "json" writes one JSON object per line to w for log
+// aggregators, and also reroutes the plain log.Printf calls through it.
+func setupLogging(format string, w io.Writer) error {
+	switch format {
+	case "text":
+		return nil
+	case "json":
+		slog.SetDefault(slog.New(slog.NewJSONHandler(w, nil)))
+		return nil
+	default:
+		return fmt.Errorf("must be json or text, got %q", format)
+	}
+}
+
+// statusRecorder captures the status code a handler sends. It passes
+// Hijack and Flush through, so WebSocket upgrades and streamed responses
+// behave as if it weren't there.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack hands the connection to a WebSocket. The upgrade writes its 101
+// response to the raw connection, so it is recorded here.
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("%T does not support hijacking", r.ResponseWriter)
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err == nil {
+		r.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// logRequests logs every request next serves once it is done, with its
+// status and how long it took. For a WebSocket that is the whole session.
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK // Nothing written, which net/http answers with 200
+		}
+		slog.Info("HTTP request", "method", r.Method, "path", r.URL.Path, "status", rec.status,
+			"remote", r.RemoteAddr, "duration", time.Since(start))
+	})
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// TestSetupLoggingJSON verifies that JSON mode turns both slog records and
+// plain log.Printf lines into JSON objects.
+func TestSetupLoggingJSON(t *testing.T) {
+	oldLogger, oldFlags := slog.Default(), log.Flags()
+	defer func() {
+		slog.SetDefault(oldLogger)
+		log.SetOutput(os.Stderr)
+		log.SetFlags(oldFlags)
+	}()
+
+	var buf bytes.Buffer
+	if err := setupLogging("json", &buf); err != nil {
+		t.Fatal(err)
+	}
+	slog.Info("Generation finished", "model", "test-model")
+	log.Println("plain line")
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	if len(lines) != 2 {
+		t.Fatalf("got %d log lines, want 2:\n%s", len(lines), buf.String())
+	}
+	var record map[string]any
+	if err := json.Unmarshal(lines[0], &record); err != nil {
+		t.Fatalf("log line is not JSON: %v", err)
+	}
+	if record["model"] != "test-model" || record["msg"] != "Generation finished" {
+		t.Errorf("got record %v", record)
+	}
+	if err := json.Unmarshal(lines[1], &record); err != nil || record["msg"] != "plain line" {
+		t.Errorf("plain log line was not rerouted: %s", lines[1])
+	}
+
+	if err := setupLogging("xml", &buf); err == nil {
+		t.Error("unknown format should be rejected")
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe to log to from server goroutines.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// TestLogRequests verifies that requests are logged with their status, and
+// that WebSocket upgrades still work behind the middleware.
+func TestLogRequests(t *testing.T) {
+	oldLogger := slog.Default()
+	defer slog.SetDefault(oldLogger)
+	var logs syncBuffer
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&logs, nil)))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/missing", http.NotFound)
+	mux.HandleFunc("/ws", handleWebSocket)
+	server := httptest.NewServer(logRequests(mux))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatalf("WebSocket upgrade failed behind the middleware: %v", err)
+	}
+	ws.Close()
+
+	// The WebSocket is logged once its handler notices the close
+	want := map[string]float64{"/missing": http.StatusNotFound, "/ws": http.StatusSwitchingProtocols}
+	deadline := time.Now().Add(2 * time.Second)
+	for len(want) > 0 && time.Now().Before(deadline) {
+		for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+			var record map[string]any
+			if json.Unmarshal([]byte(line), &record) != nil || record["msg"] != "HTTP request" {
+				continue
+			}
+			if status, ok := want[record["path"].(string)]; ok && record["status"] == status {
+				delete(want, record["path"].(string))
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(want) > 0 {
+		t.Errorf("requests not logged with the right status: %v\nlogs:\n%s", want, logs.String())
+	}
+}
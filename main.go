@@ -1,42 +1,226 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"html/template"
+	"io/fs"
 	"log"
+	"log/slog"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
+	"regexp"
 	"runtime"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"golang.ngrok.com/ngrok"
 	"golang.ngrok.com/ngrok/config"
 )
 
 var OllamaAPIURL = "http://localhost:11434/api/chat"
 
+// normalizeOllamaURL turns a user-supplied Ollama address into the chat API
+// URL. It accepts anything from "gpu-box" or "10.0.0.5:11434" (the forms
+// OLLAMA_HOST uses) to a full URL behind a proxy prefix.
+func normalizeOllamaURL(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	hasScheme := strings.Contains(raw, "://")
+	if !hasScheme {
+		raw = "http://" + raw
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return "", fmt.Errorf("missing host in %q", raw)
+	}
+	// Like the ollama CLI, a bare host means the default port
+	if !hasScheme && u.Port() == "" {
+		u.Host = net.JoinHostPort(u.Hostname(), "11434")
+	}
+
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	if !strings.HasSuffix(u.Path, "/api/chat") {
+		u.Path += "/api/chat"
+	}
+	return u.String(), nil
+}
+
+// ollamaSocket returns the socket path of a unix:// Ollama address, such as
+// unix:///run/ollama/ollama.sock, and false for any other address.
+func ollamaSocket(raw string) (string, bool) {
+	path, ok := strings.CutPrefix(strings.TrimSpace(raw), "unix://")
+	return path, ok && path != ""
+}
+
+// DefaultModel is used when neither -model nor OLLAMA_MODEL is set.
+const DefaultModel = "gemma3:1b"
+
+// OllamaModel is the model every chat request is sent to. It is set once in main.
+var OllamaModel = DefaultModel
+
+// modelNamePattern matches Ollama model names such as "gemma3:1b" or "hf.co/user/repo:Q4_K_M".
+var modelNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._/:-]{0,254}$`)
+
+// resolveModel returns the model a request should use: requested if given,
+// otherwise OllamaModel. Malformed names are rejected before reaching Ollama.
+func resolveModel(requested string) (string, error) {
+	requested = strings.TrimSpace(requested)
+	if requested == "" {
+		return OllamaModel, nil
+	}
+	if !modelNamePattern.MatchString(requested) {
+		return "", fmt.Errorf("invalid model name %q", requested)
+	}
+	return requested, nil
+}
+
+// DefaultSystemPrompt is used when no -system flag, SYSTEM_PROMPT or system.txt is provided.
+const DefaultSystemPrompt = "You are a helpful assistant."
+
+// SystemPromptFile is loaded when the prompt is not given by flag or environment.
+const SystemPromptFile = "system.txt"
+
+// SystemPrompt is sent as the first message of every chat request. It is set once in main.
+var SystemPrompt = DefaultSystemPrompt
+
+// Sampling holds the generation options sent with every chat request. It is set once in main.
+var Sampling = SamplingOptions{Temperature: 0.5, TopK: 1, TopP: 0.9}
+
 // Configure the Upgrader
 var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		return true // Allow all connections
-	},
+	CheckOrigin: checkOrigin,
 }
 
 // Structs
 type ChatRequest struct {
-	Message string `json:"message"`
+	Type      string `json:"type,omitempty"` // Empty for a chat message, or a control type such as MessageTypeStop
+	Message   string `json:"message"`
+	SessionID string `json:"session_id,omitempty"`
+	// ConversationID keeps several histories apart on one WebSocket, such
+	// as a UI's tabs. Frames answering the message carry it back.
+	ConversationID string `json:"conversation_id,omitempty"`
+	Model          string `json:"model,omitempty"` // Overrides OllamaModel for this message
+	// Images are base64-encoded pictures sent along with Message to a vision model
+	Images []string `json:"images,omitempty"`
+	Stop   []string `json:"stop,omitempty"` // Sequences that end the reply when generated
+	// MaxTokens limits the reply's length, within the server's own MaxTokens cap
+	MaxTokens int `json:"max_tokens,omitempty"`
+	// Persona names one of Personas whose system prompt replaces SystemPrompt
+	Persona string `json:"persona,omitempty"`
+	// Tools are functions the model may ask the client to call
+	Tools []Tool `json:"tools,omitempty"`
+	// Role is RoleTool when Message is a tool's output, with ToolName naming
+	// the tool. Empty means a user message.
+	Role     string `json:"role,omitempty"`
+	ToolName string `json:"tool_name,omitempty"`
+	// Temperature overrides Sampling's, and System replaces SystemPrompt
+	// unless Persona is set. Usually sent once with MessageTypeConfigure.
+	Temperature *float64 `json:"temperature,omitempty"`
+	System      string   `json:"system,omitempty"`
+	// Format is "json", or a JSON Schema object, to make the reply valid
+	// JSON. Its final frame says if it isn't, e.g. when cut off.
+	Format json.RawMessage `json:"format,omitempty"`
+	// NumCtx sets the model's context window in tokens, within MaxNumCtx
+	NumCtx int `json:"num_ctx,omitempty"`
+	// NumThread and NumGPU override the server's CPU threads and GPU layers;
+	// num_gpu 0 runs the model on the CPU alone
+	NumThread int  `json:"num_thread,omitempty"`
+	NumGPU    *int `json:"num_gpu,omitempty"`
+	// Seed fixes the random number generator, so that the same request at
+	// the same temperature gets the same reply
+	Seed *int `json:"seed,omitempty"`
 }
 
+// MaxQueuedMessages is how many messages a WebSocket connection may send
+// ahead while the previous one is still being handled, e.g. while a reply
+// is being titled or generated for another conversation. Beyond that, or
+// for the conversation whose reply is being generated, they are refused
+// with a CodeBusy frame.
+const MaxQueuedMessages = 4
+
+// MessageTypeStop asks the server to cancel the reply currently being generated.
+const MessageTypeStop = "stop"
+
+// MessageTypeReset asks the server to forget the conversation so far, that
+// of session_id if set. It is confirmed with a StatusReset frame.
+const MessageTypeReset = "reset"
+
 type StreamResponse struct {
-	Chunk string `json:"chunk"`
-	Done  bool   `json:"done"`
+	Chunk string           `json:"chunk"`
+	Done  bool             `json:"done"`
+	Stats *GenerationStats `json:"stats,omitempty"` // Only set on the final frame of a reply
+	// Status reports progress before any text arrives, e.g. StatusWaiting
+	Status string `json:"status,omitempty"`
+	// Code identifies the failure on error frames, e.g. CodeModelNotFound
+	Code string `json:"code,omitempty"`
+	// Truncated is set on the final frame when the reply hit the token limit
+	Truncated bool `json:"truncated,omitempty"`
+	// Suggestion names an installed model to try instead of a missing one
+	Suggestion string `json:"suggestion,omitempty"`
+	// Thinking carries a reasoning model's thoughts, kept apart from Chunk
+	Thinking string `json:"thinking,omitempty"`
+	// ToolCalls are the model's requests to call the request's Tools
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+	// ConversationID echoes that of the message the frame answers
+	ConversationID string `json:"conversation_id,omitempty"`
+	// Title names the session, on a StatusTitled frame
+	Title string `json:"title,omitempty"`
+	// InvalidJSON is set on the final frame of a reply asked for with a
+	// format that nonetheless doesn't parse as JSON
+	InvalidJSON bool `json:"invalid_json,omitempty"`
+	// Model names the model that generated the reply, on its final frame
+	Model string `json:"model,omitempty"`
+	// Warning explains a StatusTrimmed frame
+	Warning string `json:"warning,omitempty"`
+	// Raw is a line of Ollama's stream, forwarded in place of Chunk,
+	// Thinking and ToolCalls when RawStream is set
+	Raw json.RawMessage `json:"raw,omitempty"`
+}
+
+// StatusWaiting tells the client its message is queued behind other
+// generations.
+const StatusWaiting = "waiting"
+
+// StatusGenerating tells the client Ollama accepted the request and has the
+// model loaded, so it can show that a reply is on its way before the first
+// chunk. It may follow a StatusLoading frame.
+const StatusGenerating = "generating"
+
+// StatusTrimmed tells the client its message alone was too long for the
+// model's context, so part of it was left out. Warning says how much.
+const StatusTrimmed = "trimmed"
+
+// StatusRetrying tells the client Ollama's stream broke off and the reply
+// starts over, so the text received so far must be discarded.
+const StatusRetrying = "retrying"
+
+// StatusReset confirms a MessageTypeReset; the next message starts afresh.
+const StatusReset = "reset"
+
+// GenerationStats are the token counts and timings Ollama reports once a
+// reply is finished. Durations are in nanoseconds.
+type GenerationStats struct {
+	PromptEvalCount int   `json:"prompt_eval_count"`
+	EvalCount       int   `json:"eval_count"`
+	EvalDuration    int64 `json:"eval_duration"`
+	TotalDuration   int64 `json:"total_duration"`
 }
 
 type OllamaRequest struct {
@@ -44,226 +228,1140 @@ type OllamaRequest struct {
 	Messages []OllamaMessage        `json:"messages"`
 	Stream   bool                   `json:"stream"`
 	Options  map[string]interface{} `json:"options,omitempty"`
+	Tools    []Tool                 `json:"tools,omitempty"`
+	Format   json.RawMessage        `json:"format,omitempty"`
+	// KeepAlive is how long the model stays loaded afterwards; see KeepAlive
+	KeepAlive interface{} `json:"keep_alive,omitempty"`
 }
 
 type OllamaMessage struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role    string   `json:"role"`
+	Content string   `json:"content"`
+	Images  []string `json:"images,omitempty"` // Base64-encoded, for vision models
+	// Thinking is a reasoning model's chain of thought, streamed before its
+	// answer. It is never kept in the history sent back to Ollama.
+	Thinking string `json:"thinking,omitempty"`
+	// ToolCalls are set on assistant messages that call tools; ToolName on
+	// the RoleTool message carrying a tool's output
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+	ToolName  string     `json:"tool_name,omitempty"`
+	// Time is when the message was received or generated. Ollama has no
+	// use for it, so it is only stored and exported, in a Message.
+	Time time.Time `json:"-"`
+}
+
+// OllamaStreamChunk is one line of Ollama's streaming chat response. The
+// last line has Done set, carries no content, and reports why generation
+// ended along with its stats. A non-streaming response has the same shape.
+type OllamaStreamChunk struct {
+	Model      string        `json:"model"`
+	Message    OllamaMessage `json:"message"`
+	Done       bool          `json:"done"`
+	DoneReason string        `json:"done_reason,omitempty"`
+	GenerationStats
+}
+
+// SamplingOptions controls how Ollama picks the next token.
+type SamplingOptions struct {
+	Temperature float64
+	TopK        int
+	TopP        float64
+	Stop        []string        // Per request; generation halts when one is produced
+	NumPredict  int             // Per request; max tokens to generate, 0 for Ollama's default
+	System      string          // Per request; replaces SystemPrompt when set, e.g. by a persona
+	Tools       []Tool          // Per request; functions the model may call
+	Documents   string          // Per request; passages from Docs added to the system prompt
+	Format      json.RawMessage // Per request; "json" or a JSON Schema the reply must match
+	NumCtx      int             // Per request; context window in tokens, 0 for the model's default
+	Raw         bool            // Per request; forward Ollama's stream lines as is, see RawStream
+	NumThread   int             // CPU threads to generate with, 0 for Ollama's choice
+	NumGPU      *int            // Model layers to offload to the GPU, 0 for none; nil for Ollama's choice
+	Seed        *int            // Per request; seeds the sampler for reproducible replies, nil for a random seed
+}
+
+// MaxTokens caps how many tokens a reply may have, whatever the client asks
+// for. 0 means no cap. It is set once in main.
+var MaxTokens = 0
+
+// MaxNumCtx caps the context window a request may ask for, since memory
+// use grows with it. 0 means no cap. It is set once in main.
+var MaxNumCtx = 32768
+
+// MaxStopSequences is how many stop sequences a request may set.
+const MaxStopSequences = 8
+
+// Validate rejects values outside the ranges Ollama accepts.
+func (o SamplingOptions) Validate() error {
+	if o.Temperature < 0 || o.Temperature > 2 {
+		return fmt.Errorf("temperature must be between 0 and 2, got %v", o.Temperature)
+	}
+	if o.TopK < 1 {
+		return fmt.Errorf("top_k must be at least 1, got %d", o.TopK)
+	}
+	if o.TopP < 0 || o.TopP > 1 {
+		return fmt.Errorf("top_p must be between 0 and 1, got %v", o.TopP)
+	}
+	if o.NumPredict < 0 {
+		return fmt.Errorf("max_tokens must not be negative, got %d", o.NumPredict)
+	}
+	if o.NumCtx < 0 {
+		return fmt.Errorf("num_ctx must not be negative, got %d", o.NumCtx)
+	}
+	if o.NumThread < 0 {
+		return fmt.Errorf("num_thread must not be negative, got %d", o.NumThread)
+	}
+	if o.NumGPU != nil && *o.NumGPU < 0 {
+		return fmt.Errorf("num_gpu must not be negative, got %d", *o.NumGPU)
+	}
+	if len(o.Stop) > MaxStopSequences {
+		return fmt.Errorf("at most %d stop sequences, got %d", MaxStopSequences, len(o.Stop))
+	}
+	for _, seq := range o.Stop {
+		if seq == "" {
+			return fmt.Errorf("stop sequences must not be empty")
+		}
+	}
+	return nil
+}
+
+// Map converts the options into the shape expected by OllamaRequest.Options.
+func (o SamplingOptions) Map() map[string]interface{} {
+	options := map[string]interface{}{
+		"temperature": o.Temperature,
+		"top_k":       o.TopK,
+		"top_p":       o.TopP,
+	}
+	if len(o.Stop) > 0 {
+		options["stop"] = o.Stop
+	}
+	if o.NumPredict > 0 {
+		options["num_predict"] = o.NumPredict
+	}
+	if o.NumCtx > 0 {
+		options["num_ctx"] = o.NumCtx
+	}
+	if o.NumThread > 0 {
+		options["num_thread"] = o.NumThread
+	}
+	if o.NumGPU != nil {
+		options["num_gpu"] = *o.NumGPU
+	}
+	if o.Seed != nil {
+		options["seed"] = *o.Seed
+	}
+	return options
+}
+
+// options returns the server's Sampling with req's own settings merged in.
+func (req ChatRequest) options() (SamplingOptions, error) {
+	opts, err := requestOptions(req.Stop, req.MaxTokens)
+	if err != nil {
+		return opts, err
+	}
+	if req.Role != "" && req.Role != "user" && req.Role != RoleTool {
+		return opts, fmt.Errorf("role must be user or %s, got %q", RoleTool, req.Role)
+	}
+	if err := validateTools(req.Tools); err != nil {
+		return opts, err
+	}
+	if err := validateFormat(req.Format); err != nil {
+		return opts, err
+	}
+	if string(req.Format) != "null" {
+		opts.Format = req.Format
+	}
+	if req.Temperature != nil {
+		opts.Temperature = *req.Temperature
+	}
+	opts.NumCtx = req.NumCtx
+	if req.NumThread != 0 {
+		opts.NumThread = req.NumThread
+	}
+	if req.NumGPU != nil {
+		opts.NumGPU = req.NumGPU
+	}
+	opts.Seed = req.Seed
+	if err := opts.Validate(); err != nil {
+		return opts, err
+	}
+	if MaxNumCtx > 0 && opts.NumCtx > MaxNumCtx {
+		return opts, fmt.Errorf("num_ctx must be at most %d, got %d", MaxNumCtx, opts.NumCtx)
+	}
+	opts.System = personaPrompt(req.Persona)
+	if opts.System == "" {
+		opts.System = sanitizeContent(req.System)
+	}
+	opts.Tools = req.Tools
+	opts.Raw = RawStream
+	return opts, nil
+}
+
+// requestOptions returns the server's Sampling with a request's stop
+// sequences and token limit, capped by MaxTokens.
+func requestOptions(stop []string, maxTokens int) (SamplingOptions, error) {
+	opts := Sampling
+	opts.Stop = stop
+	opts.NumPredict = maxTokens
+	if err := opts.Validate(); err != nil {
+		return opts, err
+	}
+	if MaxTokens > 0 && (opts.NumPredict == 0 || opts.NumPredict > MaxTokens) {
+		opts.NumPredict = MaxTokens
+	}
+	return opts, nil
 }
 
 func main() {
-	checkOllama()
+	// 1. Parse Flags (flags take precedence over environment variables, and
+	// both over the -config file)
+	configFile := flag.String("config", os.Getenv("CONFIG_FILE"), "YAML or JSON file of settings keyed by flag name, plus mode (env: CONFIG_FILE)")
+	logFormat := flag.String("log-format", "text", "Log output format: text or json")
+	mock := flag.Bool("mock", false, "Answer with canned echo replies instead of calling Ollama, for UI work and CI")
+	autostart := flag.Bool("autostart", false, "Start `ollama serve` if Ollama is installed but not running, and stop it on exit")
+	flag.BoolVar(&Titles, "titles", Titles, "Name sessions with a short title after their first exchange, using one extra request")
+	accessLog := flag.Bool("access-log", false, "Log every HTTP request with its status and duration")
+	ollamaURL := flag.String("ollama-url", envOr("OLLAMA_HOST", OllamaAPIURL), "Ollama server address, e.g. http://gpu-box:11434 or unix:///run/ollama.sock (env: OLLAMA_HOST)")
+	var ollamaHeaders headerList
+	flag.Var(&ollamaHeaders, "ollama-header", "Header added to every request to Ollama, as \"Name: value\", e.g. for an API key; repeatable")
+	flag.StringVar(&OllamaModel, "model", envOr("OLLAMA_MODEL", DefaultModel), "Ollama model to chat with (env: OLLAMA_MODEL)")
+	fallbackModels := flag.String("fallback-models", "", "Comma-separated models to try in order when a reply's model is missing or fails before answering")
+	flag.StringVar(&SystemPrompt, "system", os.Getenv("SYSTEM_PROMPT"), "System prompt (env: SYSTEM_PROMPT, file: "+SystemPromptFile+")")
+	flag.StringVar(&Welcome, "welcome", os.Getenv("WELCOME_MESSAGE"), "Message shown to every chat client when it connects (env: WELCOME_MESSAGE)")
+	welcomeFile := flag.String("welcome-file", "", "File holding the welcome message, instead of -welcome")
+	personasFile := flag.String("personas", PersonasFile, "JSON file of named personas clients can pick from")
+	flag.Float64Var(&Sampling.Temperature, "temp", Sampling.Temperature, "Sampling temperature (0-2)")
+	flag.IntVar(&Sampling.TopK, "topk", Sampling.TopK, "Top-k sampling (>= 1)")
+	flag.Float64Var(&Sampling.TopP, "topp", Sampling.TopP, "Top-p sampling (0-1)")
+	flag.IntVar(&Sampling.NumThread, "num-thread", Sampling.NumThread, "CPU threads Ollama generates with, 0 for Ollama's choice")
+	numGPU := flag.Int("num-gpu", -1, "Model layers Ollama offloads to the GPU, 0 to run on the CPU alone, -1 for Ollama's choice")
+	flag.IntVar(&WindowSize, "window", envInt("WINDOW_SIZE", WindowSize), "History messages (not exchanges) sent with each request (env: WINDOW_SIZE)")
+	flag.IntVar(&MaxHistory, "max-history", MaxHistory, "History messages kept in memory per conversation, at least -window; older ones are dropped, or kept only on disk. 0 keeps all")
+	flag.IntVar(&TokenBudget, "token-budget", TokenBudget, "Approximate max tokens of history sent per request, 0 to disable")
+	flag.IntVar(&MaxBatchJobs, "max-batch", MaxBatchJobs, "Most prompts times models one /api/batch request may run")
+	flag.IntVar(&BatchParallelism, "batch-parallel", BatchParallelism, "Jobs of one /api/batch request run at once, within -max-generations")
+	maxGenerations := flag.Int("max-generations", runtime.NumCPU(), "Generations run at once; further messages queue (use 1 for a single GPU)")
+	flag.IntVar(&MaxNumCtx, "max-num-ctx", MaxNumCtx, "Largest context window, in tokens, a client may ask for with num_ctx; 0 for no cap")
+	flag.IntVar(&MaxTokens, "max-tokens", MaxTokens, "Cap on tokens per reply, also for clients asking for more; 0 for no cap")
+	flag.Int64Var(&MaxMessageBytes, "max-message-size", MaxMessageBytes, "Largest WebSocket message a client may send, in bytes, images included")
+	flag.IntVar(&MaxStreamLine, "max-stream-line", MaxStreamLine, "Longest line of Ollama's streamed response accepted, in bytes")
+	flag.DurationVar(&GenerationTimeout, "timeout", GenerationTimeout, "Longest a reply may take in total before it is abandoned, 0 to disable")
+	flag.DurationVar(&StreamIdleTimeout, "idle-timeout", StreamIdleTimeout, "Longest Ollama may go without sending a line, including loading the model, 0 to disable")
+	flag.DurationVar(&FlushInterval, "flush-interval", FlushInterval, "Coalesce streamed text into one frame per interval, e.g. 50ms; 0 sends every chunk")
+	flag.IntVar(&FlushBytes, "flush-bytes", FlushBytes, "Coalesce streamed text into frames of at least this many bytes; 0 sends every chunk")
+	flag.DurationVar(&ReadHeaderTimeout, "read-header-timeout", ReadHeaderTimeout, "Longest a client may take to send a request's headers, 0 to disable")
+	flag.DurationVar(&ReadTimeout, "read-timeout", ReadTimeout, "Longest a client may take to send a whole request, WebSocket messages excepted, 0 to disable")
+	flag.DurationVar(&IdleTimeout, "http-idle-timeout", IdleTimeout, "How long a kept-alive HTTP connection may sit idle before it is closed, 0 to disable")
+	flag.DurationVar(&PingInterval, "ping-interval", PingInterval, "How often WebSocket clients are pinged; ones that miss two pings are disconnected. 0 to disable")
+	flag.BoolVar(&RawStream, "raw-stream", RawStream, "Forward Ollama's stream lines to WebSocket and /api/stream clients as is, in each frame's raw field")
+	flag.BoolVar(&upgrader.EnableCompression, "compress", upgrader.EnableCompression, "Deflate WebSocket frames for clients that support it, trading CPU for bandwidth on slow links")
+	keepAlive := flag.String("keep-alive", "", "How long Ollama keeps the model loaded after a reply, e.g. 30m, -1 for forever or 0 to unload at once (default: Ollama's 5m)")
+	flag.DurationVar(&ResumeGrace, "resume-grace", ResumeGrace, "How long a session's reply keeps generating for a disconnected client to resume it")
+	flag.IntVar(&OllamaRetries, "retries", OllamaRetries, "Times to retry when Ollama refuses the connection")
+	flag.IntVar(&StreamRetries, "stream-retries", StreamRetries, "Times to regenerate a reply whose stream from Ollama breaks off")
+	flag.StringVar(&AuthToken, "auth-token", os.Getenv("AUTH_TOKEN"), "Require this token for chat and API access (env: AUTH_TOKEN)")
+	allowedOrigins := flag.String("allowed-origins", "", "Comma-separated extra origins allowed to open a WebSocket in lan/ngrok mode")
+	corsOrigins := flag.String("cors-origins", "", "Comma-separated origins whose pages may call the HTTP API, or * for any (default: same-origin only)")
+	corsMethods := flag.String("cors-methods", strings.Join(CORSMethods, ","), "Comma-separated methods cross-origin API calls may use")
+	corsHeaders := flag.String("cors-headers", strings.Join(CORSHeaders, ","), "Comma-separated request headers cross-origin API calls may send")
+	tlsCert := flag.String("tls-cert", "", "TLS certificate file; serves HTTPS together with -tls-key")
+	tlsKey := flag.String("tls-key", "", "TLS private key file; serves HTTPS together with -tls-cert")
+	ratePerMinute := flag.Float64("rate", DefaultRatePerMinute, "Chat messages allowed per minute per client IP, 0 to disable")
+	rateBurst := flag.Int("rate-burst", DefaultRateBurst, "Chat messages a client IP may send at once before -rate applies")
+	flag.BoolVar(&RateLimitLoopback, "rate-limit-local", RateLimitLoopback, "Also rate limit clients on this machine")
+	flag.StringVar(&NgrokDomain, "ngrok-domain", os.Getenv("NGROK_DOMAIN"), "Reserved ngrok domain to serve on, for a stable URL (env: NGROK_DOMAIN)")
+	flag.StringVar(&NgrokTokenFile, "ngrok-token-file", os.Getenv("NGROK_AUTHTOKEN_FILE"), "File holding the ngrok authtoken, used when NGROK_AUTHTOKEN is not set (env: NGROK_AUTHTOKEN_FILE)")
+	flag.StringVar(&NgrokRegion, "ngrok-region", os.Getenv("NGROK_REGION"), "ngrok region to connect through, e.g. eu or ap (default: fastest; env: NGROK_REGION)")
+	flag.StringVar(&NgrokBasicAuth, "ngrok-basic-auth", os.Getenv("NGROK_BASIC_AUTH"), "user:password visitors of the ngrok URL must enter (env: NGROK_BASIC_AUTH)")
+	listenPort := flag.Int("port", envInt("PORT", 8080), "Port to listen on in local and lan mode; in ngrok mode, serves only /api/tunnel on localhost (env: PORT)")
+	flag.BoolVar(&ShowQR, "qr", ShowQR, "Print the lan or ngrok URL as a QR code to scan with a phone")
+	preferIP := flag.String("prefer-ip", "4", "IP version of the address advertised in lan mode: 4 or 6")
+	enableMetrics := flag.Bool("metrics", false, "Serve Prometheus metrics on /metrics")
+	sessionTTL := flag.Duration("session-ttl", DefaultSessionTTL, "How long an idle session's history is kept in memory")
+	historyDir := flag.String("history-dir", "", "Directory to persist session histories in as JSON files (default: memory only)")
+	docsPath := flag.String("docs", "", "JSON file to keep uploaded documents in; enables /api/docs and adds relevant passages to chats")
+	flag.IntVar(&DocsTopK, "docs-top-k", DocsTopK, "Document passages added to each chat turn when -docs is set")
+	flag.StringVar(&EmbedModel, "embed-model", EmbedModel, "Ollama model to embed documents and messages with when -docs is set")
+	sqlitePath := flag.String("sqlite", "", "SQLite database to persist session histories in (default: memory only)")
+	flag.Parse()
+
+	var cfg Config
+	if *configFile != "" {
+		var err error
+		if cfg, err = loadConfig(*configFile); err != nil {
+			log.Fatalf("❌ Invalid -config %s: %v", *configFile, err)
+		}
+	}
+	sources, err := applyConfig(flag.CommandLine, cfg)
+	if err != nil {
+		log.Fatalf("❌ Invalid -config %s: %v", *configFile, err)
+	}
+
+	if err := setupLogging(*logFormat, os.Stderr); err != nil {
+		log.Fatalf("❌ Invalid -log-format: %v", err)
+	}
+	if socket, ok := ollamaSocket(*ollamaURL); ok {
+		// The host is never dialed; every request goes to the socket
+		ollamaClient.Transport = unixSocketTransport(socket)
+		OllamaAPIURL = "http://ollama/api/chat"
+	} else if OllamaAPIURL, err = normalizeOllamaURL(*ollamaURL); err != nil {
+		log.Fatalf("❌ Invalid -ollama-url %q: %v", *ollamaURL, err)
+	}
+	if OllamaHeaders, err = parseHeaders(ollamaHeaders); err != nil {
+		log.Fatalf("❌ Invalid -ollama-header: %v", err)
+	}
+	if len(OllamaHeaders) > 0 {
+		ollamaClient.Transport = headerTransport{base: ollamaClient.Transport, header: OllamaHeaders}
+	}
+	if *mock {
+		ollamaClient.Transport = nil
+		if OllamaAPIURL, err = startMockOllama(); err != nil {
+			log.Fatalf("❌ Could not start -mock Ollama: %v", err)
+		}
+		slog.Warn("🎭 Mock mode: replies are canned echoes, Ollama is not used")
+	}
+	if *numGPU < -1 {
+		log.Fatalf("❌ Invalid -num-gpu: must be -1 or more, got %d", *numGPU)
+	}
+	if *numGPU >= 0 {
+		Sampling.NumGPU = numGPU
+	}
+	if err := Sampling.Validate(); err != nil {
+		log.Fatalf("❌ Invalid sampling options: %v", err)
+	}
+	FallbackModels = splitList(*fallbackModels)
+	if err := validateModelNames(FallbackModels); err != nil {
+		log.Fatalf("❌ Invalid -fallback-models: %v", err)
+	}
+	if WindowSize < 1 {
+		log.Fatalf("❌ Invalid -window: must be at least 1, got %d", WindowSize)
+	}
+	if MaxHistory != 0 && MaxHistory < WindowSize {
+		log.Fatalf("❌ Invalid -max-history: must be 0 or at least -window (%d), got %d", WindowSize, MaxHistory)
+	}
+	if TokenBudget < 0 {
+		log.Fatalf("❌ Invalid -token-budget: must not be negative, got %d", TokenBudget)
+	}
+	if MaxNumCtx < 0 {
+		log.Fatalf("❌ Invalid -max-num-ctx: must not be negative, got %d", MaxNumCtx)
+	}
+	if MaxTokens < 0 {
+		log.Fatalf("❌ Invalid -max-tokens: must not be negative, got %d", MaxTokens)
+	}
+	if MaxMessageBytes < 1024 {
+		log.Fatalf("❌ Invalid -max-message-size: must be at least 1024, got %d", MaxMessageBytes)
+	}
+	if MaxStreamLine < 64*1024 {
+		log.Fatalf("❌ Invalid -max-stream-line: must be at least 65536, got %d", MaxStreamLine)
+	}
+	if GenerationTimeout < 0 {
+		log.Fatalf("❌ Invalid -timeout: must not be negative, got %v", GenerationTimeout)
+	}
+	if StreamIdleTimeout < 0 {
+		log.Fatalf("❌ Invalid -idle-timeout: must not be negative, got %v", StreamIdleTimeout)
+	}
+	if FlushInterval < 0 {
+		log.Fatalf("❌ Invalid -flush-interval: must not be negative, got %v", FlushInterval)
+	}
+	if FlushBytes < 0 {
+		log.Fatalf("❌ Invalid -flush-bytes: must not be negative, got %d", FlushBytes)
+	}
+	if ReadHeaderTimeout < 0 {
+		log.Fatalf("❌ Invalid -read-header-timeout: must not be negative, got %v", ReadHeaderTimeout)
+	}
+	if ReadTimeout < 0 {
+		log.Fatalf("❌ Invalid -read-timeout: must not be negative, got %v", ReadTimeout)
+	}
+	if IdleTimeout < 0 {
+		log.Fatalf("❌ Invalid -http-idle-timeout: must not be negative, got %v", IdleTimeout)
+	}
+	if PingInterval < 0 {
+		log.Fatalf("❌ Invalid -ping-interval: must not be negative, got %v", PingInterval)
+	}
+	if ResumeGrace < 0 {
+		log.Fatalf("❌ Invalid -resume-grace: must not be negative, got %v", ResumeGrace)
+	}
+	if KeepAlive, err = parseKeepAlive(*keepAlive); err != nil {
+		log.Fatalf("❌ Invalid -keep-alive: %v", err)
+	}
+	if NgrokBasicAuth != "" {
+		if _, _, err := parseBasicAuth(NgrokBasicAuth); err != nil {
+			log.Fatalf("❌ Invalid -ngrok-basic-auth: %v", err)
+		}
+	}
+	if *listenPort < 1 || *listenPort > 65535 {
+		log.Fatalf("❌ Invalid -port: must be between 1 and 65535, got %d", *listenPort)
+	}
+	port := strconv.Itoa(*listenPort)
+	if *preferIP != "4" && *preferIP != "6" {
+		log.Fatalf("❌ Invalid -prefer-ip: must be 4 or 6, got %q", *preferIP)
+	}
+	if OllamaRetries < 0 {
+		log.Fatalf("❌ Invalid -retries: must not be negative, got %d", OllamaRetries)
+	}
+	if StreamRetries < 0 {
+		log.Fatalf("❌ Invalid -stream-retries: must not be negative, got %d", StreamRetries)
+	}
+	if DocsTopK < 0 {
+		log.Fatalf("❌ Invalid -docs-top-k: must not be negative, got %d", DocsTopK)
+	}
+	if MaxBatchJobs < 1 {
+		log.Fatalf("❌ Invalid -max-batch: must be at least 1, got %d", MaxBatchJobs)
+	}
+	if BatchParallelism < 1 {
+		log.Fatalf("❌ Invalid -batch-parallel: must be at least 1, got %d", BatchParallelism)
+	}
+	if *maxGenerations < 1 {
+		log.Fatalf("❌ Invalid -max-generations: must be at least 1, got %d", *maxGenerations)
+	}
+	generationSlots = make(chan struct{}, *maxGenerations)
+	if *ratePerMinute < 0 {
+		log.Fatalf("❌ Invalid -rate: must not be negative, got %v", *ratePerMinute)
+	}
+	if *ratePerMinute > 0 && *rateBurst < 1 {
+		log.Fatalf("❌ Invalid -rate-burst: must be at least 1, got %d", *rateBurst)
+	}
+	if (*tlsCert == "") != (*tlsKey == "") {
+		log.Fatal("❌ -tls-cert and -tls-key must be set together")
+	}
+	if *sessionTTL <= 0 {
+		log.Fatalf("❌ Invalid -session-ttl: must be positive, got %v", *sessionTTL)
+	}
+	if SystemPrompt == "" {
+		SystemPrompt = loadSystemPrompt(SystemPromptFile)
+	}
+	if *welcomeFile != "" {
+		if Welcome, err = loadWelcome(*welcomeFile); err != nil {
+			log.Fatalf("❌ Invalid -welcome-file: %v", err)
+		}
+	}
+	if Personas, err = loadPersonas(*personasFile); err != nil {
+		log.Fatalf("❌ Invalid -personas file %s: %v", *personasFile, err)
+	}
+	logSettings(flag.CommandLine, sources)
 
-	// 1. Setup Handlers (Once globally)
+	persist, err := openStorage(*historyDir, *sqlitePath)
+	if err != nil {
+		log.Fatalf("❌ Could not open storage: %v", err)
+	}
+	sessions = NewSessionStore(*sessionTTL, persist)
+	if n, err := sessions.Restore(); err != nil {
+		log.Fatalf("❌ Could not restore sessions: %v", err)
+	} else if persist != nil {
+		slog.Info("💾 Restored sessions from storage", "count", n)
+	}
+	go sessions.RunJanitor()
+
+	if *docsPath != "" {
+		if Docs, err = OpenDocStore(*docsPath); err != nil {
+			log.Fatalf("❌ Could not open -docs %s: %v", *docsPath, err)
+		}
+		slog.Info("📚 Loaded documents", "count", len(Docs.List()), "embed_model", EmbedModel)
+	}
+
+	if *ratePerMinute > 0 {
+		limiter = NewRateLimiter(*ratePerMinute, *rateBurst)
+	}
+
+	if _, err := loadHomeTemplate(); err != nil {
+		log.Fatalf("❌ Could not load %s: %v", HomeTemplateFile, err)
+	}
+
+	if !*mock {
+		state := checkOllama(context.Background())
+		if state == OllamaNotRunning && *autostart {
+			stopOllama, err := startOllama(context.Background(), "ollama")
+			if err != nil {
+				log.Fatalf("❌ Could not start Ollama: %v", err)
+			}
+			defer stopOllama()
+			state = OllamaRunning
+		}
+		if state == OllamaRunning {
+			checkModel(OllamaModel)
+		}
+	}
+
+	// 2. Setup Handlers (Once globally)
 	http.HandleFunc("/", handleHome)
-	http.HandleFunc("/ws", handleWebSocket)
+	http.HandleFunc("/ws", rateLimit(requireAuth(handleWebSocket)))
+	http.HandleFunc("/api/chat", rateLimit(requireAuth(handleChatAPI)))
+	http.HandleFunc("/api/models", requireAuth(handleModels))
+	http.HandleFunc("/api/version", requireAuth(handleVersion))
+	http.HandleFunc("/api/personas", requireAuth(handlePersonas))
+	http.HandleFunc("/api/generate", rateLimit(requireAuth(handleGenerate)))
+	http.HandleFunc("/api/batch", rateLimit(requireAuth(handleBatch)))
+	http.HandleFunc("/api/stream", rateLimit(requireAuth(handleStream)))
+	http.HandleFunc("/api/export", requireAuth(handleExport))
+	http.HandleFunc("/api/history", requireAuth(handleHistory))
+	http.HandleFunc("/api/pull", requireAuth(handlePull))
+	http.HandleFunc("/api/embeddings", rateLimit(requireAuth(handleEmbeddings)))
+	http.HandleFunc("/v1/chat/completions", rateLimit(requireAuth(handleOpenAIChat)))
+	http.HandleFunc("/healthz", handleHealthz)
+	if Docs != nil {
+		http.HandleFunc("/api/docs", requireAuth(handleDocs))
+	}
+	if *enableMetrics {
+		http.HandleFunc("/metrics", requireAuth(promhttp.Handler().ServeHTTP))
+	}
 
-	// 2. Parse Mode (Default to 'local')
+	// 3. Parse Mode (Default to 'local')
 	mode := "local"
-	if len(os.Args) > 1 {
-		mode = os.Args[1]
+	if cfg.Mode != "" {
+		mode = cfg.Mode
+	}
+	if flag.NArg() > 0 {
+		mode = flag.Arg(0)
 	}
 
-	// 3. Start Server based on mode
-	switch mode {
-	case "ngrok":
-		log.Println("🌍 Exposing server via ngrok...")
-		ExposeViaNgrok() // This blocks execution
-	case "lan":
-		ip, err := GetLocalIP()
-		if err != nil {
-			ip = "0.0.0.0"
+	// Browsers on any site can open a WebSocket to localhost, but exposed
+	// servers only accept their own pages plus the configured origins.
+	ServerMode = mode
+	AllowAllOrigins = mode == "local"
+	AllowedOrigins = splitList(*allowedOrigins)
+	CORSOrigins, CORSMethods, CORSHeaders = splitList(*corsOrigins), splitList(*corsMethods), splitList(*corsHeaders)
+
+	scheme := "http"
+	if *tlsCert != "" {
+		scheme = "https"
+		if mode == "ngrok" {
+			log.Println("ℹ️  ngrok already serves HTTPS; ignoring -tls-cert and -tls-key.")
 		}
-		port := ":8080"
-		log.Printf("🤖 LAN Server running at http://%s%s\n", ip, port)
-		// Listen on all interfaces
-		if err := http.ListenAndServe("0.0.0.0"+port, nil); err != nil {
-			log.Fatal(err)
+	}
+
+	if mode != "local" && AuthToken == "" {
+		log.Println("⚠️  Warning: No -auth-token set; anyone who can reach this server can chat with your model.")
+	}
+
+	// 4. Start Server based on mode
+	var handler http.Handler = http.DefaultServeMux
+	if len(CORSOrigins) > 0 {
+		handler = allowCORS(handler)
+	}
+	if *accessLog {
+		handler = logRequests(handler)
+	}
+	server := newServer(handler)
+	server.RegisterOnShutdown(func() {
+		wsConns.CloseAll(websocket.CloseGoingAway, "server shutting down")
+	})
+
+	go func() {
+		var err error
+		switch mode {
+		case "ngrok":
+			log.Println("🌍 Exposing server via ngrok...")
+			serveTunnelStatus(server, net.JoinHostPort("localhost", port))
+			err = ExposeViaNgrok(server)
+		case "lan":
+			ip, ipErr := GetLocalIP(*preferIP == "6")
+			if ipErr != nil {
+				ip = "0.0.0.0"
+			}
+			lanURL := scheme + "://" + net.JoinHostPort(ip, port)
+			slog.Info("🤖 LAN Server running", "url", lanURL)
+			printQR(os.Stdout, lanURL)
+			// Listen on all interfaces, IPv4 and IPv6
+			server.Addr = ":" + port
+			err = listenAndServe(server, *tlsCert, *tlsKey)
+		default: // "local"
+			slog.Info("🤖 Local Server running", "url", scheme+"://"+net.JoinHostPort("localhost", port))
+			// Listen strictly on localhost
+			server.Addr = net.JoinHostPort("localhost", port)
+			err = listenAndServe(server, *tlsCert, *tlsKey)
 		}
-	default: // "local"
-		port := ":8080"
-		log.Printf("🤖 Local Server running at http://localhost%s\n", port)
-		// Listen strictly on localhost
-		if err := http.ListenAndServe("localhost"+port, nil); err != nil {
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			log.Fatal(err)
 		}
+	}()
+
+	waitForShutdown(server) // This blocks execution
+}
+
+// openStorage returns the durable session backend selected by flags, or
+// nil for the default in-memory mode.
+func openStorage(historyDir, sqlitePath string) (Storage, error) {
+	switch {
+	case historyDir != "" && sqlitePath != "":
+		return nil, fmt.Errorf("use either -history-dir or -sqlite, not both")
+	case historyDir != "":
+		return NewFileStore(historyDir)
+	case sqlitePath != "":
+		return NewSQLiteStore(sqlitePath)
 	}
+	return nil, nil
 }
 
-func checkOllama() {
-	_, err := exec.LookPath("ollama")
-	if err != nil {
-		log.Println("⚠️  Warning: Ollama is not installed or not in your PATH.")
-		switch runtime.GOOS {
-		case "windows":
-			log.Println("👉 Download: https://ollama.com/download/windows")
-		case "darwin":
-			log.Println("👉 Download: https://ollama.com/download/mac")
-		default:
-			log.Println("👉 Run: curl -fsSL https://ollama.com/install.sh | sh")
+// splitList splits a comma-separated flag value, dropping empty items.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
 		}
-	} else {
-		log.Println("✅ Ollama found.")
 	}
+	return items
 }
 
-func ExposeViaNgrok() {
-	if err := runNgrok(context.Background()); err != nil {
-		log.Fatal(err)
+// envOr returns the value of the environment variable key, or fallback if it is unset.
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
 	}
+	return fallback
 }
 
-func runNgrok(ctx context.Context) error {
-	log.Println("[Debug] Getting Authtoken from environment...")
-
-	// Check if token exists
-	token := os.Getenv("NGROK_AUTHTOKEN")
-	if token == "" {
-		return fmt.Errorf("❌ ERROR: NGROK_AUTHTOKEN is empty. Please export it before running")
+// envInt returns the integer value of the environment variable key, or
+// fallback if it is unset or not a number.
+func envInt(key string, fallback int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
 	}
-	log.Println("[Debug] Token found. Attempting to connect to Ngrok Cloud...")
-
-	// Attempt connection
-	listener, err := ngrok.Listen(ctx,
-		config.HTTPEndpoint(),
-		ngrok.WithAuthtokenFromEnv(),
-	)
+	n, err := strconv.Atoi(v)
 	if err != nil {
-		log.Printf("[Debug] ❌ Connection Failed: %v\n", err)
-		return err
+		log.Printf("⚠️  Warning: Ignoring %s=%q, not a number\n", key, v)
+		return fallback
 	}
-
-	// Success
-	log.Println("Debug] ✅ Connection Success!")
-	log.Println("Ingress established at:", listener.URL())
-
-	// Serve
-	return http.Serve(listener, nil)
+	return n
 }
 
-func GetLocalIP() (string, error) {
-	conn, err := net.Dial("udp", "8.8.8.8:80")
+// loadSystemPrompt reads the system prompt from path, falling back to
+// DefaultSystemPrompt if the file is missing or empty.
+func loadSystemPrompt(path string) string {
+	data, err := os.ReadFile(path)
 	if err != nil {
-		return "", err
+		return DefaultSystemPrompt
+	}
+	prompt := strings.TrimSpace(string(data))
+	if prompt == "" {
+		return DefaultSystemPrompt
 	}
-	defer conn.Close()
-	localAddr := conn.LocalAddr().(*net.UDPAddr)
-	return localAddr.IP.String(), nil
+	log.Printf("📝 Loaded system prompt from %s\n", path)
+	return prompt
 }
 
-// --- Handlers ---
+// OllamaState is what checkOllama found at startup.
+type OllamaState int
 
-func handleHome(w http.ResponseWriter, r *http.Request) {
-	if r.URL.Path != "/" {
-		http.NotFound(w, r)
+const (
+	OllamaNotInstalled OllamaState = iota // No server answers and there is no ollama binary
+	OllamaNotRunning                      // The binary is installed but its server doesn't answer
+	OllamaRunning                         // The server answers, wherever it runs
+)
+
+// checkOllama probes the Ollama server and, if it doesn't answer, looks for
+// the ollama binary on the PATH, to tell users whether they need to install
+// Ollama or just start it.
+func checkOllama(ctx context.Context) OllamaState {
+	probeErr := pingOllama(ctx)
+	if probeErr == nil {
+		log.Println("✅ Ollama is running.")
+		return OllamaRunning
+	}
+	if _, err := exec.LookPath("ollama"); err == nil {
+		log.Printf("⚠️  Warning: Ollama is installed but not running (%v).\n", probeErr)
+		if runtime.GOOS == "darwin" || runtime.GOOS == "windows" {
+			log.Println("👉 Start the Ollama app, or run: ollama serve")
+		} else {
+			log.Println("👉 Run: ollama serve")
+		}
+		return OllamaNotRunning
+	}
+
+	log.Println("⚠️  Warning: Ollama is not installed or not in your PATH.")
+	switch runtime.GOOS {
+	case "windows":
+		log.Println("👉 Download: https://ollama.com/download/windows")
+	case "darwin":
+		log.Println("👉 Download: https://ollama.com/download/mac")
+	default:
+		log.Println("👉 Run: curl -fsSL https://ollama.com/install.sh | sh")
+	}
+	return OllamaNotInstalled
+}
+
+// checkModel warns if model has not been pulled, according to `ollama list`.
+// It does nothing without the ollama binary, as when Ollama runs elsewhere.
+func checkModel(model string) {
+	if _, err := exec.LookPath("ollama"); err != nil {
 		return
 	}
-	tmpl, err := template.ParseFiles("index.html")
+	out, err := exec.Command("ollama", "list").Output()
 	if err != nil {
-		http.Error(w, "Could not load template: "+err.Error(), http.StatusInternalServerError)
+		log.Printf("⚠️  Warning: Could not run `ollama list` to verify model %q: %v\n", model, err)
 		return
 	}
-	tmpl.Execute(w, nil)
+	if !modelListed(string(out), model) {
+		log.Printf("⚠️  Warning: Model %q not found in `ollama list`.\n", model)
+		log.Printf("👉 Run: ollama pull %s\n", model)
+		return
+	}
+	log.Printf("✅ Model %s found.\n", model)
 }
 
-func handleWebSocket(w http.ResponseWriter, r *http.Request) {
-	conn, err := upgrader.Upgrade(w, r, nil)
+// modelListed reports whether model appears in the NAME column of `ollama list` output.
+// A model given without a tag matches its ":latest" variant.
+func modelListed(list, model string) bool {
+	lines := strings.Split(list, "\n")
+	for _, line := range lines[1:] { // Skip the header row
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == model || fields[0] == model+":latest" {
+			return true
+		}
+	}
+	return false
+}
+
+// listenAndServe serves HTTPS when a certificate is configured and plain HTTP otherwise.
+func listenAndServe(server *http.Server, certFile, keyFile string) error {
+	if certFile != "" {
+		return server.ListenAndServeTLS(certFile, keyFile)
+	}
+	return server.ListenAndServe()
+}
+
+// ExposeViaNgrok serves server through an ngrok tunnel until it is shut
+// down, reconnecting whenever the tunnel drops.
+func ExposeViaNgrok(server *http.Server) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	server.RegisterOnShutdown(cancel)
+	return runNgrok(ctx, server)
+}
+
+// NgrokDomain, NgrokRegion and NgrokBasicAuth configure the ngrok tunnel:
+// a reserved domain for a stable URL, the region to connect through (ngrok
+// picks the fastest when empty), and "user:password" credentials visitors
+// must enter. They are set once in main.
+var (
+	NgrokDomain    string
+	NgrokRegion    string
+	NgrokBasicAuth string
+)
+
+// NgrokTokenFile holds the ngrok authtoken for deployments that keep
+// secrets in files, such as Docker secrets. NGROK_AUTHTOKEN takes
+// precedence over it. It is set once in main.
+var NgrokTokenFile string
+
+// ngrokToken returns the authtoken from NGROK_AUTHTOKEN or, if that is
+// unset, from NgrokTokenFile.
+func ngrokToken() (string, error) {
+	if token := os.Getenv("NGROK_AUTHTOKEN"); token != "" {
+		return token, nil
+	}
+	if NgrokTokenFile == "" {
+		return "", fmt.Errorf("NGROK_AUTHTOKEN is empty. Please export it, or pass -ngrok-token-file, before running")
+	}
+	data, err := os.ReadFile(NgrokTokenFile)
 	if err != nil {
-		log.Println("Upgrade error:", err)
-		return
+		return "", fmt.Errorf("reading the ngrok authtoken: %w", err)
 	}
-	defer conn.Close()
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("the ngrok authtoken file %s is empty", NgrokTokenFile)
+	}
+	return token, nil
+}
 
-	Messages := make([]OllamaMessage, 0)
+// ngrokErrEndpointOnline is the ngrok error code for a domain that another
+// tunnel is already serving.
+const ngrokErrEndpointOnline = "ERR_NGROK_334"
 
-	for {
-		var req ChatRequest
-		err := conn.ReadJSON(&req)
+// parseBasicAuth splits "user:password" credentials, enforcing the
+// password length ngrok requires.
+func parseBasicAuth(value string) (user, password string, err error) {
+	user, password, ok := strings.Cut(value, ":")
+	if !ok || user == "" {
+		return "", "", fmt.Errorf("want user:password")
+	}
+	if len(password) < 8 || len(password) > 128 {
+		return "", "", fmt.Errorf("ngrok requires a password of 8 to 128 characters")
+	}
+	return user, password, nil
+}
+
+func runNgrok(ctx context.Context, server *http.Server) error {
+	token, err := ngrokToken()
+	if err != nil {
+		return fmt.Errorf("❌ ERROR: %w", err)
+	}
+	slog.Info("Connecting to ngrok", "domain", NgrokDomain, "region", NgrokRegion)
+
+	var endpointOpts []config.HTTPEndpointOption
+	if NgrokDomain != "" {
+		endpointOpts = append(endpointOpts, config.WithDomain(NgrokDomain))
+	}
+	if NgrokBasicAuth != "" {
+		user, password, err := parseBasicAuth(NgrokBasicAuth)
 		if err != nil {
-			log.Println("Client disconnected:", err)
-			break
+			return fmt.Errorf("❌ Invalid ngrok basic auth: %w", err)
 		}
+		endpointOpts = append(endpointOpts, config.WithBasicAuth(user, password))
+	}
 
-		err = streamOllama(conn, req.Message, &Messages)
+	return serveTunnels(ctx, server, func(ctx context.Context) (tunnel, error) {
+		listener, err := ngrok.Listen(ctx,
+			config.HTTPEndpoint(endpointOpts...),
+			ngrok.WithAuthtoken(token),
+			ngrok.WithRegion(NgrokRegion),
+		)
 		if err != nil {
-			log.Println("Ollama error:", err)
-			conn.WriteJSON(StreamResponse{Chunk: "Error: " + err.Error(), Done: true})
+			var nerr ngrok.Error
+			if errors.As(err, &nerr) && nerr.ErrorCode() == ngrokErrEndpointOnline {
+				return nil, fmt.Errorf("❌ ngrok domain %s is already in use by another tunnel; stop it or pick another -ngrok-domain", NgrokDomain)
+			}
+			return nil, fmt.Errorf("ngrok connection failed: %w", err)
 		}
-	}
+		return listener, nil
+	})
 }
 
-func streamOllama(ws *websocket.Conn, userPrompt string, messages *[]OllamaMessage) error {
-	*messages = append(*messages, OllamaMessage{Role: "user", Content: userPrompt})
+// GetLocalIP returns the address other machines on the LAN can reach this
+// one on, preferring IPv6 when preferV6 is set and falling back to the other
+// family when there is none. Dialing out (UDP, so nothing is sent) finds the
+// interface of the default route; without a route to the internet, as on
+// air-gapped networks, it falls back to the interfaces' own addresses.
+func GetLocalIP(preferV6 bool) (string, error) {
+	ip, err := localIP(preferV6)
+	if err != nil {
+		var otherErr error
+		if ip, otherErr = localIP(!preferV6); otherErr != nil {
+			return "", err
+		}
+	}
+	return ip, nil
+}
 
-	const WindowSize = 10
-	systemMessage := OllamaMessage{
-		Role:    "system",
-		Content: "You are an assistant who speaks in gangster slang.",
+// localIP returns this machine's LAN address of one IP family.
+func localIP(v6 bool) (string, error) {
+	network, probe := "udp4", "8.8.8.8:80"
+	if v6 {
+		network, probe = "udp6", "[2001:4860:4860::8888]:80"
+	}
+	conn, err := net.Dial(network, probe)
+	if err == nil {
+		defer conn.Close()
+		localAddr := conn.LocalAddr().(*net.UDPAddr)
+		return localAddr.IP.String(), nil
 	}
 
-	// Sliding Window Logic
-	messagesToSend := []OllamaMessage{systemMessage}
-	var recentMessages []OllamaMessage
-	if len(*messages) > WindowSize {
-		recentMessages = (*messages)[len(*messages)-WindowSize:]
-	} else {
-		recentMessages = *messages
+	ifaces, ifErr := net.Interfaces()
+	if ifErr != nil {
+		return "", fmt.Errorf("%w; listing interfaces: %w", err, ifErr)
+	}
+	var ips []net.IP
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, _ := iface.Addrs()
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok {
+				ips = append(ips, ipNet.IP)
+			}
+		}
 	}
-	messagesToSend = append(messagesToSend, recentMessages...)
+	if ip := pickLANIP(ips, v6); ip != nil {
+		return ip.String(), nil
+	}
+	return "", fmt.Errorf("%w; and no interface has a LAN %s address", err, network[3:])
+}
 
-	reqBody := OllamaRequest{
-		Model:    "gemma3:1b", // Ensure this model exists!
-		Messages: messagesToSend,
-		Stream:   true,
-		Options: map[string]interface{}{
-			"temperature": 0.5,
-			"top_k":       1,
-			"top_p":       0.9,
-		},
+// pickLANIP returns the first private address of the chosen family in ips
+// (RFC 1918 for IPv4, unique local for IPv6), or failing that the first
+// other one that isn't loopback or link-local.
+func pickLANIP(ips []net.IP, v6 bool) net.IP {
+	var fallback net.IP
+	for _, ip := range ips {
+		if (ip.To4() == nil) != v6 || ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+			continue
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		}
+		if ip.IsPrivate() {
+			return ip
+		}
+		if fallback == nil {
+			fallback = ip
+		}
 	}
+	return fallback
+}
+
+// HomeTemplateFile is the chat page served on /.
+const HomeTemplateFile = "index.html"
+
+// ServerMode is how the server is exposed: local, lan or ngrok. It is set once in main.
+var ServerMode = "local"
+
+// HomeData is what HomeTemplateFile is rendered with.
+type HomeData struct {
+	Model    string // The default model
+	Mode     string // ServerMode
+	WSPath   string // Path of the chat WebSocket; the page picks ws or wss itself
+	Personas []Persona
+}
 
-	jsonPayload, _ := json.Marshal(reqBody)
-	req, err := http.NewRequest("POST", OllamaAPIURL, bytes.NewBuffer(jsonPayload))
+var (
+	homeTemplateOnce sync.Once
+	homeTemplate     *template.Template
+	homeTemplateErr  error
+)
+
+// loadHomeTemplate parses HomeTemplateFile the first time it is called and
+// returns the same result ever after, falling back to the built-in
+// fallbackHomeHTML if the file is missing. main calls it at startup so a
+// broken template stops the server instead of failing every page load.
+func loadHomeTemplate() (*template.Template, error) {
+	homeTemplateOnce.Do(func() {
+		homeTemplate, homeTemplateErr = template.ParseFiles(HomeTemplateFile)
+		if errors.Is(homeTemplateErr, fs.ErrNotExist) {
+			slog.Warn("⚠️  Page template not found, serving a basic built-in page", "file", HomeTemplateFile)
+			homeTemplate, homeTemplateErr = template.New("fallback").Parse(fallbackHomeHTML)
+		}
+	})
+	return homeTemplate, homeTemplateErr
+}
+
+// --- Handlers ---
+
+func handleHome(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	tmpl, err := loadHomeTemplate()
 	if err != nil {
-		return err
+		http.Error(w, "Could not load template: "+err.Error(), http.StatusInternalServerError)
+		return
 	}
-	req.Header.Set("Content-Type", "application/json")
+	tmpl.Execute(w, HomeData{Model: OllamaModel, Mode: ServerMode, WSPath: "/ws", Personas: Personas})
+}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+func handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		return err
+		log.Println("Upgrade error:", err)
+		return
 	}
-	defer resp.Body.Close()
+	defer closeWebSocket(conn, websocket.CloseNormalClosure, "")
+	wsConns.Add(conn)
+	defer wsConns.Remove(conn)
+	activeConnections.Inc()
+	defer activeConnections.Dec()
+	// Both the reader below and the turns write frames
+	frames := &frameConn{conn: conn}
+	if frame, ok := welcomeFrame(); ok {
+		frames.WriteJSON(frame)
+	}
+
+	// ctx is cancelled as soon as the client goes away, which aborts any
+	// in-flight Ollama request instead of letting it generate for nobody.
+	// Session replies get ResumeGrace to be resumed first.
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	heardFrom := keepAlive(ctx, conn, PingInterval)
+	limit := MaxMessageBytes
+	conn.SetReadLimit(limit * readLimitFactor)
+
+	// cancelTurn stops the reply currently being generated, and is nil
+	// while there is none. turnConversation is the conversation_id the
+	// reply answers.
+	var (
+		turnMu           sync.Mutex
+		cancelTurn       context.CancelFunc
+		turnConversation string
+	)
 
-	scanner := bufio.NewScanner(resp.Body)
-	var fullBotResponse strings.Builder
+	// Read in the background so a disconnect or stop request is noticed
+	// even mid-stream. Messages are handled one at a time, in order; one
+	// for the conversation whose reply is being generated is refused as
+	// busy rather than silently waiting behind a reply that may run for
+	// minutes. Those for other conversations, resets and configures wait
+	// their turn. After a stop, the next message waits for the stopped reply.
+	requests := make(chan ChatRequest, MaxQueuedMessages)
+	go func() {
+		defer cancel()
+		defer close(requests)
+		for {
+			var req ChatRequest
+			if err := readRequest(conn, limit, &req); errors.Is(err, errMessageTooLarge) {
+				frames.WriteJSON(messageTooLargeFrame(limit))
+				continue
+			} else if err != nil {
+				logDisconnect(err)
+				return
+			}
+			heardFrom()
+			turnMu.Lock()
+			generating := cancelTurn != nil && req.ConversationID == turnConversation
+			if req.Type == MessageTypeStop && generating {
+				// The stopped reply is winding down, so what follows waits for it
+				cancelTurn()
+				cancelTurn = nil
+			}
+			turnMu.Unlock()
+			if req.Type == MessageTypeStop {
+				continue
+			}
+			if !generating || req.Type == MessageTypeReset || req.Type == MessageTypeConfigure {
+				select {
+				case requests <- req:
+					continue
+				default:
+				}
+			}
+			withConversation(frames, req.ConversationID).WriteJSON(busyFrame())
+		}
+	}()
 
-	for scanner.Scan() {
-		line := scanner.Bytes()
+	// conversations belongs to this goroutine alone: the reader hands it
+	// requests over the channel, and reaches into a running turn only via
+	// cancelTurn, under turnMu. Keep it that way rather than sharing it. It
+	// holds the connection's histories by conversation_id, "" by default.
+	conversations := map[string][]OllamaMessage{}
+	var settings connSettings
+	ip := clientIP(r)
 
-		var responseObj map[string]interface{}
-		if err := json.Unmarshal(line, &responseObj); err != nil {
+	for req := range requests {
+		if req.Type == MessageTypeResume {
+			// Replay and follow the reply a dropped connection left behind
+			if p := pendingTurns.get(req.SessionID); p != nil && req.SessionID != "" {
+				turnMu.Lock()
+				cancelTurn, turnConversation = p.cancel, req.ConversationID
+				turnMu.Unlock()
+				p.attach(frames)
+				go p.watch(ctx, frames)
+				select {
+				case <-p.done:
+				case <-ctx.Done():
+				}
+				turnMu.Lock()
+				cancelTurn = nil
+				turnMu.Unlock()
+			}
 			continue
 		}
 
-		if content, ok := responseObj["message"].(map[string]interface{}); ok {
-			if text, ok := content["content"].(string); ok {
-				ws.WriteJSON(StreamResponse{Chunk: text, Done: false})
-				fullBotResponse.WriteString(text)
+		// Every frame answering a conversation's message carries its id
+		reply := withConversation(frames, req.ConversationID)
+		if err := checkConversation(conversations, req.ConversationID); err != nil {
+			reply.WriteJSON(errorFrame(CodeInvalidRequest, err.Error()))
+			continue
+		}
+		if req.Type == MessageTypeReset {
+			// The system prompt isn't part of the history, so the next turn still gets it
+			if req.SessionID != "" && validSessionID(req.SessionID) {
+				sessions.Clear(req.SessionID)
 			}
+			delete(conversations, req.ConversationID)
+			reply.WriteJSON(StreamResponse{Status: StatusReset, Done: true})
+			continue
+		}
+		if req.Type == MessageTypeConfigure {
+			s, err := req.configure()
+			if err != nil {
+				reply.WriteJSON(errorFrame(CodeInvalidRequest, err.Error()))
+				continue
+			}
+			settings = s
+			reply.WriteJSON(StreamResponse{Status: StatusConfigured, Done: true})
+			continue
+		}
+		req = settings.apply(req)
+		if !limiter.Allow(ip) {
+			reply.WriteJSON(errorFrame(CodeRateLimited, "rate limit exceeded, please slow down"))
+			continue
+		}
+		if req.SessionID != "" && !validSessionID(req.SessionID) {
+			reply.WriteJSON(errorFrame(CodeInvalidRequest, "invalid session_id"))
+			continue
+		}
+		model, err := resolveModel(req.Model)
+		if err != nil {
+			reply.WriteJSON(errorFrame(CodeInvalidRequest, err.Error()))
+			continue
+		}
+		if err := validateImages(ctx, model, req.Images); err != nil {
+			reply.WriteJSON(errorFrame(CodeInvalidRequest, err.Error()))
+			continue
+		}
+		opts, err := req.options()
+		if err != nil {
+			reply.WriteJSON(errorFrame(CodeInvalidRequest, err.Error()))
+			continue
 		}
-	}
 
-	// Check for scanner errors (e.g., connection cut mid-stream)
-	if err := scanner.Err(); err != nil {
-		log.Println("Stream scan error:", err)
-	}
+		// Clients that send a session_id share history across reconnects;
+		// everyone else keeps histories private to this connection, one per
+		// conversation_id.
+		history := conversations[req.ConversationID]
+		if req.SessionID != "" {
+			history = sessions.Load(req.SessionID)
+		}
+		turn, prompt := history, req.prompt()
+		if req.Type == MessageTypeRegenerate {
+			if turn, prompt, err = lastTurn(history); err != nil {
+				reply.WriteJSON(errorFrame(CodeInvalidRequest, err.Error()))
+				continue
+			}
+			opts = regenerateOptions(opts)
+		}
 
-	*messages = append(*messages, OllamaMessage{
-		Role:    "assistant",
-		Content: fullBotResponse.String(),
-	})
+		// A session's reply outlives a dropped connection by ResumeGrace, so
+		// the client can reconnect and resume it. Others end with the connection.
+		var (
+			out           = reply
+			pending       *pendingTurn
+			turnCtx, stop = context.WithCancel(ctx)
+		)
+		if req.SessionID != "" {
+			turnCtx, stop = context.WithCancel(context.WithoutCancel(ctx))
+			pending = pendingTurns.start(req.SessionID, frames, stop)
+			out = withConversation(pending, req.ConversationID)
+			go pending.watch(ctx, frames)
+		}
+		turnMu.Lock()
+		cancelTurn, turnConversation = stop, req.ConversationID
+		turnMu.Unlock()
+
+		err = streamOllama(turnCtx, out, prompt, &turn, model, opts)
+		stopped := turnCtx.Err() != nil // Checked before stop() cancels it too
+		stop()
+		turnMu.Lock()
+		cancelTurn = nil
+		turnMu.Unlock()
+		if err == nil {
+			history = turn
+		}
 
-	return ws.WriteJSON(StreamResponse{Chunk: "", Done: true})
+		if req.SessionID != "" {
+			sessions.Save(req.SessionID, history)
+		} else {
+			conversations[req.ConversationID] = capHistory(history)
+		}
+		switch {
+		case err == nil:
+			if title := titleSession(ctx, req.SessionID, model, history); title != "" {
+				out.WriteJSON(StreamResponse{Status: StatusTitled, Title: title})
+			}
+		case pending == nil && (ctx.Err() != nil || errors.Is(err, ErrClientGone)):
+			// The client is gone; there is nobody left to tell.
+		case stopped:
+			// Stopped by the client before Ollama answered; let the UI reset.
+			out.WriteJSON(StreamResponse{Chunk: "", Done: true})
+		default:
+			ollamaFailures.WithLabelValues(model).Inc()
+			slog.Error("Ollama error", "session", req.SessionID, "model", model, "error", err)
+			out.WriteJSON(ollamaErrorFrame(err))
+		}
+		if pending != nil {
+			pendingTurns.finish(req.SessionID, pending)
+		}
+	}
 }
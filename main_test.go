@@ -1,10 +1,17 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"html/template"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -28,8 +35,15 @@ func mockOllamaServer() *httptest.Server {
 			return
 		}
 
-		// Simulate streaming response
 		w.Header().Set("Content-Type", "application/json")
+
+		// Non-streaming requests get the whole reply in one object
+		if !req.Stream {
+			w.Write([]byte(`{"message": {"role": "assistant", "content": "Hello World"}, "done": true}`))
+			return
+		}
+
+		// Simulate streaming response
 		
 		// Chunk 1
 		chunk1 := `{"message": {"content": "Hello "}}`
@@ -40,6 +54,12 @@ func mockOllamaServer() *httptest.Server {
 		chunk2 := `{"message": {"content": "World"}}`
 		w.Write([]byte(chunk2 + "\n"))
 		w.(http.Flusher).Flush()
+
+		// Final stats line carries no content
+		final := `{"model": "gemma3:1b", "message": {"role": "assistant", "content": ""}, "done": true, "done_reason": "stop",` +
+			`"prompt_eval_count": 26, "eval_count": 2, "eval_duration": 500000000, "total_duration": 900000000}`
+		w.Write([]byte(final + "\n"))
+		w.(http.Flusher).Flush()
 	}))
 }
 
@@ -74,6 +94,26 @@ func TestHandleHome(t *testing.T) {
 	}
 }
 
+// BenchmarkHomeParseEachTime measures serving the page the old way, parsing
+// index.html on every request, for comparison with BenchmarkHandleHome.
+func BenchmarkHomeParseEachTime(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		tmpl, err := template.ParseFiles(HomeTemplateFile)
+		if err != nil {
+			b.Fatal(err)
+		}
+		tmpl.Execute(io.Discard, nil)
+	}
+}
+
+// BenchmarkHandleHome measures serving the page from the cached template.
+func BenchmarkHandleHome(b *testing.B) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	for i := 0; i < b.N; i++ {
+		handleHome(httptest.NewRecorder(), req)
+	}
+}
+
 // TestWebSocketFlow tests the full end-to-end WebSocket conversation
 // using a mocked Ollama server.
 func TestWebSocketFlow(t *testing.T) {
@@ -133,6 +173,82 @@ func TestWebSocketFlow(t *testing.T) {
 	}
 }
 
+// countingConn counts the bytes read from a connection.
+type countingConn struct {
+	net.Conn
+	read *int64
+}
+
+func (c countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	*c.read += int64(n)
+	return n, err
+}
+
+// TestWebSocketCompression verifies that with -compress a long reply
+// reaches a client that supports compression in fewer bytes.
+func TestWebSocketCompression(t *testing.T) {
+	text := strings.Repeat("The quick brown fox jumps over the lazy dog. ", 400)
+	mockOllama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/tags" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"message": OllamaMessage{Role: "assistant", Content: text}, "done": true})
+	}))
+	defer mockOllama.Close()
+
+	oldURL, oldCompression := OllamaAPIURL, upgrader.EnableCompression
+	OllamaAPIURL = mockOllama.URL
+	defer func() { OllamaAPIURL, upgrader.EnableCompression = oldURL, oldCompression }()
+
+	server := httptest.NewServer(http.HandlerFunc(handleWebSocket))
+	defer server.Close()
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	// received chats once and returns the bytes the reply took on the wire
+	received := func(compress bool) int64 {
+		upgrader.EnableCompression = compress
+		var read int64
+		dialer := websocket.Dialer{
+			EnableCompression: true,
+			NetDial: func(network, addr string) (net.Conn, error) {
+				conn, err := net.Dial(network, addr)
+				return countingConn{conn, &read}, err
+			},
+		}
+		ws, _, err := dialer.Dial(wsURL, nil)
+		if err != nil {
+			t.Fatalf("could not open websocket connection: %v", err)
+		}
+		defer ws.Close()
+		if err := ws.WriteJSON(ChatRequest{Message: "Tell me a long story"}); err != nil {
+			t.Fatalf("could not write json: %v", err)
+		}
+		ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+		var got strings.Builder
+		for {
+			var resp StreamResponse
+			if err := ws.ReadJSON(&resp); err != nil {
+				t.Fatalf("Read failed or timed out: %v", err)
+			}
+			got.WriteString(resp.Chunk)
+			if resp.Done {
+				break
+			}
+		}
+		if got.String() != text {
+			t.Fatalf("compress %v: got a reply of %d bytes, want %d", compress, got.Len(), len(text))
+		}
+		return read
+	}
+
+	plain, compressed := received(false), received(true)
+	if compressed*4 > plain {
+		t.Errorf("got %d bytes compressed, %d plain; want at most a quarter", compressed, plain)
+	}
+}
+
 // TestSlidingWindowLogic verifies the logic for truncating message history.
 func TestSlidingWindowLogic(t *testing.T) {
 	// Create a fake history of 60 messages
@@ -141,19 +257,12 @@ func TestSlidingWindowLogic(t *testing.T) {
 		history = append(history, OllamaMessage{Role: "user", Content: "msg"})
 	}
 
-	// Simulate logic from streamOllama
-	const WindowSize = 50
-	systemMessage := OllamaMessage{Role: "system", Content: "Sys"}
-	
-	messagesToSend := []OllamaMessage{systemMessage}
-	var recentMessages []OllamaMessage
-	
-	if len(history) > WindowSize {
-		recentMessages = history[len(history)-WindowSize:]
-	} else {
-		recentMessages = history
-	}
-	messagesToSend = append(messagesToSend, recentMessages...)
+	// Run the same logic streamOllama uses
+	oldWindow := WindowSize
+	WindowSize = 50
+	defer func() { WindowSize = oldWindow }()
+
+	messagesToSend := buildOllamaRequest(history, "test", Sampling, true).Messages
 
 	// Assertions
 	expectedLength := 1 + 50 // 1 System + 50 Recent
@@ -165,4 +274,363 @@ func TestSlidingWindowLogic(t *testing.T) {
 	if messagesToSend[0].Role != "system" {
 		t.Error("First message should be system prompt")
 	}
-}
\ No newline at end of file
+}
+
+// TestSamplingOptionsValidate verifies that out-of-range sampling flags are rejected.
+func TestSamplingOptionsValidate(t *testing.T) {
+	cases := []struct {
+		name    string
+		opts    SamplingOptions
+		wantErr bool
+	}{
+		{"defaults", Sampling, false},
+		{"temperature too high", SamplingOptions{Temperature: 2.5, TopK: 1, TopP: 0.9}, true},
+		{"negative temperature", SamplingOptions{Temperature: -1, TopK: 1, TopP: 0.9}, true},
+		{"zero top_k", SamplingOptions{Temperature: 0.5, TopK: 0, TopP: 0.9}, true},
+		{"top_p above one", SamplingOptions{Temperature: 0.5, TopK: 1, TopP: 1.5}, true},
+		{"stop sequences", SamplingOptions{Temperature: 0.5, TopK: 1, TopP: 0.9, Stop: []string{"\n\n", "User:"}}, false},
+		{"empty stop sequence", SamplingOptions{Temperature: 0.5, TopK: 1, TopP: 0.9, Stop: []string{""}}, true},
+	}
+
+	for _, tc := range cases {
+		err := tc.opts.Validate()
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: Validate() error = %v, wantErr %v", tc.name, err, tc.wantErr)
+		}
+	}
+}
+
+// TestResolveModel verifies the per-message model override and its validation.
+func TestResolveModel(t *testing.T) {
+	cases := []struct {
+		requested string
+		want      string
+		wantErr   bool
+	}{
+		{"", OllamaModel, false},
+		{"  ", OllamaModel, false},
+		{"llama3.2", "llama3.2", false},
+		{"hf.co/user/repo:Q4_K_M", "hf.co/user/repo:Q4_K_M", false},
+		{"bad model", "", true},
+		{"-rm", "", true},
+		{strings.Repeat("a", 300), "", true},
+	}
+
+	for _, tc := range cases {
+		got, err := resolveModel(tc.requested)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("resolveModel(%q) error = %v, wantErr %v", tc.requested, err, tc.wantErr)
+		}
+		if got != tc.want {
+			t.Errorf("resolveModel(%q) = %q, want %q", tc.requested, got, tc.want)
+		}
+	}
+}
+
+// TestNormalizeOllamaURL verifies that hosts, ports and proxy prefixes all
+// end up pointing at the chat endpoint.
+func TestNormalizeOllamaURL(t *testing.T) {
+	cases := []struct {
+		raw, want string
+	}{
+		{"http://localhost:11434/api/chat", "http://localhost:11434/api/chat"},
+		{"http://gpu-box:11434/", "http://gpu-box:11434/api/chat"},
+		{"gpu-box", "http://gpu-box:11434/api/chat"},
+		{"10.0.0.5:8000", "http://10.0.0.5:8000/api/chat"},
+		{"https://example.com/ollama", "https://example.com/ollama/api/chat"},
+	}
+	for _, tc := range cases {
+		got, err := normalizeOllamaURL(tc.raw)
+		if err != nil {
+			t.Errorf("normalizeOllamaURL(%q) failed: %v", tc.raw, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("normalizeOllamaURL(%q) = %q, want %q", tc.raw, got, tc.want)
+		}
+	}
+
+	for _, raw := range []string{"", "ftp://host", "http://", "http://bad host"} {
+		if _, err := normalizeOllamaURL(raw); err == nil {
+			t.Errorf("normalizeOllamaURL(%q) should fail", raw)
+		}
+	}
+}
+
+// TestOllamaOverUnixSocket verifies that a unix:// address is recognized
+// and that chats reach an Ollama listening on that socket.
+func TestOllamaOverUnixSocket(t *testing.T) {
+	if _, ok := ollamaSocket("http://localhost:11434"); ok {
+		t.Error("a TCP address was taken for a socket")
+	}
+	socket, ok := ollamaSocket("unix://" + filepath.Join(t.TempDir(), "ollama.sock"))
+	if !ok {
+		t.Fatal("unix:// address not recognized")
+	}
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Skipf("Unix sockets unavailable: %v", err)
+	}
+	mockOllama := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"message": {"role": "assistant", "content": "Hi from the socket"}, "done": true}`))
+	}))
+	mockOllama.Listener = listener
+	mockOllama.Start()
+	defer mockOllama.Close()
+
+	oldURL, oldTransport := OllamaAPIURL, ollamaClient.Transport
+	OllamaAPIURL, ollamaClient.Transport = "http://ollama/api/chat", unixSocketTransport(socket)
+	defer func() { OllamaAPIURL, ollamaClient.Transport = oldURL, oldTransport }()
+
+	var history []OllamaMessage
+	reply, err := chatOllama(context.Background(), OllamaMessage{Role: "user", Content: "Hi"}, &history, "m", Sampling)
+	if err != nil || reply != "Hi from the socket" {
+		t.Errorf("got reply %q, error %v", reply, err)
+	}
+}
+
+// TestRequestMaxTokens verifies that max_tokens becomes num_predict and that
+// the server's cap applies both to larger and to missing values.
+func TestRequestMaxTokens(t *testing.T) {
+	oldCap := MaxTokens
+	defer func() { MaxTokens = oldCap }()
+
+	cases := []struct {
+		cap, requested int
+		want           interface{}
+	}{
+		{0, 0, nil},
+		{0, 100, 100},
+		{500, 100, 100},
+		{500, 1000, 500},
+		{500, 0, 500},
+	}
+	for _, tc := range cases {
+		MaxTokens = tc.cap
+		opts, err := ChatRequest{MaxTokens: tc.requested}.options()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := opts.Map()["num_predict"]; got != tc.want {
+			t.Errorf("cap %d, requested %d: got num_predict %v, want %v", tc.cap, tc.requested, got, tc.want)
+		}
+	}
+
+	if _, err := (ChatRequest{MaxTokens: -1}).options(); err == nil {
+		t.Error("negative max_tokens should be rejected")
+	}
+}
+
+// TestRequestNumCtx verifies that num_ctx reaches Ollama's options and
+// that values below zero or above the server's cap are rejected.
+func TestRequestNumCtx(t *testing.T) {
+	oldCap := MaxNumCtx
+	MaxNumCtx = 8192
+	defer func() { MaxNumCtx = oldCap }()
+
+	opts, err := ChatRequest{NumCtx: 8192}.options()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := opts.Map()["num_ctx"]; got != 8192 {
+		t.Errorf("got num_ctx %v, want 8192", got)
+	}
+	if _, ok := Sampling.Map()["num_ctx"]; ok {
+		t.Error("num_ctx sent without being asked for")
+	}
+	for _, numCtx := range []int{-1, 8193} {
+		if _, err := (ChatRequest{NumCtx: numCtx}).options(); err == nil {
+			t.Errorf("num_ctx %d should be rejected", numCtx)
+		}
+	}
+	MaxNumCtx = 0
+	if _, err := (ChatRequest{NumCtx: 131072}).options(); err != nil {
+		t.Errorf("without a cap: %v", err)
+	}
+}
+
+// TestHardwareOptions verifies that num_thread and num_gpu reach Ollama's
+// options only when set, that num_gpu 0 is kept, and that negative values
+// are rejected.
+func TestHardwareOptions(t *testing.T) {
+	options := Sampling.Map()
+	if _, ok := options["num_thread"]; ok {
+		t.Error("num_thread sent without being asked for")
+	}
+	if _, ok := options["num_gpu"]; ok {
+		t.Error("num_gpu sent without being asked for")
+	}
+
+	zero := 0
+	opts, err := ChatRequest{NumThread: 4, NumGPU: &zero}.options()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if options := opts.Map(); options["num_thread"] != 4 || options["num_gpu"] != 0 {
+		t.Errorf("got %v, want num_thread 4 and num_gpu 0", options)
+	}
+
+	minus := -1
+	for _, req := range []ChatRequest{{NumThread: -2}, {NumGPU: &minus}} {
+		if _, err := req.options(); err == nil {
+			t.Errorf("%+v should be rejected", req)
+		}
+	}
+}
+
+// TestPickLANIP verifies the interface fallback of GetLocalIP, which needs
+// no network access: private addresses win over public ones, and loopback,
+// link-local and other-family addresses are never picked.
+func TestPickLANIP(t *testing.T) {
+	cases := []struct {
+		name string
+		ips  []string
+		v6   bool
+		want string
+	}{
+		{"private preferred", []string{"127.0.0.1", "fe80::1", "203.0.113.7", "192.168.1.20"}, false, "192.168.1.20"},
+		{"public fallback", []string{"169.254.3.4", "203.0.113.7"}, false, "203.0.113.7"},
+		{"first private", []string{"10.0.0.5", "172.16.0.9"}, false, "10.0.0.5"},
+		{"none usable", []string{"127.0.0.1", "::1", "169.254.3.4"}, false, ""},
+		{"v6 unique local preferred", []string{"192.168.1.20", "fe80::1", "2001:db8::5", "fd00::7"}, true, "fd00::7"},
+		{"v6 global fallback", []string{"::1", "2001:db8::5"}, true, "2001:db8::5"},
+		{"v6 none usable", []string{"10.0.0.5", "::1", "fe80::1"}, true, ""},
+	}
+	for _, tc := range cases {
+		var ips []net.IP
+		for _, s := range tc.ips {
+			ips = append(ips, net.ParseIP(s))
+		}
+		got := ""
+		if ip := pickLANIP(ips, tc.v6); ip != nil {
+			got = ip.String()
+		}
+		if got != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+// TestParseBasicAuth verifies the checks on -ngrok-basic-auth.
+func TestParseBasicAuth(t *testing.T) {
+	cases := []struct {
+		value   string
+		wantErr bool
+	}{
+		{"alice:correct-horse", false},
+		{"alice:has:colons", false},
+		{"alice", true},
+		{":password123", true},
+		{"alice:short", true},
+	}
+	for _, tc := range cases {
+		_, _, err := parseBasicAuth(tc.value)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("parseBasicAuth(%q) error = %v, wantErr %v", tc.value, err, tc.wantErr)
+		}
+	}
+}
+
+// TestNgrokToken verifies that NGROK_AUTHTOKEN wins over the token file,
+// and that the file's content is trimmed.
+func TestNgrokToken(t *testing.T) {
+	oldFile := NgrokTokenFile
+	defer func() { NgrokTokenFile = oldFile }()
+	NgrokTokenFile = filepath.Join(t.TempDir(), "ngrok_token")
+	os.WriteFile(NgrokTokenFile, []byte("  from-file\n"), 0o600)
+
+	t.Setenv("NGROK_AUTHTOKEN", "from-env")
+	if token, err := ngrokToken(); err != nil || token != "from-env" {
+		t.Errorf("with both: got %q, %v, want the environment's", token, err)
+	}
+	t.Setenv("NGROK_AUTHTOKEN", "")
+	if token, err := ngrokToken(); err != nil || token != "from-file" {
+		t.Errorf("with the file: got %q, %v", token, err)
+	}
+	os.WriteFile(NgrokTokenFile, []byte("\n"), 0o600)
+	if _, err := ngrokToken(); err == nil {
+		t.Error("an empty token file should be refused")
+	}
+	NgrokTokenFile = ""
+	if _, err := ngrokToken(); err == nil || !strings.Contains(err.Error(), "NGROK_AUTHTOKEN") {
+		t.Errorf("with neither: got %v", err)
+	}
+}
+
+// TestHomeTemplateData verifies that the page is rendered with the active
+// model, mode, WebSocket path and personas.
+func TestHomeTemplateData(t *testing.T) {
+	oldModel, oldMode, oldPersonas := OllamaModel, ServerMode, Personas
+	OllamaModel, ServerMode = "llama3.2", "lan"
+	Personas = []Persona{{Name: "tutor", Description: "Explains step by step", System: "x"}}
+	defer func() { OllamaModel, ServerMode, Personas = oldModel, oldMode, oldPersonas }()
+
+	rr := httptest.NewRecorder()
+	handleHome(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	body := rr.Body.String()
+	for _, want := range []string{
+		`<option value="llama3.2">llama3.2</option>`,
+		"llama3.2 · lan",
+		`const wsPath = "/ws";`,
+		`<option value="tutor" title="Explains step by step">tutor</option>`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("page does not contain %q", want)
+		}
+	}
+}
+
+// TestHomeFallbackPage verifies that without index.html the built-in page
+// is served, still rendered with the server's data.
+func TestHomeFallbackPage(t *testing.T) {
+	resetTemplate := func() {
+		homeTemplateOnce = sync.Once{}
+		homeTemplate, homeTemplateErr = nil, nil
+	}
+	resetTemplate()
+	defer resetTemplate()
+	t.Chdir(t.TempDir()) // No index.html here
+
+	rr := httptest.NewRecorder()
+	handleHome(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", rr.Code, http.StatusOK, rr.Body.String())
+	}
+	body := rr.Body.String()
+	if !strings.Contains(body, "index.html was not found") || !strings.Contains(body, OllamaModel) {
+		t.Errorf("did not get the fallback page:\n%s", body)
+	}
+}
+
+// TestCheckOllama verifies that the startup check tells a running server
+// apart from an installed one that isn't running and a missing install.
+func TestCheckOllama(t *testing.T) {
+	mockOllama := httptest.NewServer(mockOllamaHandler())
+	defer mockOllama.Close()
+
+	oldURL, oldTimeout := OllamaAPIURL, HealthCheckTimeout
+	OllamaAPIURL, HealthCheckTimeout = mockOllama.URL+"/api/chat", 200*time.Millisecond
+	defer func() { OllamaAPIURL, HealthCheckTimeout = oldURL, oldTimeout }()
+
+	// A PATH holding only a stand-in ollama binary
+	bin := t.TempDir()
+	if err := os.WriteFile(filepath.Join(bin, "ollama"), []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin)
+
+	if state := checkOllama(context.Background()); state != OllamaRunning {
+		t.Errorf("server up: got state %d, want OllamaRunning", state)
+	}
+	mockOllama.Close()
+	if state := checkOllama(context.Background()); state != OllamaNotRunning {
+		t.Errorf("server down: got state %d, want OllamaNotRunning", state)
+	}
+	t.Setenv("PATH", t.TempDir())
+	if state := checkOllama(context.Background()); state != OllamaNotInstalled {
+		t.Errorf("no binary: got state %d, want OllamaNotInstalled", state)
+	}
+}
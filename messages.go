@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Message is the form history is stored and exported in: an OllamaMessage
+// with its Time, which requests to Ollama leave out.
+type Message struct {
+	OllamaMessage
+	Time time.Time `json:"time,omitzero"`
+}
+
+// timedMessages wraps history for storing or exporting it.
+func timedMessages(history []OllamaMessage) []Message {
+	timed := make([]Message, len(history))
+	for i, m := range history {
+		timed[i] = Message{OllamaMessage: m, Time: m.Time}
+	}
+	return timed
+}
+
+// untimedMessages unwraps stored messages, keeping each Time on its OllamaMessage.
+func untimedMessages(timed []Message) []OllamaMessage {
+	if timed == nil {
+		return nil
+	}
+	history := make([]OllamaMessage, len(timed))
+	for i, m := range timed {
+		history[i] = m.OllamaMessage
+		history[i].Time = m.Time
+	}
+	return history
+}
+
+// messageRoles are the roles a message in a conversation may have.
+var messageRoles = map[string]bool{"user": true, "assistant": true, "system": true, RoleTool: true}
+
+// sanitizeContent strips control characters other than newlines, tabs and
+// carriage returns from s, and replaces invalid UTF-8, so a client can't
+// store escape sequences or NUL bytes in the history.
+func sanitizeContent(s string) string {
+	s = strings.ToValidUTF8(s, "\uFFFD")
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) && r != '\n' && r != '\t' && r != '\r' {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// validateMessages rejects messages with a role other than user, assistant,
+// system or tool, and sanitizes the content of the rest in place.
+func validateMessages(messages []OllamaMessage) error {
+	for i := range messages {
+		if !messageRoles[messages[i].Role] {
+			return fmt.Errorf("message %d has role %q; must be user, assistant, system or %s", i, messages[i].Role, RoleTool)
+		}
+		messages[i].Content = sanitizeContent(messages[i].Content)
+	}
+	return nil
+}
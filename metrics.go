@@ -0,0 +1,31 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Prometheus metrics, served on /metrics when the -metrics flag is set.
+// They are always collected, which costs next to nothing.
+var (
+	messagesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "chat_ollama_messages_total",
+		Help: "Chat messages sent to Ollama.",
+	}, []string{"model"})
+
+	activeConnections = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "chat_ollama_websocket_connections",
+		Help: "Currently open WebSocket connections.",
+	})
+
+	ollamaFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "chat_ollama_ollama_failures_total",
+		Help: "Ollama requests that failed, not counting ones the client cancelled.",
+	}, []string{"model"})
+
+	generationSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "chat_ollama_generation_seconds",
+		Help:    "Time from sending a message to Ollama until the reply is complete.",
+		Buckets: prometheus.ExponentialBuckets(0.25, 2, 10), // 0.25s to about 2 minutes
+	}, []string{"model"})
+)
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// TestMetricsCountMessages verifies that a reply is counted and timed under
+// its model, and that the metrics show up on the scrape endpoint.
+func TestMetricsCountMessages(t *testing.T) {
+	mockOllama := mockOllamaServer()
+	defer mockOllama.Close()
+
+	oldURL := OllamaAPIURL
+	OllamaAPIURL = mockOllama.URL
+	defer func() { OllamaAPIURL = oldURL }()
+
+	const model = "metrics-test-model"
+	before := testutil.ToFloat64(messagesTotal.WithLabelValues(model))
+
+	var history []OllamaMessage
+	if _, err := chatOllama(context.Background(), OllamaMessage{Role: "user", Content: "Hi"}, &history, model, Sampling); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := testutil.ToFloat64(messagesTotal.WithLabelValues(model)); got != before+1 {
+		t.Errorf("messages_total = %v, want %v", got, before+1)
+	}
+
+	rr := httptest.NewRecorder()
+	promhttp.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rr.Body.String()
+	for _, name := range []string{
+		`chat_ollama_messages_total{model="` + model + `"}`,
+		`chat_ollama_generation_seconds_count{model="` + model + `"} 1`,
+		"chat_ollama_websocket_connections",
+	} {
+		if !strings.Contains(body, name) {
+			t.Errorf("metrics output is missing %s", name)
+		}
+	}
+}
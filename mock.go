@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// MockWordDelay is the pause between the words of a -mock reply, so the
+// chat UI streams them like a real model would.
+var MockWordDelay = 50 * time.Millisecond
+
+// startMockOllama serves a stand-in for Ollama on a loopback port and
+// returns the URL of its chat endpoint, for OllamaAPIURL. Everything that
+// talks to Ollama then gets canned answers, so the server runs without it.
+func startMockOllama() (string, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	go http.Serve(listener, mockOllamaHandler())
+	return "http://" + listener.Addr().String() + "/api/chat", nil
+}
+
+// mockOllamaHandler answers the parts of Ollama's API this server uses.
+// Chats and completions echo the prompt back.
+func mockOllamaHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/chat", func(w http.ResponseWriter, r *http.Request) {
+		var req OllamaRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, `{"error": "invalid request"}`, http.StatusBadRequest)
+			return
+		}
+		var prompt string
+		if n := len(req.Messages); n > 0 {
+			prompt = req.Messages[n-1].Content
+		}
+		mockReply(w, r, req.Model, "You said: "+prompt, req.Stream, func(word string) interface{} {
+			return map[string]interface{}{"message": OllamaMessage{Role: "assistant", Content: word}}
+		})
+	})
+	mux.HandleFunc("POST /api/generate", func(w http.ResponseWriter, r *http.Request) {
+		var req OllamaGenerateRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, `{"error": "invalid request"}`, http.StatusBadRequest)
+			return
+		}
+		mockReply(w, r, req.Model, req.Prompt+" …", req.Stream, func(word string) interface{} {
+			return map[string]interface{}{"response": word}
+		})
+	})
+	mux.HandleFunc("GET /api/tags", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"models": []ModelInfo{{Name: OllamaModel}}})
+	})
+	mux.HandleFunc("GET /api/ps", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"models": []ModelInfo{{Name: OllamaModel}}})
+	})
+	mux.HandleFunc("GET /api/version", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"version": "mock"}`))
+	})
+	mux.HandleFunc("POST /api/show", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"capabilities": []string{"completion", "vision", "tools"}})
+	})
+	mux.HandleFunc("POST /api/embeddings", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"embedding": []float64{0.1, 0.2, 0.3}})
+	})
+	mux.HandleFunc("POST /api/pull", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status": "success"}` + "\n"))
+	})
+	return mux
+}
+
+// mockReply sends text word by word as lines built by chunk, or all at
+// once when stream is off, ending with a done line carrying stats.
+func mockReply(w http.ResponseWriter, r *http.Request, model, text string, stream bool, chunk func(word string) interface{}) {
+	enc := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+	words := strings.SplitAfter(text, " ")
+	start := time.Now()
+
+	line := func(v interface{}, done bool) {
+		m := v.(map[string]interface{})
+		m["model"], m["done"] = model, done
+		if done {
+			m["done_reason"] = "stop"
+			m["eval_count"] = len(words)
+			m["eval_duration"] = time.Since(start).Nanoseconds()
+			m["total_duration"] = time.Since(start).Nanoseconds()
+		}
+		enc.Encode(m)
+	}
+
+	if !stream {
+		line(chunk(text), true)
+		return
+	}
+	for _, word := range words {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(MockWordDelay):
+		}
+		line(chunk(word), false)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	line(chunk(""), true)
+}
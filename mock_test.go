@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// TestMockOllamaEchoes verifies that with the -mock stand-in as Ollama, a
+// chat streams back an echo of the message over the WebSocket and the REST
+// API answers in one piece.
+func TestMockOllamaEchoes(t *testing.T) {
+	mockOllama := httptest.NewServer(mockOllamaHandler())
+	defer mockOllama.Close()
+
+	oldURL, oldDelay, oldCache := OllamaAPIURL, MockWordDelay, availableModels
+	OllamaAPIURL, MockWordDelay, availableModels = mockOllama.URL+"/api/chat", 0, &modelCache{ttl: time.Minute}
+	defer func() { OllamaAPIURL, MockWordDelay, availableModels = oldURL, oldDelay, oldCache }()
+
+	server := httptest.NewServer(http.HandlerFunc(handleWebSocket))
+	defer server.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+	ws, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("could not open websocket connection: %v", err)
+	}
+	defer ws.Close()
+
+	if err := ws.WriteJSON(ChatRequest{Message: "Hello there"}); err != nil {
+		t.Fatalf("could not write json: %v", err)
+	}
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var text strings.Builder
+	var chunks int
+	for {
+		var resp StreamResponse
+		if err := ws.ReadJSON(&resp); err != nil {
+			t.Fatalf("Read failed or timed out: %v", err)
+		}
+		if resp.Code != "" {
+			t.Fatalf("got error frame %+v", resp)
+		}
+		if resp.Done {
+			if resp.Stats == nil || resp.Stats.EvalCount != 4 {
+				t.Errorf("done frame has stats %+v, want 4 tokens", resp.Stats)
+			}
+			break
+		}
+		if resp.Status != "" {
+			continue
+		}
+		text.WriteString(resp.Chunk)
+		chunks++
+	}
+	if text.String() != "You said: Hello there" || chunks != 4 {
+		t.Errorf("got %q in %d chunks, want the echo word by word", text.String(), chunks)
+	}
+
+	rr := httptest.NewRecorder()
+	handleChatAPI(rr, httptest.NewRequest(http.MethodPost, "/api/chat", strings.NewReader(`{"message": "Ping"}`)))
+	var reply ChatReply
+	json.NewDecoder(rr.Body).Decode(&reply)
+	if rr.Code != http.StatusOK || reply.Reply != "You said: Ping" {
+		t.Errorf("REST API: got %d %+v", rr.Code, reply)
+	}
+}
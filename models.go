@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// MissingModelError is returned when a chat asks for a model Ollama doesn't
+// have. It matches ErrModelNotFound with errors.Is.
+type MissingModelError struct {
+	Model      string
+	Suggestion string // Closest installed model by name, if any is close
+}
+
+func (e *MissingModelError) Error() string {
+	msg := fmt.Sprintf("%s is not installed; run `ollama pull %s`", e.Model, e.Model)
+	if e.Suggestion != "" {
+		msg += fmt.Sprintf(" or use %s", e.Suggestion)
+	}
+	return msg
+}
+
+func (e *MissingModelError) Is(target error) bool {
+	return target == ErrModelNotFound
+}
+
+// requireModel fails with a MissingModelError when Ollama's cached model list
+// doesn't have model, so the client learns what to do instead of getting
+// Ollama's bare 404. If the list can't be fetched, or is empty so there is
+// nothing to suggest, the check is skipped and the generation reports
+// whatever goes wrong.
+func requireModel(ctx context.Context, model string) error {
+	models, err := availableModels.Get(ctx)
+	if err != nil || len(models) == 0 {
+		return nil
+	}
+
+	names := make([]string, len(models))
+	for i, m := range models {
+		if sameModel(m.Name, model) {
+			return nil
+		}
+		names[i] = m.Name
+	}
+	return &MissingModelError{Model: model, Suggestion: closestModel(model, names)}
+}
+
+// sameModel compares model names the way Ollama resolves them, where a name
+// without a tag means the latest tag.
+func sameModel(a, b string) bool {
+	withTag := func(name string) string {
+		if !strings.Contains(name, ":") {
+			return name + ":latest"
+		}
+		return name
+	}
+	return withTag(a) == withTag(b)
+}
+
+// closestModel returns the name in names most similar to model: another tag
+// of the same model if there is one, otherwise the one whose name without
+// the tag is within a few edits.
+func closestModel(model string, names []string) string {
+	base, _, _ := strings.Cut(model, ":")
+	for _, name := range names {
+		if nameBase, _, _ := strings.Cut(name, ":"); nameBase == base {
+			return name
+		}
+	}
+
+	best, bestDist := "", len(base)/2+1
+	for _, name := range names {
+		nameBase, _, _ := strings.Cut(name, ":")
+		if d := editDistance(base, nameBase); d < bestDist {
+			best, bestDist = name, d
+		}
+	}
+	return best
+}
+
+// editDistance is the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
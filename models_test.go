@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// tagsOllamaServer lists a few installed models on /api/tags and counts the
+// chat requests it gets.
+func tagsOllamaServer(chats *atomic.Int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/tags" {
+			w.Write([]byte(`{"models": [{"name": "gemma3:1b"}, {"name": "llama3.2:latest"}, {"name": "qwen2.5:7b"}]}`))
+			return
+		}
+		chats.Add(1)
+		w.Write([]byte(`{"message": {"content": "Hi"}, "done": true}` + "\n"))
+	}))
+}
+
+// TestRequireModel verifies the check against the model list and the
+// suggested replacement for a missing model.
+func TestRequireModel(t *testing.T) {
+	var chats atomic.Int32
+	mockOllama := tagsOllamaServer(&chats)
+	defer mockOllama.Close()
+
+	oldURL, oldCache := OllamaAPIURL, availableModels
+	OllamaAPIURL, availableModels = mockOllama.URL+"/api/chat", &modelCache{ttl: time.Minute}
+	defer func() { OllamaAPIURL, availableModels = oldURL, oldCache }()
+
+	cases := []struct {
+		model      string
+		missing    bool
+		suggestion string
+	}{
+		{"gemma3:1b", false, ""},
+		{"llama3.2", false, ""}, // No tag means latest
+		{"gemma3:4b", true, "gemma3:1b"},
+		{"lama3.2", true, "llama3.2:latest"},
+		{"mistral", true, ""},
+	}
+	for _, tc := range cases {
+		err := requireModel(context.Background(), tc.model)
+		if !tc.missing {
+			if err != nil {
+				t.Errorf("%s: got error %v, want none", tc.model, err)
+			}
+			continue
+		}
+		var missing *MissingModelError
+		if !errors.As(err, &missing) || !errors.Is(err, ErrModelNotFound) {
+			t.Errorf("%s: got error %v, want a MissingModelError", tc.model, err)
+			continue
+		}
+		if missing.Suggestion != tc.suggestion {
+			t.Errorf("%s: got suggestion %q, want %q", tc.model, missing.Suggestion, tc.suggestion)
+		}
+		if !strings.Contains(err.Error(), "ollama pull "+tc.model) {
+			t.Errorf("%s: error %q does not say how to pull the model", tc.model, err)
+		}
+	}
+}
+
+// TestMissingModelFrame verifies that a chat with a missing model fails
+// before reaching Ollama, with an error frame that suggests another model.
+func TestMissingModelFrame(t *testing.T) {
+	var chats atomic.Int32
+	mockOllama := tagsOllamaServer(&chats)
+	defer mockOllama.Close()
+
+	oldURL, oldCache := OllamaAPIURL, availableModels
+	OllamaAPIURL, availableModels = mockOllama.URL+"/api/chat", &modelCache{ttl: time.Minute}
+	defer func() { OllamaAPIURL, availableModels = oldURL, oldCache }()
+
+	server := httptest.NewServer(http.HandlerFunc(handleWebSocket))
+	defer server.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+	ws, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("could not open websocket connection: %v", err)
+	}
+	defer ws.Close()
+
+	if err := ws.WriteJSON(ChatRequest{Message: "Hi", Model: "qwen2.5:14b"}); err != nil {
+		t.Fatalf("could not write json: %v", err)
+	}
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var resp StreamResponse
+	if err := ws.ReadJSON(&resp); err != nil {
+		t.Fatalf("Read failed or timed out: %v", err)
+	}
+	if resp.Code != CodeModelNotFound || resp.Suggestion != "qwen2.5:7b" {
+		t.Errorf("got frame %+v, want code %q suggesting qwen2.5:7b", resp, CodeModelNotFound)
+	}
+	if n := chats.Load(); n != 0 {
+		t.Errorf("Ollama got %d chat requests, want none", n)
+	}
+}
@@ -0,0 +1,672 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WindowSize is how many of the most recent history messages are sent to
+// Ollama with each request, in addition to the system prompt. It counts
+// individual messages, not exchanges: a user prompt and the assistant's reply
+// are two messages, so the default of 10 keeps the last 5 exchanges. It is
+// set once in main.
+var WindowSize = 10
+
+// buildOllamaRequest prepends the system prompt to the most recent messages
+// of history, limited by both WindowSize and TokenBudget, and wraps them in a
+// request for model.
+func buildOllamaRequest(history []OllamaMessage, model string, opts SamplingOptions, stream bool) OllamaRequest {
+	systemMessage, history := splitSystem(history, opts)
+
+	// Sliding Window Logic
+	messagesToSend := []OllamaMessage{systemMessage}
+	var recentMessages []OllamaMessage
+	if len(history) > WindowSize {
+		recentMessages = history[len(history)-WindowSize:]
+	} else {
+		recentMessages = history
+	}
+	recentMessages = trimToBudget(systemMessage, recentMessages, contextBudget(opts))
+	messagesToSend = append(messagesToSend, recentMessages...)
+
+	return OllamaRequest{
+		Model:     model,
+		Messages:  messagesToSend,
+		Stream:    stream,
+		Options:   opts.Map(),
+		Tools:     opts.Tools,
+		Format:    opts.Format,
+		KeepAlive: KeepAlive,
+	}
+}
+
+// splitSystem returns the system message a request with history and opts
+// starts with, and history without it.
+func splitSystem(history []OllamaMessage, opts SamplingOptions) (OllamaMessage, []OllamaMessage) {
+	systemMessage := OllamaMessage{
+		Role:    "system",
+		Content: SystemPrompt,
+	}
+	if opts.System != "" {
+		systemMessage.Content = opts.System
+	}
+	// A client that sends its own system prompt, as OpenAI clients do, gets it instead
+	if len(history) > 0 && history[0].Role == "system" {
+		systemMessage, history = history[0], history[1:]
+	}
+	if opts.Documents != "" {
+		systemMessage.Content += "\n\n" + opts.Documents
+	}
+	return systemMessage, history
+}
+
+// OllamaRetries is how many times a refused connection to Ollama is retried. It is set once in main.
+var OllamaRetries = 3
+
+// retryBaseDelay is the wait before the first retry. It doubles on every attempt.
+var retryBaseDelay = 500 * time.Millisecond
+
+// postOllama sends reqBody to the Ollama chat API. Cancelling ctx aborts the
+// request, including a streaming body that is still being read. The caller
+// must close the response body.
+//
+// While Ollama is starting up it refuses connections, so dial failures are
+// retried with exponential backoff. Any HTTP response, even a 4xx, is
+// returned as is.
+func postOllama(ctx context.Context, reqBody OllamaRequest) (*http.Response, error) {
+	return postOllamaTo(ctx, OllamaAPIURL, reqBody)
+}
+
+// ollamaClient sends every request to Ollama. main gives it a transport that
+// dials a Unix socket when -ollama-url is a unix:// address.
+var ollamaClient = &http.Client{}
+
+// unixSocketTransport connects to the Unix socket at path, whatever host
+// a request's URL names.
+func unixSocketTransport(path string) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+		var dialer net.Dialer
+		return dialer.DialContext(ctx, "unix", path)
+	}
+	return transport
+}
+
+// postOllamaTo is postOllama for any Ollama endpoint, such as /api/generate.
+func postOllamaTo(ctx context.Context, url string, reqBody any) (*http.Response, error) {
+	jsonPayload, _ := json.Marshal(reqBody)
+
+	delay := retryBaseDelay
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(jsonPayload))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := ollamaClient.Do(req)
+		if err == nil || !isDialError(err) || attempt >= OllamaRetries {
+			if err != nil && ctx.Err() == nil {
+				err = fmt.Errorf("%w: %w", ErrOllamaUnreachable, err)
+			}
+			return resp, err
+		}
+
+		slog.Warn("⏳ Ollama not reachable, retrying", "error", err, "delay", delay, "attempt", attempt+1, "retries", OllamaRetries)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		delay *= 2
+	}
+}
+
+// isDialError reports whether err happened while connecting, e.g. connection
+// refused, meaning the request never reached Ollama and is safe to retry.
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// ollamaEndpoint returns the URL of another Ollama API path, such as
+// "/api/tags", on the same server as OllamaAPIURL.
+func ollamaEndpoint(path string) string {
+	return strings.TrimSuffix(OllamaAPIURL, "/api/chat") + path
+}
+
+// ModelInfo describes a model that has been pulled into Ollama.
+type ModelInfo struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"` // In bytes
+}
+
+// ModelsCacheTTL is how long the model list is reused before asking Ollama again.
+const ModelsCacheTTL = 30 * time.Second
+
+// availableModels caches Ollama's model list.
+var availableModels = &modelCache{ttl: ModelsCacheTTL}
+
+// modelCache remembers the result of fetchModels for a short time.
+type modelCache struct {
+	mu      sync.Mutex
+	models  []ModelInfo
+	fetched time.Time
+	ttl     time.Duration
+}
+
+// Get returns the cached model list, refreshing it from Ollama if it is stale.
+func (c *modelCache) Get(ctx context.Context) ([]ModelInfo, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.models != nil && time.Since(c.fetched) < c.ttl {
+		return c.models, nil
+	}
+	models, err := fetchModels(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.models, c.fetched = models, time.Now()
+	return models, nil
+}
+
+// Invalidate drops the cached list, e.g. after a model was pulled.
+func (c *modelCache) Invalidate() {
+	c.mu.Lock()
+	c.models = nil
+	c.mu.Unlock()
+}
+
+// fetchModels asks Ollama's /api/tags endpoint which models are available.
+func fetchModels(ctx context.Context) ([]ModelInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", ollamaEndpoint("/api/tags"), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := ollamaClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("ollama returned %s", resp.Status)
+	}
+
+	var tags struct {
+		Models []ModelInfo `json:"models"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tags); err != nil {
+		return nil, fmt.Errorf("decoding model list: %w", err)
+	}
+	if tags.Models == nil {
+		tags.Models = []ModelInfo{}
+	}
+	return tags.Models, nil
+}
+
+// HealthCheckTimeout bounds how long pingOllama waits, so a hung Ollama
+// fails the health check instead of hanging it.
+var HealthCheckTimeout = 2 * time.Second
+
+// pingOllama checks that the Ollama API is up and answering requests.
+func pingOllama(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, HealthCheckTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", ollamaEndpoint("/api/tags"), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := ollamaClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("ollama returned %s", resp.Status)
+	}
+	return nil
+}
+
+// MaxStreamLine is the longest line of Ollama's streamed response that is
+// accepted, in bytes. bufio.Scanner's default of 64KB is too small for some
+// models' chunks. It is set once in main.
+var MaxStreamLine = 4 << 20
+
+// KeepAlive is how long Ollama keeps the model loaded after each request,
+// sent as keep_alive: a duration string, or seconds where -1 means forever
+// and 0 unloads the model right away. Nil leaves Ollama's default of five
+// minutes. It is set once in main.
+var KeepAlive interface{}
+
+// parseKeepAlive turns a -keep-alive value into the keep_alive Ollama takes.
+// Plain numbers are seconds and are sent as numbers, since Ollama reads a
+// string such as "-1" as a malformed duration.
+func parseKeepAlive(s string) (interface{}, error) {
+	if s == "" {
+		return nil, nil
+	}
+	if seconds, err := strconv.Atoi(s); err == nil {
+		return seconds, nil
+	}
+	if _, err := time.ParseDuration(s); err != nil {
+		return nil, fmt.Errorf("must be a duration such as 30m or seconds such as -1, got %q", s)
+	}
+	return s, nil
+}
+
+// GenerationTimeout bounds a whole generation, and StreamIdleTimeout the
+// wait for each streamed line, including the first while Ollama loads the
+// model. Zero disables either. They are set once in main.
+var (
+	GenerationTimeout = 10 * time.Minute
+	StreamIdleTimeout = 2 * time.Minute
+)
+
+// generationContext derives the context for one generation from parent,
+// cancelling it with an ErrTimeout cause once GenerationTimeout passes or
+// StreamIdleTimeout passes without touch being called. These are context
+// deadlines rather than http.Client.Timeout, which would also cut off a
+// healthy stream that simply takes long.
+func generationContext(parent context.Context) (ctx context.Context, touch func(), cancel func()) {
+	ctx, cancelCause := context.WithCancelCause(parent)
+	total, idle := GenerationTimeout, StreamIdleTimeout
+	var timers []*time.Timer
+	if total > 0 {
+		timers = append(timers, time.AfterFunc(total, func() {
+			cancelCause(fmt.Errorf("%w: no complete reply after %v", ErrTimeout, total))
+		}))
+	}
+	touch = func() {}
+	if idle > 0 {
+		idleTimer := time.AfterFunc(idle, func() {
+			cancelCause(fmt.Errorf("%w: ollama sent nothing for %v", ErrTimeout, idle))
+		})
+		timers = append(timers, idleTimer)
+		touch = func() { idleTimer.Reset(idle) }
+	}
+	return ctx, touch, func() {
+		for _, t := range timers {
+			t.Stop()
+		}
+		cancelCause(nil)
+	}
+}
+
+// timeoutCause returns the ErrTimeout that ended ctx, or nil if it is still
+// running or ended for another reason.
+func timeoutCause(ctx context.Context) error {
+	if cause := context.Cause(ctx); errors.Is(cause, ErrTimeout) {
+		return cause
+	}
+	return nil
+}
+
+// generationSlots caps how many generations run at once, so a burst of
+// clients can't overload a small GPU. Each generation holds one slot. It is
+// replaced once in main.
+var generationSlots = make(chan struct{}, runtime.NumCPU())
+
+// acquireGeneration takes a generation slot, first calling queued if every
+// slot is busy. It returns the function that hands the slot back.
+func acquireGeneration(ctx context.Context, queued func()) (release func(), err error) {
+	slots := generationSlots
+	select {
+	case slots <- struct{}{}:
+	default:
+		if queued != nil {
+			queued()
+		}
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return func() { <-slots }, nil
+}
+
+// chatOllama sends prompt with a non-streaming request and returns the
+// full reply. Both the prompt and the reply are appended to messages, but
+// only if the request succeeded.
+func chatOllama(ctx context.Context, prompt OllamaMessage, messages *[]OllamaMessage, model string, opts SamplingOptions) (string, error) {
+	if err := requireModel(ctx, model); err != nil {
+		return "", err
+	}
+	release, err := acquireGeneration(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
+	// The whole reply arrives at once, so only the overall timeout applies
+	genCtx, _, cancel := generationContext(ctx)
+	defer cancel()
+
+	messagesTotal.WithLabelValues(model).Inc()
+	start := time.Now()
+	opts = withDocuments(genCtx, opts, prompt)
+	system, _ := splitSystem(*messages, opts)
+	prompt, warning := fitPrompt(system, prompt, contextBudget(opts))
+	if warning != "" {
+		slog.Warn("✂️  Message too long for the context, trimmed it", "model", model, "budget", contextBudget(opts))
+	}
+	turn := append(slices.Clip(*messages), prompt)
+	resp, err := postOllama(genCtx, buildOllamaRequest(turn, model, opts, false))
+	if err != nil {
+		if timeout := timeoutCause(genCtx); timeout != nil {
+			return "", timeout
+		}
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if err := checkOllamaStatus(resp); err != nil {
+		return "", err
+	}
+
+	var result OllamaStreamChunk
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		if timeout := timeoutCause(genCtx); timeout != nil {
+			return "", timeout
+		}
+		return "", fmt.Errorf("decoding ollama response: %w", err)
+	}
+
+	generationSeconds.WithLabelValues(model).Observe(time.Since(start).Seconds())
+
+	*messages = append(turn, OllamaMessage{
+		Role:      "assistant",
+		Content:   result.Message.Content,
+		ToolCalls: result.Message.ToolCalls,
+		Time:      time.Now(),
+	})
+	return result.Message.Content, nil
+}
+
+// StreamRetries is how many times a reply whose stream from Ollama breaks
+// off is generated again from the start. It is set once in main.
+var StreamRetries = 1
+
+// FlushInterval and FlushBytes coalesce Ollama's many tiny chunks into
+// fewer frames: streamed text is held back until FlushBytes have built up
+// or FlushInterval has passed since the last frame. Zero for both sends each
+// chunk as it arrives. They are set once in main.
+var (
+	FlushInterval time.Duration
+	FlushBytes    int
+)
+
+// chunkBuffer coalesces streamed text into frames for out. It only checks
+// the clock when a chunk arrives, so text can wait past the interval while
+// Ollama pauses; the stream's end always flushes.
+type chunkBuffer struct {
+	out      FrameWriter
+	interval time.Duration
+	size     int
+	pending  strings.Builder
+	last     time.Time
+}
+
+func newChunkBuffer(out FrameWriter) *chunkBuffer {
+	return &chunkBuffer{out: out, interval: FlushInterval, size: FlushBytes, last: time.Now()}
+}
+
+// add queues text, sending it along with anything queued before once a
+// threshold is reached.
+func (b *chunkBuffer) add(text string) error {
+	b.pending.WriteString(text)
+	switch {
+	case b.size == 0 && b.interval == 0,
+		b.size > 0 && b.pending.Len() >= b.size,
+		b.interval > 0 && time.Since(b.last) >= b.interval:
+		return b.flush()
+	}
+	return nil
+}
+
+// flush sends the queued text, if any, as one frame.
+func (b *chunkBuffer) flush() error {
+	if b.pending.Len() == 0 {
+		return nil
+	}
+	err := b.out.WriteJSON(StreamResponse{Chunk: b.pending.String(), Done: false})
+	b.pending.Reset()
+	b.last = time.Now()
+	return err
+}
+
+// streamOllama sends prompt and forwards the reply to ws chunk by chunk,
+// ending with a done frame. The prompt and reply are appended to messages
+// once the reply is complete, or cut short by cancelling ctx. A stream that
+// breaks off is generated again up to StreamRetries times, each announced
+// by a StatusRetrying frame. A failed request, a stream that keeps
+// breaking off, or a client that stops taking frames, leaves messages
+// untouched.
+func streamOllama(ctx context.Context, ws FrameWriter, prompt OllamaMessage, messages *[]OllamaMessage, model string, opts SamplingOptions) error {
+	chain, err := installedChain(ctx, model)
+	if err != nil {
+		return err
+	}
+	release, err := acquireGeneration(ctx, func() {
+		ws.WriteJSON(StreamResponse{Status: StatusWaiting})
+	})
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	genCtx, touch, cancel := generationContext(ctx)
+	defer cancel()
+
+	opts = withDocuments(genCtx, opts, prompt)
+	system, _ := splitSystem(*messages, opts)
+	prompt, warning := fitPrompt(system, prompt, contextBudget(opts))
+	if warning != "" {
+		slog.Warn("✂️  Message too long for the context, trimmed it", "model", model, "budget", contextBudget(opts))
+		if err := ws.WriteJSON(StreamResponse{Status: StatusTrimmed, Warning: warning}); err != nil {
+			return fmt.Errorf("%w: %w", ErrClientGone, err)
+		}
+	}
+	turn := append(slices.Clip(*messages), prompt)
+
+	var (
+		reply    *streamedReply
+		streamed bool
+	)
+	for i, candidate := range chain {
+		model = candidate
+		reply, streamed, err = streamModel(ctx, genCtx, touch, ws, turn, model, opts)
+		// Once text has gone out the client has seen this model's reply,
+		// so it isn't swapped for another's
+		if err == nil || streamed || !canFallBack(err) || i == len(chain)-1 || genCtx.Err() != nil {
+			break
+		}
+		slog.Warn("↪️  Model failed, falling back", "model", model, "fallback", chain[i+1], "error", err)
+	}
+	if err != nil {
+		// Keeping the half-written reply would poison the next request's context
+		return err
+	}
+	if len(chain) > 1 {
+		slog.Info("Reply served", "model", model, "requested", chain[0])
+	}
+
+	*messages = append(turn, OllamaMessage{
+		Role:      "assistant",
+		Content:   reply.text.String(),
+		ToolCalls: reply.toolCalls,
+		Time:      time.Now(),
+	})
+	return ws.WriteJSON(StreamResponse{
+		Chunk:       "",
+		Done:        true,
+		Stats:       reply.stats,
+		Truncated:   reply.truncated,
+		InvalidJSON: invalidJSON(opts.Format, model, reply.text.String()),
+		Model:       model,
+	})
+}
+
+// streamModel streams a reply to turn from model, regenerating it up to
+// StreamRetries times if the stream breaks off. streamed reports whether
+// the client was sent text of an attempt that broke off.
+func streamModel(ctx, genCtx context.Context, touch func(), ws FrameWriter, turn []OllamaMessage, model string, opts SamplingOptions) (reply *streamedReply, streamed bool, err error) {
+	messagesTotal.WithLabelValues(model).Inc()
+	req := buildOllamaRequest(turn, model, opts, true)
+	retries := StreamRetries
+	for attempt := 0; ; attempt++ {
+		reply, err = streamAttempt(ctx, genCtx, touch, ws, req, opts.Raw)
+		if !errors.Is(err, ErrStreamInterrupted) || attempt >= retries || genCtx.Err() != nil {
+			return reply, attempt > 0 || errors.Is(err, ErrStreamInterrupted), err
+		}
+		// Ollama can't pick up where it left off, so the reply starts over;
+		// a writer that can't take back the text it sent refuses the retry
+		slog.Warn("🔁 Ollama stream interrupted, regenerating the reply", "model", model, "error", err, "attempt", attempt+1, "retries", retries)
+		if ws.WriteJSON(StreamResponse{Status: StatusRetrying}) != nil {
+			return reply, true, err
+		}
+	}
+}
+
+// forwardChunk sends the thinking, tool calls and text of chunk to ws as
+// frames of their own, and adds them to reply.
+func forwardChunk(ws FrameWriter, chunks *chunkBuffer, chunk OllamaStreamChunk, reply *streamedReply) error {
+	// Reasoning models think out loud before answering; the UI shows that
+	// apart from the reply, and it stays out of the history
+	if thought := chunk.Message.Thinking; thought != "" {
+		if err := chunks.flush(); err != nil {
+			return err
+		}
+		if err := ws.WriteJSON(StreamResponse{Thinking: thought}); err != nil {
+			return err
+		}
+	}
+
+	// Tool calls arrive whole, usually on a line without content
+	if calls := chunk.Message.ToolCalls; len(calls) > 0 {
+		reply.toolCalls = append(reply.toolCalls, calls...)
+		if err := chunks.flush(); err != nil {
+			return err
+		}
+		if err := ws.WriteJSON(StreamResponse{ToolCalls: calls}); err != nil {
+			return err
+		}
+	}
+
+	// The final stats line has no content, so only forward real text
+	if text := chunk.Message.Content; text != "" {
+		reply.text.WriteString(text)
+		return chunks.add(text)
+	}
+	return nil
+}
+
+// streamedReply is what one attempt at streaming a reply produced.
+type streamedReply struct {
+	text      strings.Builder
+	stats     *GenerationStats // Nil unless Ollama sent its final line
+	truncated bool
+	toolCalls []ToolCall
+}
+
+// streamAttempt posts req and forwards the streamed reply to ws. A reply
+// cut short by cancelling ctx is returned without an error; one that ends
+// without Ollama's final line gives ErrStreamInterrupted. A frame ws fails
+// to take before ctx is cancelled gives ErrClientGone at once, and closing
+// the response then aborts the request so Ollama stops generating for
+// nobody. With raw set, each line goes to ws as is rather than split into
+// frames.
+func streamAttempt(ctx, genCtx context.Context, touch func(), ws FrameWriter, req OllamaRequest, raw bool) (*streamedReply, error) {
+	start := time.Now()
+	stopProbe := probeLoading(genCtx, ws, req.Model)
+	resp, err := postOllama(genCtx, req)
+	stopProbe()
+	if err != nil {
+		if timeout := timeoutCause(genCtx); timeout != nil {
+			return nil, timeout
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if err := checkOllamaStatus(resp); err != nil {
+		return nil, err
+	}
+	// Ollama answers once the model is loaded, so the text is on its way
+	if err := ws.WriteJSON(StreamResponse{Status: StatusGenerating}); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrClientGone, err)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), MaxStreamLine)
+	reply := &streamedReply{}
+	chunks := newChunkBuffer(ws)
+
+	for scanner.Scan() {
+		touch()
+		var chunk OllamaStreamChunk
+		if err := json.Unmarshal(scanner.Bytes(), &chunk); err != nil {
+			continue
+		}
+
+		var err error
+		if raw {
+			// The line goes out whole, copied since the scanner reuses its buffer
+			err = ws.WriteJSON(StreamResponse{Raw: bytes.Clone(scanner.Bytes())})
+			reply.toolCalls = append(reply.toolCalls, chunk.Message.ToolCalls...)
+			reply.text.WriteString(chunk.Message.Content)
+		} else {
+			err = forwardChunk(ws, chunks, chunk, reply)
+		}
+
+		if err != nil && ctx.Err() == nil {
+			slog.Info("Client stopped taking frames, abandoning the generation", "model", req.Model, "latency", time.Since(start), "error", err)
+			return nil, fmt.Errorf("%w: %w", ErrClientGone, err)
+		} else if err != nil {
+			break // Cancelled, which keeps the reply so far below
+		}
+
+		if chunk.Done {
+			reply.stats = &chunk.GenerationStats
+			reply.truncated = chunk.DoneReason == "length" // num_predict was reached
+			generationSeconds.WithLabelValues(req.Model).Observe(time.Since(start).Seconds())
+			slog.Info("Generation finished", "model", chunk.Model, "reason", chunk.DoneReason,
+				"latency", time.Since(start), "eval_count", chunk.EvalCount)
+			break
+		}
+	}
+
+	if err := chunks.flush(); err != nil && ctx.Err() == nil {
+		return nil, fmt.Errorf("%w: %w", ErrClientGone, err)
+	}
+
+	// A stream that ends without Ollama's final line was cut off mid-reply
+	if timeout := timeoutCause(genCtx); timeout != nil && reply.stats == nil {
+		return nil, timeout
+	} else if ctx.Err() != nil {
+		slog.Info("Generation cancelled", "model", req.Model, "latency", time.Since(start), "error", ctx.Err())
+	} else if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrStreamInterrupted, err)
+	} else if reply.stats == nil {
+		return nil, fmt.Errorf("%w: %w", ErrStreamInterrupted, io.ErrUnexpectedEOF)
+	}
+	return reply, nil
+}
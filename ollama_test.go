@@ -0,0 +1,1085 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// stallingOllamaServer sends one chunk, then stalls until the request is
+// cancelled, at which point it closes cancelled.
+func stallingOllamaServer(cancelled chan struct{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"message": {"content": "Hello "}}` + "\n"))
+		w.(http.Flusher).Flush()
+
+		select {
+		case <-r.Context().Done():
+			close(cancelled)
+		case <-time.After(5 * time.Second):
+		}
+	}))
+}
+
+// TestDisconnectCancelsOllamaRequest verifies that closing the WebSocket
+// mid-stream cancels the outbound Ollama request.
+func TestDisconnectCancelsOllamaRequest(t *testing.T) {
+	cancelled := make(chan struct{})
+	mockOllama := stallingOllamaServer(cancelled)
+	defer mockOllama.Close()
+
+	oldURL := OllamaAPIURL
+	OllamaAPIURL = mockOllama.URL
+	defer func() { OllamaAPIURL = oldURL }()
+
+	server := httptest.NewServer(http.HandlerFunc(handleWebSocket))
+	defer server.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+	ws, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("could not open websocket connection: %v", err)
+	}
+
+	if err := ws.WriteJSON(ChatRequest{Message: "Tell me a long story"}); err != nil {
+		t.Fatalf("could not write json: %v", err)
+	}
+
+	// Wait for the first chunk so we know generation has started, then hang up.
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var resp StreamResponse
+	if err := ws.ReadJSON(&resp); err != nil {
+		t.Fatalf("did not receive first chunk: %v", err)
+	}
+	ws.Close()
+
+	select {
+	case <-cancelled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Ollama request was not cancelled after the client disconnected")
+	}
+}
+
+// TestStopMessageCancelsGeneration verifies that a stop control message
+// cancels the Ollama request and still ends the reply with a done frame.
+func TestStopMessageCancelsGeneration(t *testing.T) {
+	cancelled := make(chan struct{})
+	mockOllama := stallingOllamaServer(cancelled)
+	defer mockOllama.Close()
+
+	oldURL := OllamaAPIURL
+	OllamaAPIURL = mockOllama.URL
+	defer func() { OllamaAPIURL = oldURL }()
+
+	server := httptest.NewServer(http.HandlerFunc(handleWebSocket))
+	defer server.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+	ws, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("could not open websocket connection: %v", err)
+	}
+	defer ws.Close()
+
+	if err := ws.WriteJSON(ChatRequest{Message: "Tell me a long story"}); err != nil {
+		t.Fatalf("could not write json: %v", err)
+	}
+
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var resp StreamResponse
+	if err := ws.ReadJSON(&resp); err != nil {
+		t.Fatalf("did not receive first chunk: %v", err)
+	}
+
+	if err := ws.WriteJSON(ChatRequest{Type: MessageTypeStop}); err != nil {
+		t.Fatalf("could not send stop: %v", err)
+	}
+
+	for !resp.Done {
+		if err := ws.ReadJSON(&resp); err != nil {
+			t.Fatalf("did not receive done frame after stop: %v", err)
+		}
+	}
+
+	select {
+	case <-cancelled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Ollama request was not cancelled by the stop message")
+	}
+}
+
+// TestStreamForwardsOnlyContent verifies that the typed stream parser forwards
+// every content chunk and skips the empty final stats line.
+func TestStreamForwardsOnlyContent(t *testing.T) {
+	mockOllama := mockOllamaServer()
+	defer mockOllama.Close()
+
+	oldURL := OllamaAPIURL
+	OllamaAPIURL = mockOllama.URL
+	defer func() { OllamaAPIURL = oldURL }()
+
+	server := httptest.NewServer(http.HandlerFunc(handleWebSocket))
+	defer server.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+	ws, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("could not open websocket connection: %v", err)
+	}
+	defer ws.Close()
+
+	if err := ws.WriteJSON(ChatRequest{Message: "Hi"}); err != nil {
+		t.Fatalf("could not write json: %v", err)
+	}
+
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var first StreamResponse
+	if err := ws.ReadJSON(&first); err != nil || first.Status != StatusGenerating {
+		t.Fatalf("got first frame %+v, err %v, want a %q frame", first, err, StatusGenerating)
+	}
+	var text strings.Builder
+	for {
+		var resp StreamResponse
+		if err := ws.ReadJSON(&resp); err != nil {
+			t.Fatalf("Read failed or timed out: %v", err)
+		}
+		if resp.Done {
+			break
+		}
+		if resp.Chunk == "" {
+			t.Error("received an empty content chunk")
+		}
+		text.WriteString(resp.Chunk)
+	}
+
+	if text.String() != "Hello World" {
+		t.Errorf("got %q, want %q", text.String(), "Hello World")
+	}
+}
+
+// TestStreamForwardsThinking verifies that a reasoning model's thinking
+// reaches the client in its own frames and stays out of the reply and the
+// history.
+func TestStreamForwardsThinking(t *testing.T) {
+	mockOllama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"message": {"role": "assistant", "content": "", "thinking": "The user "}, "done": false}` + "\n"))
+		w.Write([]byte(`{"message": {"role": "assistant", "content": "", "thinking": "said hi."}, "done": false}` + "\n"))
+		w.Write([]byte(`{"message": {"role": "assistant", "content": "Hello"}, "done": false}` + "\n"))
+		w.Write([]byte(`{"message": {"role": "assistant", "content": ""}, "done": true}` + "\n"))
+	}))
+	defer mockOllama.Close()
+
+	oldURL := OllamaAPIURL
+	OllamaAPIURL = mockOllama.URL
+	defer func() { OllamaAPIURL = oldURL }()
+
+	var frames []StreamResponse
+	out := frameFunc(func(v interface{}) error {
+		frames = append(frames, v.(StreamResponse))
+		return nil
+	})
+	var history []OllamaMessage
+	if err := streamOllama(context.Background(), out, OllamaMessage{Role: "user", Content: "Hi"}, &history, "m", SamplingOptions{}); err != nil {
+		t.Fatalf("streamOllama: %v", err)
+	}
+
+	var thinking, text strings.Builder
+	for _, f := range frames {
+		if f.Thinking != "" && f.Chunk != "" {
+			t.Errorf("frame %+v mixes thinking and content", f)
+		}
+		thinking.WriteString(f.Thinking)
+		text.WriteString(f.Chunk)
+	}
+	if thinking.String() != "The user said hi." || text.String() != "Hello" {
+		t.Errorf("got thinking %q and text %q", thinking.String(), text.String())
+	}
+	if reply := history[len(history)-1]; reply.Content != "Hello" || reply.Thinking != "" {
+		t.Errorf("history kept %+v, want only the answer", reply)
+	}
+}
+
+// frameFunc adapts a function to a FrameWriter.
+type frameFunc func(v interface{}) error
+
+func (f frameFunc) WriteJSON(v interface{}) error { return f(v) }
+
+// TestStreamForwardsStats verifies that the stats on Ollama's final chunk
+// reach the client in the done frame.
+func TestStreamForwardsStats(t *testing.T) {
+	mockOllama := mockOllamaServer()
+	defer mockOllama.Close()
+
+	oldURL := OllamaAPIURL
+	OllamaAPIURL = mockOllama.URL
+	defer func() { OllamaAPIURL = oldURL }()
+
+	server := httptest.NewServer(http.HandlerFunc(handleWebSocket))
+	defer server.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+	ws, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("could not open websocket connection: %v", err)
+	}
+	defer ws.Close()
+
+	if err := ws.WriteJSON(ChatRequest{Message: "Hi"}); err != nil {
+		t.Fatalf("could not write json: %v", err)
+	}
+
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var resp StreamResponse
+	for !resp.Done {
+		resp = StreamResponse{}
+		if err := ws.ReadJSON(&resp); err != nil {
+			t.Fatalf("Read failed or timed out: %v", err)
+		}
+		if !resp.Done && resp.Stats != nil {
+			t.Error("stats should only be sent on the done frame")
+		}
+	}
+
+	want := GenerationStats{PromptEvalCount: 26, EvalCount: 2, EvalDuration: 500000000, TotalDuration: 900000000}
+	if resp.Stats == nil {
+		t.Fatal("done frame has no stats")
+	}
+	if *resp.Stats != want {
+		t.Errorf("got stats %+v, want %+v", *resp.Stats, want)
+	}
+}
+
+// TestPostOllamaRetriesUntilUp verifies that refused connections are retried
+// until Ollama starts listening.
+func TestPostOllamaRetriesUntilUp(t *testing.T) {
+	// Reserve a free port, then release it so the first dial is refused.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	oldURL, oldRetries, oldDelay := OllamaAPIURL, OllamaRetries, retryBaseDelay
+	OllamaAPIURL, OllamaRetries, retryBaseDelay = "http://"+addr, 5, 20*time.Millisecond
+	defer func() { OllamaAPIURL, OllamaRetries, retryBaseDelay = oldURL, oldRetries, oldDelay }()
+
+	// Bring "Ollama" up shortly after the first attempt.
+	started := make(chan *http.Server, 1)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			started <- nil
+			return
+		}
+		srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"done": true}`))
+		})}
+		started <- srv
+		srv.Serve(ln)
+	}()
+
+	resp, err := postOllama(context.Background(), OllamaRequest{Model: "test"})
+	if srv := <-started; srv != nil {
+		defer srv.Close()
+	} else {
+		t.Skip("could not rebind the reserved port")
+	}
+	if err != nil {
+		t.Fatalf("postOllama failed despite retries: %v", err)
+	}
+	resp.Body.Close()
+}
+
+// TestPostOllamaDoesNotRetryHTTPErrors verifies that an HTTP error response
+// is returned immediately rather than retried.
+func TestPostOllamaDoesNotRetryHTTPErrors(t *testing.T) {
+	var hits int32
+	mockOllama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		http.Error(w, `{"error": "model not found"}`, http.StatusNotFound)
+	}))
+	defer mockOllama.Close()
+
+	oldURL := OllamaAPIURL
+	OllamaAPIURL = mockOllama.URL
+	defer func() { OllamaAPIURL = oldURL }()
+
+	resp, err := postOllama(context.Background(), OllamaRequest{Model: "missing"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("got status %d, want %d", resp.StatusCode, http.StatusNotFound)
+	}
+	if n := atomic.LoadInt32(&hits); n != 1 {
+		t.Errorf("Ollama was called %d times, want 1", n)
+	}
+}
+
+// TestBusyGenerationSendsWaitingFrame verifies that a message arriving while
+// every generation slot is taken is told to wait, then answered once a slot
+// frees up.
+func TestBusyGenerationSendsWaitingFrame(t *testing.T) {
+	mockOllama := mockOllamaServer()
+	defer mockOllama.Close()
+
+	oldURL, oldSlots := OllamaAPIURL, generationSlots
+	OllamaAPIURL = mockOllama.URL
+	generationSlots = make(chan struct{}, 1)
+	defer func() { OllamaAPIURL, generationSlots = oldURL, oldSlots }()
+
+	// Occupy the only slot, as another client's generation would.
+	release, err := acquireGeneration(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(handleWebSocket))
+	defer server.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+	ws, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("could not open websocket connection: %v", err)
+	}
+	defer ws.Close()
+
+	if err := ws.WriteJSON(ChatRequest{Message: "Hi"}); err != nil {
+		t.Fatalf("could not write json: %v", err)
+	}
+
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var resp StreamResponse
+	if err := ws.ReadJSON(&resp); err != nil {
+		t.Fatalf("did not receive a frame: %v", err)
+	}
+	if resp.Status != StatusWaiting {
+		t.Fatalf("got first frame %+v, want status %q", resp, StatusWaiting)
+	}
+
+	release()
+	var reply strings.Builder
+	for !resp.Done {
+		if err := ws.ReadJSON(&resp); err != nil {
+			t.Fatalf("did not receive the reply: %v", err)
+		}
+		reply.WriteString(resp.Chunk)
+	}
+	if reply.String() != "Hello World" {
+		t.Errorf("got reply %q, want %q", reply.String(), "Hello World")
+	}
+}
+
+// TestStreamParsesLongLines verifies that a single stream line far beyond
+// bufio.Scanner's default 64KB limit is still parsed.
+func TestStreamParsesLongLines(t *testing.T) {
+	long := strings.Repeat("a", 200*1024)
+	mockOllama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"message": {"content": "` + long + `"}}` + "\n"))
+		w.Write([]byte(`{"done": true}` + "\n"))
+	}))
+	defer mockOllama.Close()
+
+	oldURL := OllamaAPIURL
+	OllamaAPIURL = mockOllama.URL
+	defer func() { OllamaAPIURL = oldURL }()
+
+	server := httptest.NewServer(http.HandlerFunc(handleWebSocket))
+	defer server.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+	ws, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("could not open websocket connection: %v", err)
+	}
+	defer ws.Close()
+
+	if err := ws.WriteJSON(ChatRequest{Message: "Hi"}); err != nil {
+		t.Fatalf("could not write json: %v", err)
+	}
+
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var text strings.Builder
+	var resp StreamResponse
+	for !resp.Done {
+		resp = StreamResponse{}
+		if err := ws.ReadJSON(&resp); err != nil {
+			t.Fatalf("Read failed or timed out: %v", err)
+		}
+		text.WriteString(resp.Chunk)
+	}
+
+	if resp.Code != "" {
+		t.Fatalf("got error frame %q", resp.Chunk)
+	}
+	if text.Len() != len(long) {
+		t.Errorf("got %d bytes of reply, want %d", text.Len(), len(long))
+	}
+}
+
+// TestStopSequencesForwarded verifies that a message's stop sequences reach
+// OllamaRequest.Options and that the reply still ends with a done frame.
+func TestStopSequencesForwarded(t *testing.T) {
+	var got OllamaRequest
+	mockOllama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Write([]byte(`{"message": {"content": "1, 2"}}` + "\n"))
+		w.Write([]byte(`{"done": true, "done_reason": "stop"}` + "\n"))
+	}))
+	defer mockOllama.Close()
+
+	oldURL := OllamaAPIURL
+	OllamaAPIURL = mockOllama.URL
+	defer func() { OllamaAPIURL = oldURL }()
+
+	server := httptest.NewServer(http.HandlerFunc(handleWebSocket))
+	defer server.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+	ws, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("could not open websocket connection: %v", err)
+	}
+	defer ws.Close()
+
+	if err := ws.WriteJSON(ChatRequest{Message: "Count to ten", Stop: []string{", 3"}}); err != nil {
+		t.Fatalf("could not write json: %v", err)
+	}
+
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var resp StreamResponse
+	for !resp.Done {
+		if err := ws.ReadJSON(&resp); err != nil {
+			t.Fatalf("Read failed or timed out: %v", err)
+		}
+	}
+	if resp.Code != "" {
+		t.Fatalf("got error frame %q", resp.Chunk)
+	}
+
+	stop, _ := got.Options["stop"].([]interface{})
+	if len(stop) != 1 || stop[0] != ", 3" {
+		t.Errorf("Ollama received options %v, want stop [\", 3\"]", got.Options)
+	}
+}
+
+// TestStreamReportsTruncation verifies that a reply ended by the token limit
+// is flagged on the final frame.
+func TestStreamReportsTruncation(t *testing.T) {
+	mockOllama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"message": {"content": "Once upon"}}` + "\n"))
+		w.Write([]byte(`{"done": true, "done_reason": "length"}` + "\n"))
+	}))
+	defer mockOllama.Close()
+
+	oldURL := OllamaAPIURL
+	OllamaAPIURL = mockOllama.URL
+	defer func() { OllamaAPIURL = oldURL }()
+
+	server := httptest.NewServer(http.HandlerFunc(handleWebSocket))
+	defer server.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+	ws, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("could not open websocket connection: %v", err)
+	}
+	defer ws.Close()
+
+	if err := ws.WriteJSON(ChatRequest{Message: "Tell me a story", MaxTokens: 2}); err != nil {
+		t.Fatalf("could not write json: %v", err)
+	}
+
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var resp StreamResponse
+	for !resp.Done {
+		if err := ws.ReadJSON(&resp); err != nil {
+			t.Fatalf("Read failed or timed out: %v", err)
+		}
+	}
+	if !resp.Truncated {
+		t.Error("final frame is not marked as truncated")
+	}
+}
+
+// TestInterruptedStreamKeepsHistoryIntact verifies that a stream cut off
+// before Ollama's final line leaves no half-written turn in the history.
+func TestInterruptedStreamKeepsHistoryIntact(t *testing.T) {
+	mockOllama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"message": {"content": "The answer is"}}` + "\n")) // No done line follows
+	}))
+	defer mockOllama.Close()
+
+	oldURL, oldSessions := OllamaAPIURL, sessions
+	OllamaAPIURL = mockOllama.URL
+	sessions = NewSessionStore(time.Minute, nil)
+	defer func() { OllamaAPIURL, sessions = oldURL, oldSessions }()
+
+	before := []OllamaMessage{{Role: "user", Content: "Hi"}, {Role: "assistant", Content: "Hello"}}
+	sessions.Save("intact", before)
+
+	server := httptest.NewServer(http.HandlerFunc(handleWebSocket))
+	defer server.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+	ws, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("could not open websocket connection: %v", err)
+	}
+	defer ws.Close()
+
+	if err := ws.WriteJSON(ChatRequest{Message: "What is the answer?", SessionID: "intact"}); err != nil {
+		t.Fatalf("could not write json: %v", err)
+	}
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var resp StreamResponse
+	for !resp.Done {
+		if err := ws.ReadJSON(&resp); err != nil {
+			t.Fatalf("Read failed or timed out: %v", err)
+		}
+	}
+	if resp.Code != CodeStreamInterrupted {
+		t.Errorf("got final frame %+v, want code %q", resp, CodeStreamInterrupted)
+	}
+
+	if history := sessions.Load("intact"); len(history) != len(before) {
+		t.Errorf("got history %+v, want it unchanged", history)
+	}
+}
+
+// TestGenerationTimeouts verifies that a wedged or endless stream is
+// abandoned with a timeout error frame, both when Ollama goes quiet and when
+// it keeps sending past the overall limit.
+func TestGenerationTimeouts(t *testing.T) {
+	tests := []struct {
+		name        string
+		total, idle time.Duration
+		interval    time.Duration // Between chunks; 0 stalls after the first
+	}{
+		{name: "idle", total: time.Minute, idle: 100 * time.Millisecond},
+		{name: "total", total: 200 * time.Millisecond, idle: time.Minute, interval: 20 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cancelled := make(chan struct{})
+			mockOllama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				defer close(cancelled)
+				for {
+					w.Write([]byte(`{"message": {"content": "more "}}` + "\n"))
+					w.(http.Flusher).Flush()
+					wait := tt.interval
+					if wait == 0 {
+						wait = 5 * time.Second
+					}
+					select {
+					case <-r.Context().Done():
+						return
+					case <-time.After(wait):
+					}
+				}
+			}))
+			defer mockOllama.Close()
+
+			oldURL, oldTotal, oldIdle := OllamaAPIURL, GenerationTimeout, StreamIdleTimeout
+			OllamaAPIURL, GenerationTimeout, StreamIdleTimeout = mockOllama.URL, tt.total, tt.idle
+			defer func() { OllamaAPIURL, GenerationTimeout, StreamIdleTimeout = oldURL, oldTotal, oldIdle }()
+
+			server := httptest.NewServer(http.HandlerFunc(handleWebSocket))
+			defer server.Close()
+
+			wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+			ws, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+			if err != nil {
+				t.Fatalf("could not open websocket connection: %v", err)
+			}
+			defer ws.Close()
+
+			if err := ws.WriteJSON(ChatRequest{Message: "Go on forever"}); err != nil {
+				t.Fatalf("could not write json: %v", err)
+			}
+			ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+			var resp StreamResponse
+			for !resp.Done {
+				if err := ws.ReadJSON(&resp); err != nil {
+					t.Fatalf("Read failed or timed out: %v", err)
+				}
+			}
+			if resp.Code != CodeTimeout {
+				t.Errorf("got final frame %+v, want code %q", resp, CodeTimeout)
+			}
+
+			select {
+			case <-cancelled:
+			case <-time.After(2 * time.Second):
+				t.Fatal("Ollama request was not cancelled after the timeout")
+			}
+		})
+	}
+}
+
+// TestKeepAlive verifies parsing -keep-alive and that the value reaches
+// Ollama as a number or duration string, or not at all when unset.
+func TestKeepAlive(t *testing.T) {
+	cases := []struct {
+		flag string
+		want string // keep_alive as encoded in the request, "" if absent
+	}{
+		{"", ""},
+		{"30m", `"30m"`},
+		{"-1", "-1"},
+		{"0", "0"},
+		{"3600", "3600"},
+	}
+	for _, tc := range cases {
+		keepAlive, err := parseKeepAlive(tc.flag)
+		if err != nil {
+			t.Errorf("%q: got error %v", tc.flag, err)
+			continue
+		}
+		oldKeepAlive := KeepAlive
+		KeepAlive = keepAlive
+		body, _ := json.Marshal(buildOllamaRequest(nil, "m", Sampling, true))
+		KeepAlive = oldKeepAlive
+
+		var req map[string]json.RawMessage
+		json.Unmarshal(body, &req)
+		if got := string(req["keep_alive"]); got != tc.want {
+			t.Errorf("%q: got keep_alive %s, want %s", tc.flag, got, tc.want)
+		}
+	}
+
+	for _, bad := range []string{"forever", "5 minutes", "1.5h!"} {
+		if _, err := parseKeepAlive(bad); err == nil {
+			t.Errorf("%q: got no error", bad)
+		}
+	}
+}
+
+// TestStopThenMessageKeepsHistoryConsistent sends a stop and the next message
+// back to back while a reply streams, so the reader goroutine and the turn
+// overlap. Run with -race: the connection's history must stay confined to
+// the handler, and the next turn must see the stopped one in it.
+func TestStopThenMessageKeepsHistoryConsistent(t *testing.T) {
+	var (
+		requests atomic.Int32
+		second   = make(chan []OllamaMessage, 1)
+	)
+	mockOllama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/tags" {
+			http.NotFound(w, r) // Skips the installed model check
+			return
+		}
+		var req OllamaRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if requests.Add(1) == 1 {
+			w.Write([]byte(`{"message": {"content": "Once upon"}, "done": false}` + "\n"))
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+			return
+		}
+		second <- req.Messages
+		w.Write([]byte(`{"message": {"content": "Sure"}, "done": true}` + "\n"))
+	}))
+	defer mockOllama.Close()
+
+	oldURL := OllamaAPIURL
+	OllamaAPIURL = mockOllama.URL
+	defer func() { OllamaAPIURL = oldURL }()
+
+	server := httptest.NewServer(http.HandlerFunc(handleWebSocket))
+	defer server.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+	ws, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("could not open websocket connection: %v", err)
+	}
+	defer ws.Close()
+
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if err := ws.WriteJSON(ChatRequest{Message: "Tell me a story"}); err != nil {
+		t.Fatalf("could not write json: %v", err)
+	}
+	var resp StreamResponse
+	if err := ws.ReadJSON(&resp); err != nil {
+		t.Fatalf("did not receive first chunk: %v", err)
+	}
+	ws.WriteJSON(ChatRequest{Type: MessageTypeStop})
+	ws.WriteJSON(ChatRequest{Message: "Something shorter, please"})
+
+	for done := 0; done < 2; {
+		resp = StreamResponse{}
+		if err := ws.ReadJSON(&resp); err != nil {
+			t.Fatalf("Read failed or timed out: %v", err)
+		}
+		if resp.Done {
+			done++
+		}
+	}
+
+	var got []OllamaMessage
+	select {
+	case got = <-second:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the second message never reached Ollama")
+	}
+	var roles []string
+	for _, m := range got {
+		roles = append(roles, m.Role+":"+m.Content)
+	}
+	want := []string{"user:Tell me a story", "assistant:Once upon", "user:Something shorter, please"}
+	if len(roles) != 4 || strings.Join(roles[1:], "|") != strings.Join(want, "|") {
+		t.Errorf("second request had history %q, want the system prompt then %q", roles, want)
+	}
+}
+
+// TestMessageWhileGeneratingIsBusy verifies that a message sent while a
+// reply streams is refused with a busy frame, and never reaches Ollama.
+func TestMessageWhileGeneratingIsBusy(t *testing.T) {
+	var requests atomic.Int32
+	cancelled := make(chan struct{})
+	mockOllama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/tags" {
+			http.NotFound(w, r) // Skips the installed model check
+			return
+		}
+		requests.Add(1)
+		w.Write([]byte(`{"message": {"content": "Once upon"}, "done": false}` + "\n"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+		close(cancelled)
+	}))
+	defer mockOllama.Close()
+
+	oldURL := OllamaAPIURL
+	OllamaAPIURL = mockOllama.URL
+	defer func() { OllamaAPIURL = oldURL }()
+
+	server := httptest.NewServer(http.HandlerFunc(handleWebSocket))
+	defer server.Close()
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("could not open websocket connection: %v", err)
+	}
+	defer ws.Close()
+
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	ws.WriteJSON(ChatRequest{Message: "Tell me a story"})
+	var resp StreamResponse
+	for resp.Chunk == "" {
+		if err := ws.ReadJSON(&resp); err != nil {
+			t.Fatalf("did not receive first chunk: %v", err)
+		}
+	}
+
+	ws.WriteJSON(ChatRequest{Message: "And another one"})
+	resp = StreamResponse{}
+	if err := ws.ReadJSON(&resp); err != nil {
+		t.Fatalf("no answer to the second message: %v", err)
+	}
+	if resp.Code != CodeBusy || !resp.Done {
+		t.Errorf("got %+v, want a busy error frame", resp)
+	}
+
+	ws.WriteJSON(ChatRequest{Type: MessageTypeStop})
+	select {
+	case <-cancelled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("stop did not cancel the reply")
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("Ollama got %d chat requests, want 1", n)
+	}
+}
+
+// TestOtherConversationWaitsWhileGenerating verifies that a message for
+// another conversation waits for the running reply instead of being
+// refused, and that a stop for that other conversation leaves the running
+// reply alone.
+func TestOtherConversationWaitsWhileGenerating(t *testing.T) {
+	cancelled := make(chan struct{})
+	mockOllama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/tags" {
+			http.NotFound(w, r) // Skips the installed model check
+			return
+		}
+		var req OllamaRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Messages[len(req.Messages)-1].Content == "Quick one" {
+			w.Write([]byte(`{"message": {"content": "Sure"}, "done": true}` + "\n"))
+			return
+		}
+		w.Write([]byte(`{"message": {"content": "Once upon"}, "done": false}` + "\n"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+		close(cancelled)
+	}))
+	defer mockOllama.Close()
+
+	oldURL := OllamaAPIURL
+	OllamaAPIURL = mockOllama.URL
+	defer func() { OllamaAPIURL = oldURL }()
+
+	server := httptest.NewServer(http.HandlerFunc(handleWebSocket))
+	defer server.Close()
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("could not open websocket connection: %v", err)
+	}
+	defer ws.Close()
+
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	ws.WriteJSON(ChatRequest{Message: "Tell me a story", ConversationID: "a"})
+	var resp StreamResponse
+	for resp.Chunk == "" {
+		if err := ws.ReadJSON(&resp); err != nil {
+			t.Fatalf("did not receive first chunk: %v", err)
+		}
+	}
+
+	ws.WriteJSON(ChatRequest{Message: "Quick one", ConversationID: "b"})
+	ws.WriteJSON(ChatRequest{Type: MessageTypeStop, ConversationID: "b"})
+	select {
+	case <-cancelled:
+		t.Fatal("a stop for another conversation cancelled the reply")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	ws.WriteJSON(ChatRequest{Type: MessageTypeStop, ConversationID: "a"})
+	var reply string
+	for {
+		resp = StreamResponse{}
+		if err := ws.ReadJSON(&resp); err != nil {
+			t.Fatalf("no reply for the other conversation: %v", err)
+		}
+		if resp.Code != "" {
+			t.Fatalf("got error frame %+v", resp)
+		}
+		if resp.ConversationID != "b" {
+			continue
+		}
+		reply += resp.Chunk
+		if resp.Done {
+			break
+		}
+	}
+	if reply != "Sure" {
+		t.Errorf("other conversation got %q, want its reply", reply)
+	}
+}
+
+// tokenOllamaServer streams n one-word chunks, like a model sending a token
+// at a time, then the final line.
+func tokenOllamaServer(n int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/tags" {
+			http.NotFound(w, r) // Skips the installed model check
+			return
+		}
+		for i := 0; i < n; i++ {
+			w.Write([]byte(`{"message": {"content": "tok "}, "done": false}` + "\n"))
+		}
+		w.Write([]byte(`{"message": {"content": ""}, "done": true}` + "\n"))
+	}))
+}
+
+// streamFrames streams a reply from OllamaAPIURL and returns its text frames.
+func streamFrames(tb testing.TB) []string {
+	var frames []string
+	out := frameFunc(func(v interface{}) error {
+		if frame := v.(StreamResponse); !frame.Done && frame.Status == "" {
+			frames = append(frames, frame.Chunk)
+		}
+		return nil
+	})
+	var history []OllamaMessage
+	if err := streamOllama(context.Background(), out, OllamaMessage{Role: "user", Content: "Hi"}, &history, "m", Sampling); err != nil {
+		tb.Fatalf("streamOllama: %v", err)
+	}
+	return frames
+}
+
+// TestChunkCoalescing verifies that -flush-bytes merges chunks into fewer
+// frames without losing or reordering text, and that the default still
+// sends one frame per chunk.
+func TestChunkCoalescing(t *testing.T) {
+	mockOllama := tokenOllamaServer(25)
+	defer mockOllama.Close()
+
+	oldURL, oldBytes := OllamaAPIURL, FlushBytes
+	OllamaAPIURL = mockOllama.URL
+	defer func() { OllamaAPIURL, FlushBytes = oldURL, oldBytes }()
+
+	want := strings.Repeat("tok ", 25)
+	FlushBytes = 0
+	if frames := streamFrames(t); len(frames) != 25 || strings.Join(frames, "") != want {
+		t.Errorf("default: got %d frames, want one per chunk", len(frames))
+	}
+
+	FlushBytes = 16
+	frames := streamFrames(t)
+	if strings.Join(frames, "") != want {
+		t.Fatalf("coalesced text %q, want %q", strings.Join(frames, ""), want)
+	}
+	if len(frames) != 7 {
+		t.Errorf("got %d frames, want 7", len(frames))
+	}
+	for _, frame := range frames[:len(frames)-1] {
+		if len(frame) < 16 {
+			t.Errorf("frame %q sent before reaching 16 bytes", frame)
+		}
+	}
+}
+
+// BenchmarkStreamFrames compares how many frames a 500-token reply takes per
+// chunk and with coalescing.
+func BenchmarkStreamFrames(b *testing.B) {
+	mockOllama := tokenOllamaServer(500)
+	defer mockOllama.Close()
+
+	oldURL, oldBytes, oldInterval := OllamaAPIURL, FlushBytes, FlushInterval
+	OllamaAPIURL = mockOllama.URL
+	defer func() { OllamaAPIURL, FlushBytes, FlushInterval = oldURL, oldBytes, oldInterval }()
+
+	cases := []struct {
+		name     string
+		bytes    int
+		interval time.Duration
+	}{
+		{"per-chunk", 0, 0},
+		{"bytes=64", 64, 0},
+		{"bytes=256", 256, 0},
+		{"interval=50ms", 0, 50 * time.Millisecond},
+	}
+	for _, tc := range cases {
+		b.Run(tc.name, func(b *testing.B) {
+			FlushBytes, FlushInterval = tc.bytes, tc.interval
+			var frames int
+			for b.Loop() {
+				frames += len(streamFrames(b))
+			}
+			b.ReportMetric(float64(frames)/float64(b.N), "frames/op")
+		})
+	}
+}
+
+// TestInterruptedStreamIsRetried verifies that a stream that breaks off is
+// generated again after a retrying frame, and that only the complete reply
+// is kept. Without retries the interruption is an error as before.
+func TestInterruptedStreamIsRetried(t *testing.T) {
+	var attempts atomic.Int32
+	mockOllama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/tags" {
+			http.NotFound(w, r) // Skips the installed model check
+			return
+		}
+		if attempts.Add(1) == 1 {
+			w.Write([]byte(`{"message": {"content": "Hel"}, "done": false}` + "\n")) // No done line follows
+			return
+		}
+		w.Write([]byte(`{"message": {"content": "Hello"}, "done": false}` + "\n"))
+		w.Write([]byte(`{"message": {"content": ""}, "done": true}` + "\n"))
+	}))
+	defer mockOllama.Close()
+
+	oldURL, oldRetries := OllamaAPIURL, StreamRetries
+	OllamaAPIURL, StreamRetries = mockOllama.URL, 1
+	defer func() { OllamaAPIURL, StreamRetries = oldURL, oldRetries }()
+
+	var frames []string
+	out := frameFunc(func(v interface{}) error {
+		frame := v.(StreamResponse)
+		frames = append(frames, frame.Status+frame.Chunk)
+		return nil
+	})
+	var history []OllamaMessage
+	if err := streamOllama(context.Background(), out, OllamaMessage{Role: "user", Content: "Hi"}, &history, "m", Sampling); err != nil {
+		t.Fatalf("streamOllama: %v", err)
+	}
+	if got := strings.Join(frames, "|"); got != StatusGenerating+"|Hel|"+StatusRetrying+"|"+StatusGenerating+"|Hello|" {
+		t.Errorf("got frames %q", got)
+	}
+	if len(history) != 2 || history[1].Content != "Hello" {
+		t.Errorf("got history %+v, want the complete reply only", history)
+	}
+
+	attempts.Store(0)
+	StreamRetries = 0
+	history = nil
+	err := streamOllama(context.Background(), out, OllamaMessage{Role: "user", Content: "Hi"}, &history, "m", Sampling)
+	if !errors.Is(err, ErrStreamInterrupted) || len(history) != 0 {
+		t.Errorf("without retries: got error %v and history %+v", err, history)
+	}
+}
+
+// TestFailedWriteStopsGeneration verifies that a client that stops taking
+// frames mid-stream ends the generation rather than leaving Ollama to
+// finish a reply nobody reads.
+func TestFailedWriteStopsGeneration(t *testing.T) {
+	cancelled := make(chan struct{})
+	mockOllama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/tags" {
+			http.NotFound(w, r)
+			return
+		}
+		for range 500 {
+			w.Write([]byte(`{"message": {"content": "word "}}` + "\n"))
+			w.(http.Flusher).Flush()
+			select {
+			case <-r.Context().Done():
+				close(cancelled)
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}))
+	defer mockOllama.Close()
+
+	oldURL := OllamaAPIURL
+	OllamaAPIURL = mockOllama.URL
+	defer func() { OllamaAPIURL = oldURL }()
+
+	chunks := 0
+	out := frameFunc(func(v interface{}) error {
+		if v.(StreamResponse).Chunk == "" {
+			return nil
+		}
+		if chunks++; chunks > 2 {
+			return errors.New("broken pipe")
+		}
+		return nil
+	})
+	history := []OllamaMessage{}
+	start := time.Now()
+	err := streamOllama(context.Background(), out, OllamaMessage{Role: "user", Content: "Tell me a long story"}, &history, "m", Sampling)
+	if !errors.Is(err, ErrClientGone) {
+		t.Errorf("got %v, want ErrClientGone", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("streamOllama took %v after the client went away", elapsed)
+	}
+	if len(history) != 0 {
+		t.Errorf("history = %+v, want it untouched", history)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Ollama request was not cancelled after a write failed")
+	}
+}
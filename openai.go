@@ -0,0 +1,250 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// OpenAIChatRequest is the subset of OpenAI's chat completion request that
+// maps onto Ollama. Unknown fields are ignored so SDK clients just work.
+type OpenAIChatRequest struct {
+	Model       string          `json:"model"`
+	Messages    []OllamaMessage `json:"messages"`
+	Stream      bool            `json:"stream"`
+	Temperature *float64        `json:"temperature,omitempty"`
+	TopP        *float64        `json:"top_p,omitempty"`
+	MaxTokens   int             `json:"max_tokens,omitempty"`
+	Stop        openAIStop      `json:"stop,omitempty"`
+}
+
+// openAIStop accepts OpenAI's stop field, which is a string or a list.
+type openAIStop []string
+
+func (s *openAIStop) UnmarshalJSON(data []byte) error {
+	var one string
+	if json.Unmarshal(data, &one) == nil {
+		*s = openAIStop{one}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(s))
+}
+
+// OpenAIChoice is one completion in a response or stream chunk. Message is
+// set in full responses, Delta in stream chunks.
+type OpenAIChoice struct {
+	Index        int            `json:"index"`
+	Message      *OllamaMessage `json:"message,omitempty"`
+	Delta        *OllamaMessage `json:"delta,omitempty"`
+	FinishReason *string        `json:"finish_reason"`
+}
+
+// OpenAIChatResponse is a chat completion, or one chunk of a streamed one.
+type OpenAIChatResponse struct {
+	ID      string         `json:"id"`
+	Object  string         `json:"object"`
+	Created int64          `json:"created"`
+	Model   string         `json:"model"`
+	Choices []OpenAIChoice `json:"choices"`
+}
+
+// openAIError writes an error in OpenAI's {"error": {...}} shape, which the
+// SDKs turn into exceptions.
+func openAIError(w http.ResponseWriter, status int, errType, message string) {
+	writeJSON(w, status, map[string]interface{}{
+		"error": map[string]string{"type": errType, "message": message},
+	})
+}
+
+// options turns the request's sampling fields into SamplingOptions, falling
+// back to the server's settings for those it leaves out.
+func (req OpenAIChatRequest) options() (SamplingOptions, error) {
+	opts, err := requestOptions(req.Stop, req.MaxTokens)
+	if err != nil {
+		return opts, err
+	}
+	if req.Temperature != nil {
+		opts.Temperature = *req.Temperature
+	}
+	if req.TopP != nil {
+		opts.TopP = *req.TopP
+	}
+	return opts, opts.Validate()
+}
+
+// handleOpenAIChat serves OpenAI's /v1/chat/completions so existing OpenAI
+// SDK clients can point at this server. The client sends the whole
+// conversation each time; a leading system message replaces the server's.
+func handleOpenAIChat(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req OpenAIChatRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		openAIError(w, http.StatusBadRequest, "invalid_request_error", "Invalid JSON: "+err.Error())
+		return
+	}
+	if err := validateMessages(req.Messages); err != nil {
+		openAIError(w, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+	if len(req.Messages) == 0 || req.Messages[len(req.Messages)-1].Role != "user" {
+		openAIError(w, http.StatusBadRequest, "invalid_request_error", "messages must end with a user message")
+		return
+	}
+	model, err := resolveModel(req.Model)
+	if err != nil {
+		openAIError(w, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+	opts, err := req.options()
+	if err != nil {
+		openAIError(w, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+
+	history := req.Messages[:len(req.Messages)-1]
+	prompt := req.Messages[len(req.Messages)-1]
+	reply := OpenAIChatResponse{
+		ID:      newCompletionID(),
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   model,
+	}
+
+	if req.Stream {
+		out := &openAIStream{w: w, reply: reply}
+		err = streamOllama(r.Context(), out, prompt, &history, model, opts)
+		// A client that stopped taking chunks has nobody left to tell
+		if err != nil && r.Context().Err() == nil && !errors.Is(err, ErrClientGone) {
+			ollamaFailures.WithLabelValues(model).Inc()
+			slog.Error("Ollama error", "model", model, "error", err)
+			out.fail(err)
+		}
+		return
+	}
+
+	content, err := chatOllama(r.Context(), prompt, &history, model, opts)
+	if err != nil {
+		if r.Context().Err() != nil {
+			slog.Info("Chat request cancelled by the client", "model", model)
+			return
+		}
+		ollamaFailures.WithLabelValues(model).Inc()
+		slog.Error("Ollama error", "model", model, "error", err)
+		openAIError(w, openAIStatus(err), "api_error", err.Error())
+		return
+	}
+	stop := "stop"
+	reply.Choices = []OpenAIChoice{{
+		Message:      &OllamaMessage{Role: "assistant", Content: content},
+		FinishReason: &stop,
+	}}
+	writeJSON(w, http.StatusOK, reply)
+}
+
+// openAIStatus is the HTTP status for an error from the Ollama calls.
+func openAIStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrModelNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrTimeout):
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+// newCompletionID returns an id in OpenAI's chatcmpl-... style.
+func newCompletionID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return "chatcmpl-" + hex.EncodeToString(b)
+}
+
+// errAlreadyStreamed refuses a retry once text has reached an OpenAI client,
+// which has no way to take it back.
+var errAlreadyStreamed = errors.New("reply already partly streamed")
+
+// openAIStream is a FrameWriter that translates streamOllama's frames into
+// OpenAI's Server-Sent Events chunks, ending with "data: [DONE]".
+type openAIStream struct {
+	w       http.ResponseWriter
+	reply   OpenAIChatResponse
+	started bool
+}
+
+// start sends the SSE headers and the chunk announcing the assistant role.
+func (s *openAIStream) start() {
+	s.started = true
+	s.w.Header().Set("Content-Type", "text/event-stream")
+	s.w.Header().Set("Cache-Control", "no-cache")
+	s.send(OpenAIChoice{Delta: &OllamaMessage{Role: "assistant"}})
+}
+
+func (s *openAIStream) send(choice OpenAIChoice) error {
+	chunk := s.reply
+	chunk.Object = "chat.completion.chunk"
+	chunk.Choices = []OpenAIChoice{choice}
+	data, _ := json.Marshal(chunk)
+	return s.event(string(data))
+}
+
+func (s *openAIStream) event(data string) error {
+	if _, err := fmt.Fprintf(s.w, "data: %s\n\n", data); err != nil {
+		return err
+	}
+	if flusher, ok := s.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return nil
+}
+
+func (s *openAIStream) WriteJSON(v interface{}) error {
+	frame, ok := v.(StreamResponse)
+	if ok && frame.Status == StatusRetrying && s.started {
+		return errAlreadyStreamed // The client would get the text twice
+	}
+	if !ok || frame.Status != "" {
+		return nil // Queue notices have no OpenAI equivalent
+	}
+	if frame.Chunk == "" && !frame.Done {
+		return nil // Thinking and tool calls are not translated to OpenAI's format
+	}
+	if !s.started {
+		s.start()
+	}
+	if !frame.Done {
+		return s.send(OpenAIChoice{Delta: &OllamaMessage{Content: frame.Chunk}})
+	}
+
+	reason := "stop"
+	if frame.Truncated {
+		reason = "length"
+	}
+	if err := s.send(OpenAIChoice{Delta: &OllamaMessage{}, FinishReason: &reason}); err != nil {
+		return err
+	}
+	return s.event("[DONE]")
+}
+
+// fail reports err as a plain error response if nothing was streamed yet,
+// or as a final error event otherwise.
+func (s *openAIStream) fail(err error) {
+	if !s.started {
+		openAIError(s.w, openAIStatus(err), "api_error", err.Error())
+		return
+	}
+	data, _ := json.Marshal(map[string]interface{}{
+		"error": map[string]string{"type": "api_error", "code": errorCode(err), "message": err.Error()},
+	})
+	s.event(string(data))
+	s.event("[DONE]")
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// openAIOllamaServer streams "Hello world" and records the request it got.
+func openAIOllamaServer(got *OllamaRequest) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(got)
+		if !got.Stream {
+			w.Write([]byte(`{"message": {"role": "assistant", "content": "Hello world"}, "done": true}`))
+			return
+		}
+		w.Write([]byte(`{"message": {"content": "Hello "}}` + "\n"))
+		w.Write([]byte(`{"message": {"content": "world"}}` + "\n"))
+		w.Write([]byte(`{"message": {"content": ""}, "done": true, "done_reason": "stop"}` + "\n"))
+	}))
+}
+
+// TestOpenAIChatCompletion verifies that an OpenAI request is translated
+// for Ollama, with the client's system prompt and temperature, and answered
+// in OpenAI's shape.
+func TestOpenAIChatCompletion(t *testing.T) {
+	var got OllamaRequest
+	mockOllama := openAIOllamaServer(&got)
+	defer mockOllama.Close()
+
+	oldURL := OllamaAPIURL
+	OllamaAPIURL = mockOllama.URL
+	defer func() { OllamaAPIURL = oldURL }()
+
+	body := `{"model": "llama3", "temperature": 0.2, "stop": "END", "messages": [
+		{"role": "system", "content": "Be brief."},
+		{"role": "user", "content": "Hi"}]}`
+	rr := httptest.NewRecorder()
+	handleOpenAIChat(rr, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body)))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", rr.Code, http.StatusOK, rr.Body.String())
+	}
+	if got.Model != "llama3" || len(got.Messages) != 2 || got.Messages[0].Content != "Be brief." {
+		t.Errorf("Ollama got %+v", got)
+	}
+	if got.Options["temperature"] != 0.2 {
+		t.Errorf("got options %v, want temperature 0.2", got.Options)
+	}
+	if stop, _ := got.Options["stop"].([]interface{}); len(stop) != 1 || stop[0] != "END" {
+		t.Errorf("got options %v, want stop [END]", got.Options)
+	}
+
+	var reply OpenAIChatResponse
+	if err := json.NewDecoder(rr.Body).Decode(&reply); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if reply.Object != "chat.completion" || len(reply.Choices) != 1 || reply.Choices[0].Message.Content != "Hello world" {
+		t.Errorf("got response %+v", reply)
+	}
+}
+
+// TestOpenAIChatStream verifies that stream:true answers with OpenAI's
+// Server-Sent Events chunks, ending with a finish reason and [DONE].
+func TestOpenAIChatStream(t *testing.T) {
+	var got OllamaRequest
+	mockOllama := openAIOllamaServer(&got)
+	defer mockOllama.Close()
+
+	oldURL := OllamaAPIURL
+	OllamaAPIURL = mockOllama.URL
+	defer func() { OllamaAPIURL = oldURL }()
+
+	body := `{"stream": true, "messages": [{"role": "user", "content": "Hi"}]}`
+	rr := httptest.NewRecorder()
+	handleOpenAIChat(rr, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body)))
+
+	if ct := rr.Header().Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("got Content-Type %q", ct)
+	}
+
+	var text strings.Builder
+	var events []string
+	var finish string
+	scanner := bufio.NewScanner(rr.Body)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		events = append(events, data)
+		if data == "[DONE]" {
+			continue
+		}
+		var chunk OpenAIChatResponse
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			t.Fatalf("invalid chunk %q: %v", data, err)
+		}
+		if chunk.Object != "chat.completion.chunk" || len(chunk.Choices) != 1 {
+			t.Fatalf("got chunk %+v", chunk)
+		}
+		choice := chunk.Choices[0]
+		text.WriteString(choice.Delta.Content)
+		if choice.FinishReason != nil {
+			finish = *choice.FinishReason
+		}
+	}
+
+	if text.String() != "Hello world" {
+		t.Errorf("got text %q", text.String())
+	}
+	if finish != "stop" {
+		t.Errorf("got finish reason %q, want stop", finish)
+	}
+	if len(events) == 0 || events[len(events)-1] != "[DONE]" {
+		t.Errorf("stream did not end with [DONE]: %q", events)
+	}
+}
+
+// TestOpenAIChatErrors verifies the status codes for bad input.
+func TestOpenAIChatErrors(t *testing.T) {
+	cases := []struct {
+		name   string
+		method string
+		body   string
+		want   int
+	}{
+		{"wrong method", http.MethodGet, "", http.StatusMethodNotAllowed},
+		{"bad json", http.MethodPost, `{`, http.StatusBadRequest},
+		{"no messages", http.MethodPost, `{"messages": []}`, http.StatusBadRequest},
+		{"last not user", http.MethodPost, `{"messages": [{"role": "assistant", "content": "Hi"}]}`, http.StatusBadRequest},
+		{"bad temperature", http.MethodPost, `{"temperature": 3, "messages": [{"role": "user", "content": "Hi"}]}`, http.StatusBadRequest},
+	}
+	for _, tc := range cases {
+		rr := httptest.NewRecorder()
+		handleOpenAIChat(rr, httptest.NewRequest(tc.method, "/v1/chat/completions", strings.NewReader(tc.body)))
+		if rr.Code != tc.want {
+			t.Errorf("%s: got status %d, want %d", tc.name, rr.Code, tc.want)
+		}
+	}
+}
+
+// TestOpenAIStreamRefusesRetry verifies that a retry is refused once text
+// has been streamed, since OpenAI clients can't discard it, but allowed
+// before.
+func TestOpenAIStreamRefusesRetry(t *testing.T) {
+	out := &openAIStream{w: httptest.NewRecorder()}
+	if err := out.WriteJSON(StreamResponse{Status: StatusRetrying}); err != nil {
+		t.Errorf("before any text: got %v, want the retry allowed", err)
+	}
+	out.WriteJSON(StreamResponse{Chunk: "Hel"})
+	if err := out.WriteJSON(StreamResponse{Status: StatusRetrying}); !errors.Is(err, errAlreadyStreamed) {
+		t.Errorf("after text: got %v, want %v", err, errAlreadyStreamed)
+	}
+}
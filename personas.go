@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+)
+
+// PersonasFile is where personas are loaded from unless -personas says otherwise.
+const PersonasFile = "personas.json"
+
+// Persona is a named system prompt a client can pick for its chat.
+type Persona struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	System      string `json:"system"`
+}
+
+// DefaultPersonas are offered when there is no personas file.
+var DefaultPersonas = []Persona{
+	{Name: "gangster", Description: "Speaks in gangster slang", System: "You are an assistant who speaks in gangster slang."},
+	{Name: "pirate", Description: "Talks like a pirate", System: "You are an assistant who talks like a pirate."},
+	{Name: "concise", Description: "Short, to-the-point answers", System: "You are a helpful assistant. Answer as briefly as possible."},
+}
+
+// Personas are the personas clients can pick from. It is set once in main.
+var Personas = DefaultPersonas
+
+// loadPersonas reads a JSON array of personas from path, falling back to
+// DefaultPersonas if the file is missing. Every persona needs a unique name
+// and a system prompt.
+func loadPersonas(path string) ([]Persona, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return DefaultPersonas, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var personas []Persona
+	if err := json.Unmarshal(data, &personas); err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	for _, p := range personas {
+		switch {
+		case p.Name == "":
+			return nil, fmt.Errorf("a persona has no name")
+		case seen[p.Name]:
+			return nil, fmt.Errorf("persona %q is defined twice", p.Name)
+		case p.System == "":
+			return nil, fmt.Errorf("persona %q has no system prompt", p.Name)
+		}
+		seen[p.Name] = true
+	}
+	log.Printf("🎭 Loaded %d personas from %s\n", len(personas), path)
+	return personas, nil
+}
+
+// personaPrompt returns the system prompt of the persona called name. An
+// empty or unknown name gets "", meaning SystemPrompt, so a client holding
+// on to a persona that was since removed still gets an answer.
+func personaPrompt(name string) string {
+	if name == "" {
+		return ""
+	}
+	for _, p := range Personas {
+		if p.Name == name {
+			return p.System
+		}
+	}
+	slog.Warn("Unknown persona, using the default system prompt", "persona", name)
+	return ""
+}
+
+// PersonaList is the response body of the personas endpoint.
+type PersonaList struct {
+	Personas []Persona `json:"personas"`
+}
+
+// handlePersonas lists the personas clients can pick from.
+func handlePersonas(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, PersonaList{Personas: Personas})
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestLoadPersonas verifies reading a personas file, the defaults when there
+// is none, and the rejection of broken definitions.
+func TestLoadPersonas(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	personas, err := loadPersonas(filepath.Join(dir, "missing.json"))
+	if err != nil || len(personas) != len(DefaultPersonas) {
+		t.Errorf("missing file: got %d personas, err %v; want the defaults", len(personas), err)
+	}
+
+	path := write("ok.json", `[{"name": "tutor", "description": "Explains step by step", "system": "You are a patient tutor."}]`)
+	personas, err = loadPersonas(path)
+	if err != nil || len(personas) != 1 || personas[0].System != "You are a patient tutor." {
+		t.Errorf("valid file: got %+v, err %v", personas, err)
+	}
+
+	for name, content := range map[string]string{
+		"bad.json":       `{`,
+		"noname.json":    `[{"system": "Hi"}]`,
+		"nosystem.json":  `[{"name": "empty"}]`,
+		"duplicate.json": `[{"name": "a", "system": "x"}, {"name": "a", "system": "y"}]`,
+	} {
+		if _, err := loadPersonas(write(name, content)); err == nil {
+			t.Errorf("%s: got no error", name)
+		}
+	}
+}
+
+// TestPersonaSelectsSystemPrompt verifies that a chat's persona replaces the
+// system prompt sent to Ollama, and that an unknown one falls back to the
+// default instead of failing.
+func TestPersonaSelectsSystemPrompt(t *testing.T) {
+	oldPersonas, oldPrompt := Personas, SystemPrompt
+	Personas = []Persona{{Name: "tutor", System: "You are a patient tutor."}}
+	SystemPrompt = DefaultSystemPrompt
+	defer func() { Personas, SystemPrompt = oldPersonas, oldPrompt }()
+
+	cases := []struct {
+		persona string
+		want    string
+	}{
+		{"tutor", "You are a patient tutor."},
+		{"", DefaultSystemPrompt},
+		{"no-such-persona", DefaultSystemPrompt},
+	}
+	for _, tc := range cases {
+		opts, err := ChatRequest{Message: "Hi", Persona: tc.persona}.options()
+		if err != nil {
+			t.Fatalf("%q: %v", tc.persona, err)
+		}
+		req := buildOllamaRequest([]OllamaMessage{{Role: "user", Content: "Hi"}}, "m", opts, true)
+		if got := req.Messages[0]; got.Role != "system" || got.Content != tc.want {
+			t.Errorf("persona %q: got system message %+v, want %q", tc.persona, got, tc.want)
+		}
+	}
+}
+
+// TestPersonasAPI verifies that /api/personas lists the loaded personas.
+func TestPersonasAPI(t *testing.T) {
+	rr := httptest.NewRecorder()
+	handlePersonas(rr, httptest.NewRequest(http.MethodGet, "/api/personas", nil))
+
+	var list PersonaList
+	if err := json.NewDecoder(rr.Body).Decode(&list); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if len(list.Personas) != len(Personas) || !strings.Contains(list.Personas[0].System, "gangster") {
+		t.Errorf("got %+v", list)
+	}
+}
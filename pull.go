@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+)
+
+// PullRequest is the request body of the model download endpoint.
+type PullRequest struct {
+	Model string `json:"model"`
+}
+
+// PullProgress is one event of a model download. Percent covers the layer
+// being downloaded; Ollama reports layers one after another.
+type PullProgress struct {
+	Status  string  `json:"status"`
+	Percent float64 `json:"percent,omitempty"`
+	Done    bool    `json:"done,omitempty"`
+	Error   string  `json:"error,omitempty"`
+}
+
+// ollamaPullLine is one line of Ollama's streamed /api/pull response.
+type ollamaPullLine struct {
+	Status    string `json:"status"`
+	Total     int64  `json:"total"`
+	Completed int64  `json:"completed"`
+	Error     string `json:"error"`
+}
+
+// handlePull downloads a model through Ollama's /api/pull, streaming its
+// progress as Server-Sent Events of PullProgress, so users can fetch a
+// missing model from the chat UI. The last event has done or error set.
+func handlePull(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req PullRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Model == "" {
+		http.Error(w, "model is required", http.StatusBadRequest)
+		return
+	}
+	model, err := resolveModel(req.Model)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	resp, err := postOllamaTo(ctx, ollamaEndpoint("/api/pull"), map[string]any{"model": model, "stream": true})
+	if err == nil {
+		defer resp.Body.Close()
+		err = checkOllamaStatus(resp)
+	}
+	if err != nil {
+		slog.Error("Model pull failed", "model", model, "error", err)
+		http.Error(w, "Ollama request failed: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	flusher, _ := w.(http.Flusher)
+	out := sseWriter{w: w, flusher: flusher}
+	slog.Info("📥 Pulling model", "model", model)
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var line ollamaPullLine
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			continue
+		}
+		if line.Error != "" {
+			slog.Error("Model pull failed", "model", model, "error", line.Error)
+			out.WriteJSON(PullProgress{Status: "error", Error: line.Error})
+			return
+		}
+
+		progress := PullProgress{Status: line.Status}
+		if line.Total > 0 {
+			progress.Percent = float64(line.Completed*1000/line.Total) / 10
+		}
+		if line.Status == "success" {
+			availableModels.Invalidate()
+			slog.Info("✅ Model pulled", "model", model)
+			progress.Done = true
+		}
+		out.WriteJSON(progress)
+		if progress.Done {
+			return
+		}
+	}
+
+	if ctx.Err() == nil {
+		err := fmt.Errorf("%w: the download ended before it finished", ErrStreamInterrupted)
+		if scanner.Err() != nil {
+			err = fmt.Errorf("%w: %w", ErrStreamInterrupted, scanner.Err())
+		}
+		slog.Error("Model pull failed", "model", model, "error", err)
+		out.WriteJSON(PullProgress{Status: "error", Error: err.Error()})
+	}
+}
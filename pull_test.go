@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readPullEvents parses the data: events of a pull response.
+func readPullEvents(t *testing.T, body string) []PullProgress {
+	t.Helper()
+	var events []PullProgress
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var event PullProgress
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			t.Fatalf("invalid event %q: %v", data, err)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+// TestPullStreamsProgress verifies that Ollama's pull progress is relayed
+// with a percentage and that success ends the stream and refreshes the
+// model list.
+func TestPullStreamsProgress(t *testing.T) {
+	var got map[string]any
+	var gotPath string
+	mockOllama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Write([]byte(`{"status": "pulling manifest"}` + "\n"))
+		w.Write([]byte(`{"status": "pulling 8eeb52dfb3bb", "total": 2000, "completed": 500}` + "\n"))
+		w.Write([]byte(`{"status": "pulling 8eeb52dfb3bb", "total": 2000, "completed": 2000}` + "\n"))
+		w.Write([]byte(`{"status": "success"}` + "\n"))
+	}))
+	defer mockOllama.Close()
+
+	oldURL, oldCache := OllamaAPIURL, availableModels
+	OllamaAPIURL = mockOllama.URL + "/api/chat"
+	availableModels = &modelCache{ttl: time.Minute, models: []ModelInfo{{Name: "old"}}, fetched: time.Now()}
+	defer func() { OllamaAPIURL, availableModels = oldURL, oldCache }()
+
+	rr := httptest.NewRecorder()
+	handlePull(rr, httptest.NewRequest(http.MethodPost, "/api/pull", strings.NewReader(`{"model": "gemma3:1b"}`)))
+
+	if gotPath != "/api/pull" || got["model"] != "gemma3:1b" {
+		t.Errorf("Ollama got path %q and request %v", gotPath, got)
+	}
+	events := readPullEvents(t, rr.Body.String())
+	if len(events) != 4 {
+		t.Fatalf("got %d events, want 4: %+v", len(events), events)
+	}
+	if events[1].Percent != 25 || events[2].Percent != 100 {
+		t.Errorf("got percentages %v and %v, want 25 and 100", events[1].Percent, events[2].Percent)
+	}
+	if last := events[3]; !last.Done || last.Status != "success" {
+		t.Errorf("got final event %+v", last)
+	}
+	if availableModels.models != nil {
+		t.Error("model list was not refreshed after the pull")
+	}
+}
+
+// TestPullReportsErrors verifies that an error from Ollama, such as an
+// unknown model, ends the stream with an error event.
+func TestPullReportsErrors(t *testing.T) {
+	mockOllama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status": "pulling manifest"}` + "\n"))
+		w.Write([]byte(`{"error": "pull model manifest: file does not exist"}` + "\n"))
+	}))
+	defer mockOllama.Close()
+
+	oldURL := OllamaAPIURL
+	OllamaAPIURL = mockOllama.URL + "/api/chat"
+	defer func() { OllamaAPIURL = oldURL }()
+
+	rr := httptest.NewRecorder()
+	handlePull(rr, httptest.NewRequest(http.MethodPost, "/api/pull", strings.NewReader(`{"model": "no-such-model"}`)))
+
+	events := readPullEvents(t, rr.Body.String())
+	if len(events) == 0 {
+		t.Fatal("got no events")
+	}
+	if last := events[len(events)-1]; last.Done || !strings.Contains(last.Error, "does not exist") {
+		t.Errorf("got final event %+v, want the error", last)
+	}
+}
+
+// TestPullErrors verifies the status codes for bad input.
+func TestPullErrors(t *testing.T) {
+	cases := []struct {
+		name   string
+		method string
+		body   string
+		want   int
+	}{
+		{"wrong method", http.MethodGet, "", http.StatusMethodNotAllowed},
+		{"bad json", http.MethodPost, `{`, http.StatusBadRequest},
+		{"no model", http.MethodPost, `{}`, http.StatusBadRequest},
+		{"bad model", http.MethodPost, `{"model": "../etc"}`, http.StatusBadRequest},
+	}
+	for _, tc := range cases {
+		rr := httptest.NewRecorder()
+		handlePull(rr, httptest.NewRequest(tc.method, "/api/pull", strings.NewReader(tc.body)))
+		if rr.Code != tc.want {
+			t.Errorf("%s: got status %d, want %d", tc.name, rr.Code, tc.want)
+		}
+	}
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"io"
+
+	"github.com/mdp/qrterminal/v3"
+)
+
+// ShowQR prints the access URL as a QR code in lan and ngrok mode, so a
+// phone can open the chat by scanning the terminal. It is set once in main.
+var ShowQR = false
+
+// printQR renders url as a QR code on w when ShowQR is set. Half blocks keep
+// it small enough for an ordinary terminal window.
+func printQR(w io.Writer, url string) {
+	if !ShowQR {
+		return
+	}
+	qrterminal.GenerateHalfBlock(url, qrterminal.L, w)
+}
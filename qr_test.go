@@ -0,0 +1,25 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestPrintQR verifies that the QR code is only printed with -qr.
+func TestPrintQR(t *testing.T) {
+	old := ShowQR
+	defer func() { ShowQR = old }()
+
+	var buf bytes.Buffer
+	ShowQR = false
+	printQR(&buf, "http://192.168.1.5:8080")
+	if buf.Len() != 0 {
+		t.Errorf("printed %d bytes without -qr", buf.Len())
+	}
+
+	ShowQR = true
+	printQR(&buf, "http://192.168.1.5:8080")
+	if buf.Len() == 0 {
+		t.Error("printed nothing with -qr")
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Defaults for -rate and -rate-burst: a steady message every two seconds,
+// with room for a quick burst of follow-ups.
+const (
+	DefaultRatePerMinute = 30
+	DefaultRateBurst     = 10
+)
+
+// limiter throttles chat traffic per client IP. A nil limiter allows
+// everything; main replaces it according to the flags.
+var limiter *RateLimiter
+
+// RateLimitLoopback also limits clients connecting from this machine, which
+// are exempt by default so local use is never throttled.
+var RateLimitLoopback = false
+
+// bucket is one client's token bucket.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// RateLimiter is a token-bucket limiter keyed by client IP. Each bucket holds
+// up to burst tokens and refills at rate tokens per second; every request
+// spends one.
+type RateLimiter struct {
+	mu        sync.Mutex
+	rate      float64
+	burst     float64
+	buckets   map[string]*bucket
+	lastPrune time.Time
+}
+
+// NewRateLimiter returns a limiter allowing perMinute requests per minute per
+// IP on average, and up to burst at once.
+func NewRateLimiter(perMinute float64, burst int) *RateLimiter {
+	return &RateLimiter{
+		rate:    perMinute / 60,
+		burst:   float64(burst),
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// Allow spends a token for ip and reports whether one was available.
+func (l *RateLimiter) Allow(ip string) bool {
+	if l == nil {
+		return true
+	}
+	if !RateLimitLoopback {
+		if parsed := net.ParseIP(ip); parsed != nil && parsed.IsLoopback() {
+			return true
+		}
+	}
+	return l.allowAt(ip, time.Now())
+}
+
+func (l *RateLimiter) allowAt(ip string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastPrune) > time.Minute {
+		l.prune(now)
+	}
+
+	b, ok := l.buckets[ip]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[ip] = b
+	}
+	b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// prune forgets buckets that have refilled completely, since a fresh bucket
+// behaves the same. It keeps the map from growing with every IP ever seen.
+func (l *RateLimiter) prune(now time.Time) {
+	for ip, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, ip)
+		}
+	}
+	l.lastPrune = now
+}
+
+// clientIP returns the IP part of the request's remote address.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// rateLimit rejects requests over the client's limit with 429 before they
+// reach next.
+func rateLimit(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if ip := clientIP(r); !limiter.Allow(ip) {
+			log.Printf("🐢 Rate limited request to %s from %s\n", r.URL.Path, ip)
+			w.Header().Set("Retry-After", "60")
+			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestRateLimiterBucket verifies that a client can spend its burst, is then
+// refused, and regains tokens over time independently of other clients.
+func TestRateLimiterBucket(t *testing.T) {
+	l := NewRateLimiter(60, 2) // One token per second
+	now := time.Now()
+
+	if !l.allowAt("1.2.3.4", now) || !l.allowAt("1.2.3.4", now) {
+		t.Fatal("burst requests should be allowed")
+	}
+	if l.allowAt("1.2.3.4", now) {
+		t.Error("request beyond the burst should be refused")
+	}
+	if !l.allowAt("5.6.7.8", now) {
+		t.Error("another IP should have its own bucket")
+	}
+	if !l.allowAt("1.2.3.4", now.Add(time.Second)) {
+		t.Error("bucket should refill after a second")
+	}
+}
+
+// TestRateLimitMiddleware verifies the 429 response and the loopback exemption.
+func TestRateLimitMiddleware(t *testing.T) {
+	oldLimiter := limiter
+	limiter = NewRateLimiter(1, 1)
+	defer func() { limiter = oldLimiter }()
+
+	handler := rateLimit(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	call := func(remoteAddr string) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/chat", nil)
+		req.RemoteAddr = remoteAddr
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		return rr.Code
+	}
+
+	if code := call("203.0.113.7:5000"); code != http.StatusOK {
+		t.Fatalf("first request: got status %d, want %d", code, http.StatusOK)
+	}
+	if code := call("203.0.113.7:5001"); code != http.StatusTooManyRequests {
+		t.Errorf("second request: got status %d, want %d", code, http.StatusTooManyRequests)
+	}
+	for i := 0; i < 3; i++ {
+		if code := call("127.0.0.1:5000"); code != http.StatusOK {
+			t.Errorf("loopback request %d: got status %d, want %d", i, code, http.StatusOK)
+		}
+	}
+}
@@ -0,0 +1,16 @@
+package main
+
+import "encoding/json"
+
+// RawStream forwards each line of Ollama's stream to chat clients as is,
+// in a frame's Raw field, instead of the Chunk, Thinking and ToolCalls
+// taken from it. Status, error and final frames keep their usual shape.
+// OpenAI clients always get OpenAI's format. It is set once in main.
+var RawStream bool
+
+// rawContent returns the reply text of a raw Ollama line.
+func rawContent(line json.RawMessage) string {
+	var chunk OllamaStreamChunk
+	json.Unmarshal(line, &chunk)
+	return chunk.Message.Content
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestRawStream verifies that with RawStream every line of Ollama's stream
+// reaches the client as is, while the history still gets the reply.
+func TestRawStream(t *testing.T) {
+	lines := []string{
+		`{"model":"m","message":{"role":"assistant","content":"","thinking":"Hmm"},"done":false}`,
+		`{"model":"m","message":{"role":"assistant","content":"Hel"},"done":false}`,
+		`{"model":"m","message":{"role":"assistant","content":"lo"},"done":false}`,
+		`{"model":"m","message":{"role":"assistant","content":""},"done":true,"done_reason":"stop","eval_count":3}`,
+	}
+	mockOllama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/tags" {
+			http.NotFound(w, r)
+			return
+		}
+		for _, line := range lines {
+			w.Write([]byte(line + "\n"))
+		}
+	}))
+	defer mockOllama.Close()
+
+	oldURL, oldRaw := OllamaAPIURL, RawStream
+	OllamaAPIURL, RawStream = mockOllama.URL, true
+	defer func() { OllamaAPIURL, RawStream = oldURL, oldRaw }()
+
+	opts, err := ChatRequest{Message: "Hi"}.options()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var raw []string
+	var final StreamResponse
+	out := frameFunc(func(v interface{}) error {
+		frame := v.(StreamResponse)
+		if frame.Chunk != "" || frame.Thinking != "" {
+			t.Errorf("got a text frame %+v in raw mode", frame)
+		}
+		if frame.Raw != nil {
+			raw = append(raw, string(frame.Raw))
+		}
+		if frame.Done {
+			final = frame
+		}
+		return nil
+	})
+	var history []OllamaMessage
+	if err := streamOllama(context.Background(), out, OllamaMessage{Role: "user", Content: "Hi"}, &history, "m", opts); err != nil {
+		t.Fatal(err)
+	}
+	if len(raw) != len(lines) {
+		t.Fatalf("got %d raw frames, want %d", len(raw), len(lines))
+	}
+	for i := range lines {
+		if raw[i] != lines[i] {
+			t.Errorf("frame %d: got %s, want %s", i, raw[i], lines[i])
+		}
+	}
+	if final.Stats == nil || final.Stats.EvalCount != 3 {
+		t.Errorf("final frame %+v, want the usual stats", final)
+	}
+	if reply := history[len(history)-1]; reply.Content != "Hello" {
+		t.Errorf("history kept %+v, want the reply", reply)
+	}
+
+	// A resumed client gets the text so far, taken from the raw lines
+	turn := &pendingTurn{}
+	for _, line := range lines {
+		turn.WriteJSON(StreamResponse{Raw: json.RawMessage(line)})
+	}
+	var resumed StreamResponse
+	turn.attach(frameFunc(func(v interface{}) error {
+		resumed = v.(StreamResponse)
+		return nil
+	}))
+	if resumed.Chunk != "Hello" {
+		t.Errorf("resumed with %q, want the text so far", resumed.Chunk)
+	}
+}
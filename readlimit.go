@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/gorilla/websocket"
+)
+
+// MaxMessageBytes is the largest WebSocket message a client may send,
+// images included. It is set once in main.
+var MaxMessageBytes int64 = 1 << 20
+
+// readLimitFactor sets the hard limit, as a multiple of MaxMessageBytes, at
+// which the connection is closed instead. Messages below it are drained and
+// answered with an error frame, so an oversized paste doesn't disconnect.
+const readLimitFactor = 4
+
+// errMessageTooLarge reports a message over MaxMessageBytes.
+var errMessageTooLarge = errors.New("message too large")
+
+// readRequest reads the next message from conn into req. A message over
+// limit bytes is discarded without being held in memory and reported as
+// errMessageTooLarge; the connection stays usable.
+func readRequest(conn *websocket.Conn, limit int64, req *ChatRequest) error {
+	_, r, err := conn.NextReader()
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return err
+	}
+	if int64(len(data)) > limit {
+		if _, err := io.Copy(io.Discard, r); err != nil {
+			return err
+		}
+		return errMessageTooLarge
+	}
+	return json.Unmarshal(data, req)
+}
+
+// messageTooLargeFrame tells the client its message was dropped.
+func messageTooLargeFrame(limit int64) StreamResponse {
+	return errorFrame(CodeMessageTooLarge, fmt.Sprintf("message is larger than the %d KiB limit", limit>>10))
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// TestOversizedMessage verifies that a message over MaxMessageBytes gets an
+// error frame and leaves the connection usable, and that one far over it
+// closes the connection.
+func TestOversizedMessage(t *testing.T) {
+	mockOllama := mockOllamaServer()
+	defer mockOllama.Close()
+
+	oldURL, oldLimit := OllamaAPIURL, MaxMessageBytes
+	OllamaAPIURL, MaxMessageBytes = mockOllama.URL, 4096
+	defer func() { OllamaAPIURL, MaxMessageBytes = oldURL, oldLimit }()
+
+	server := httptest.NewServer(http.HandlerFunc(handleWebSocket))
+	defer server.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+	ws, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("could not open websocket connection: %v", err)
+	}
+	defer ws.Close()
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	if err := ws.WriteJSON(ChatRequest{Message: strings.Repeat("a", 10000)}); err != nil {
+		t.Fatalf("could not write json: %v", err)
+	}
+	var resp StreamResponse
+	if err := ws.ReadJSON(&resp); err != nil {
+		t.Fatalf("Read failed or timed out: %v", err)
+	}
+	if resp.Code != CodeMessageTooLarge || !resp.Done {
+		t.Fatalf("got frame %+v, want code %q", resp, CodeMessageTooLarge)
+	}
+
+	// The connection still works for messages within the limit
+	if err := ws.WriteJSON(ChatRequest{Message: "Hi"}); err != nil {
+		t.Fatalf("could not write json: %v", err)
+	}
+	for resp = (StreamResponse{}); !resp.Done; {
+		if err := ws.ReadJSON(&resp); err != nil {
+			t.Fatalf("connection unusable after an oversized message: %v", err)
+		}
+		if resp.Code != "" {
+			t.Fatalf("got error frame %+v", resp)
+		}
+	}
+
+	// Past the hard limit, the server hangs up
+	if err := ws.WriteJSON(ChatRequest{Message: strings.Repeat("a", 5*4096)}); err != nil {
+		t.Fatalf("could not write json: %v", err)
+	}
+	err = ws.ReadJSON(&resp)
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseMessageTooBig {
+		t.Errorf("got %v, want close code %d", err, websocket.CloseMessageTooBig)
+	}
+}
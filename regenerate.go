@@ -0,0 +1,32 @@
+package main
+
+import "errors"
+
+// MessageTypeRegenerate asks the server to replace its last reply with a
+// new one to the same message, that of session_id if set. The reply streams
+// like any other; the old one stays in the history if the new one fails.
+const MessageTypeRegenerate = "regenerate"
+
+// RegenerateTemperatureBoost is added to the temperature of regenerated
+// replies, up to the maximum of 2, so they differ from the one replaced.
+const RegenerateTemperatureBoost = 0.2
+
+// errNothingToRegenerate is returned for a regenerate before any reply.
+var errNothingToRegenerate = errors.New("there is no reply to regenerate")
+
+// lastTurn splits history into the messages before its last turn and the
+// prompt that turn answered, dropping the reply. It fails unless history
+// ends with an assistant reply to a user or tool message.
+func lastTurn(history []OllamaMessage) ([]OllamaMessage, OllamaMessage, error) {
+	n := len(history)
+	if n < 2 || history[n-1].Role != "assistant" || history[n-2].Role == "assistant" {
+		return nil, OllamaMessage{}, errNothingToRegenerate
+	}
+	return history[:n-2], history[n-2], nil
+}
+
+// regenerateOptions raises opts' temperature by RegenerateTemperatureBoost.
+func regenerateOptions(opts SamplingOptions) SamplingOptions {
+	opts.Temperature = min(opts.Temperature+RegenerateTemperatureBoost, 2)
+	return opts
+}
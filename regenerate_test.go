@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// TestRegenerate verifies that a regenerate message sends the last user
+// message to Ollama again, warmer, and that its reply replaces the old one
+// in the history instead of adding a second turn.
+func TestRegenerate(t *testing.T) {
+	var mu sync.Mutex
+	var requests []OllamaRequest
+	mockOllama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/tags" {
+			http.NotFound(w, r)
+			return
+		}
+		var req OllamaRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		requests = append(requests, req)
+		n := len(requests)
+		mu.Unlock()
+		fmt.Fprintf(w, `{"message": {"content": "Reply %d"}, "done": true}`+"\n", n)
+	}))
+	defer mockOllama.Close()
+
+	oldURL, oldSessions := OllamaAPIURL, sessions
+	OllamaAPIURL = mockOllama.URL
+	sessions = NewSessionStore(time.Minute, nil)
+	defer func() { OllamaAPIURL, sessions = oldURL, oldSessions }()
+
+	server := httptest.NewServer(http.HandlerFunc(handleWebSocket))
+	defer server.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+	ws, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("could not open websocket connection: %v", err)
+	}
+	defer ws.Close()
+
+	// send writes req and returns the text of its reply, or its error code.
+	send := func(req ChatRequest) (string, string) {
+		if err := ws.WriteJSON(req); err != nil {
+			t.Fatalf("could not write json: %v", err)
+		}
+		ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+		var text strings.Builder
+		for {
+			var resp StreamResponse
+			if err := ws.ReadJSON(&resp); err != nil {
+				t.Fatalf("Read failed or timed out: %v", err)
+			}
+			if resp.Code != "" {
+				return "", resp.Code
+			}
+			text.WriteString(resp.Chunk)
+			if resp.Done {
+				return text.String(), ""
+			}
+		}
+	}
+
+	const id = "regenerate-test"
+	if _, code := send(ChatRequest{Type: MessageTypeRegenerate, SessionID: id}); code != CodeInvalidRequest {
+		t.Errorf("regenerate before any reply: got code %q, want %q", code, CodeInvalidRequest)
+	}
+	if reply, _ := send(ChatRequest{Message: "Tell me a joke", SessionID: id}); reply != "Reply 1" {
+		t.Fatalf("got reply %q", reply)
+	}
+	if reply, _ := send(ChatRequest{Type: MessageTypeRegenerate, SessionID: id}); reply != "Reply 2" {
+		t.Fatalf("got regenerated reply %q", reply)
+	}
+
+	mu.Lock()
+	last := requests[len(requests)-1]
+	mu.Unlock()
+	if len(last.Messages) != 2 || last.Messages[1].Role != "user" || last.Messages[1].Content != "Tell me a joke" {
+		t.Errorf("regenerate sent %+v, want the system prompt and the same user message", last.Messages)
+	}
+	if want := Sampling.Temperature + RegenerateTemperatureBoost; last.Options["temperature"] != want {
+		t.Errorf("regenerate used temperature %v, want %v", last.Options["temperature"], want)
+	}
+
+	history := sessions.Load(id)
+	if len(history) != 2 || history[0].Content != "Tell me a joke" || history[1].Content != "Reply 2" {
+		t.Errorf("got history %+v, want the user message and only the new reply", history)
+	}
+}
+
+// TestRegenerateOptionsCapsTemperature verifies that the boost never pushes
+// the temperature past what Validate accepts.
+func TestRegenerateOptionsCapsTemperature(t *testing.T) {
+	opts := regenerateOptions(SamplingOptions{Temperature: 1.9, TopK: 1, TopP: 0.9})
+	if opts.Temperature != 2 {
+		t.Errorf("got temperature %v, want 2", opts.Temperature)
+	}
+	if err := opts.Validate(); err != nil {
+		t.Error(err)
+	}
+}
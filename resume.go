@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FrameWriter is where a reply's frames go: a WebSocket connection, or a
+// pendingTurn that forwards to whichever connection is watching.
+type FrameWriter interface {
+	WriteJSON(v interface{}) error
+}
+
+// MessageTypeResume asks the server to replay the reply of session_id that
+// was still being generated when the client's previous connection dropped.
+const MessageTypeResume = "resume"
+
+// StatusResumed marks the frame that replays the text generated so far.
+const StatusResumed = "resumed"
+
+// ResumeGrace is how long a reply keeps generating after its client
+// disconnects, waiting for it to reconnect. It is set once in main.
+var ResumeGrace = 30 * time.Second
+
+// pendingTurns are the session replies currently being generated.
+var pendingTurns = &turnRegistry{turns: make(map[string]*pendingTurn)}
+
+// pendingTurn records a session's reply in progress and forwards its frames
+// to the connection currently watching, which changes when the client
+// reconnects. Generation carries on while nobody is watching.
+type pendingTurn struct {
+	mu     sync.Mutex
+	text   strings.Builder
+	out    FrameWriter // nil while the client is away
+	cancel context.CancelFunc
+	grace  time.Duration // ResumeGrace when the turn started
+	done   chan struct{}
+}
+
+// WriteJSON records chunks and forwards v. A failed write means the client
+// is gone, which is not an error for the generation.
+func (p *pendingTurn) WriteJSON(v interface{}) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if frame, ok := v.(StreamResponse); ok && frame.Status == StatusRetrying {
+		p.text.Reset() // The reply starts over
+	} else if ok && frame.Raw != nil {
+		p.text.WriteString(rawContent(frame.Raw))
+	} else if ok && !frame.Done {
+		p.text.WriteString(frame.Chunk)
+	}
+	if p.out != nil && p.out.WriteJSON(v) != nil {
+		p.out = nil
+	}
+	return nil
+}
+
+// attach makes out the watching connection, first replaying the text
+// generated so far.
+func (p *pendingTurn) attach(out FrameWriter) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	out.WriteJSON(StreamResponse{Chunk: p.text.String(), Status: StatusResumed})
+	p.out = out
+}
+
+// watch detaches out once ctx, its connection's context, is done, and
+// cancels the generation unless a client resumes it within ResumeGrace.
+func (p *pendingTurn) watch(ctx context.Context, out FrameWriter) {
+	select {
+	case <-p.done:
+		return
+	case <-ctx.Done():
+	}
+
+	p.mu.Lock()
+	if p.out == out {
+		p.out = nil
+	}
+	p.mu.Unlock()
+
+	select {
+	case <-p.done:
+	case <-time.After(p.grace):
+		p.mu.Lock()
+		if p.out == nil {
+			p.cancel()
+		}
+		p.mu.Unlock()
+	}
+}
+
+// turnRegistry finds a session's pending turn.
+type turnRegistry struct {
+	mu    sync.Mutex
+	turns map[string]*pendingTurn
+}
+
+// start registers a reply for session id, watched by out.
+func (r *turnRegistry) start(id string, out FrameWriter, cancel context.CancelFunc) *pendingTurn {
+	p := &pendingTurn{out: out, cancel: cancel, grace: ResumeGrace, done: make(chan struct{})}
+	r.mu.Lock()
+	r.turns[id] = p
+	r.mu.Unlock()
+	return p
+}
+
+// get returns the reply being generated for session id, if any.
+func (r *turnRegistry) get(id string) *pendingTurn {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.turns[id]
+}
+
+// finish unregisters p once its reply is complete and saved.
+func (r *turnRegistry) finish(id string, p *pendingTurn) {
+	r.mu.Lock()
+	if r.turns[id] == p {
+		delete(r.turns, id)
+	}
+	r.mu.Unlock()
+	close(p.done)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// pausingOllamaServer sends "Hello ", then waits for resume to be closed
+// before finishing the reply with "World".
+func pausingOllamaServer(resume chan struct{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"message": {"content": "Hello "}}` + "\n"))
+		w.(http.Flusher).Flush()
+		select {
+		case <-resume:
+		case <-r.Context().Done():
+			return
+		}
+		w.Write([]byte(`{"message": {"content": "World"}}` + "\n"))
+		w.Write([]byte(`{"done": true}` + "\n"))
+	}))
+}
+
+// TestResumeAfterDisconnect verifies that a session's reply survives its
+// connection dropping mid-stream: a new connection gets the text so far
+// replayed, then the rest, and history holds the complete reply.
+func TestResumeAfterDisconnect(t *testing.T) {
+	resume := make(chan struct{})
+	mockOllama := pausingOllamaServer(resume)
+	defer mockOllama.Close()
+
+	oldURL := OllamaAPIURL
+	OllamaAPIURL = mockOllama.URL
+	defer func() { OllamaAPIURL = oldURL }()
+
+	server := httptest.NewServer(http.HandlerFunc(handleWebSocket))
+	defer server.Close()
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	ws, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("could not open websocket connection: %v", err)
+	}
+	if err := ws.WriteJSON(ChatRequest{Message: "Hi", SessionID: "resume-test"}); err != nil {
+		t.Fatalf("could not write json: %v", err)
+	}
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var resp StreamResponse
+	if err := ws.ReadJSON(&resp); err != nil || resp.Status != StatusGenerating {
+		t.Fatalf("got first frame %+v, err %v", resp, err)
+	}
+	if err := ws.ReadJSON(&resp); err != nil || resp.Chunk != "Hello " {
+		t.Fatalf("got first frame %+v, err %v", resp, err)
+	}
+	ws.Close() // The connection drops mid-reply
+
+	ws, _, err = websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("could not reconnect: %v", err)
+	}
+	defer ws.Close()
+	if err := ws.WriteJSON(ChatRequest{Type: MessageTypeResume, SessionID: "resume-test"}); err != nil {
+		t.Fatalf("could not write json: %v", err)
+	}
+
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	resp = StreamResponse{}
+	if err := ws.ReadJSON(&resp); err != nil {
+		t.Fatalf("did not receive the replay: %v", err)
+	}
+	if resp.Status != StatusResumed || resp.Chunk != "Hello " {
+		t.Fatalf("got replay frame %+v", resp)
+	}
+
+	close(resume)
+	text := resp.Chunk
+	for !resp.Done {
+		resp = StreamResponse{}
+		if err := ws.ReadJSON(&resp); err != nil {
+			t.Fatalf("did not receive the rest of the reply: %v", err)
+		}
+		text += resp.Chunk
+	}
+	if text != "Hello World" {
+		t.Errorf("got reply %q, want %q", text, "Hello World")
+	}
+
+	history := sessions.Load("resume-test")
+	if len(history) != 2 || history[1].Content != "Hello World" {
+		t.Errorf("got history %+v", history)
+	}
+}
+
+// TestAbandonedReplyIsCancelled verifies that a reply nobody resumes within
+// ResumeGrace stops generating.
+func TestAbandonedReplyIsCancelled(t *testing.T) {
+	cancelled := make(chan struct{})
+	mockOllama := stallingOllamaServer(cancelled)
+	defer mockOllama.Close()
+
+	oldURL, oldGrace := OllamaAPIURL, ResumeGrace
+	OllamaAPIURL = mockOllama.URL
+	ResumeGrace = 50 * time.Millisecond
+	defer func() { OllamaAPIURL, ResumeGrace = oldURL, oldGrace }()
+
+	server := httptest.NewServer(http.HandlerFunc(handleWebSocket))
+	defer server.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+	ws, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("could not open websocket connection: %v", err)
+	}
+	if err := ws.WriteJSON(ChatRequest{Message: "Hi", SessionID: "abandon-test"}); err != nil {
+		t.Fatalf("could not write json: %v", err)
+	}
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var resp StreamResponse
+	if err := ws.ReadJSON(&resp); err != nil {
+		t.Fatalf("did not receive first chunk: %v", err)
+	}
+	ws.Close()
+
+	select {
+	case <-cancelled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("abandoned reply was not cancelled after ResumeGrace")
+	}
+
+	// Let the turn wind down before ResumeGrace is restored.
+	for deadline := time.Now().Add(2 * time.Second); pendingTurns.get("abandon-test") != nil; {
+		if time.Now().After(deadline) {
+			t.Fatal("abandoned turn was never finished")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
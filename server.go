@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ShutdownTimeout bounds how long in-flight requests and streams get to finish on exit.
+const ShutdownTimeout = 10 * time.Second
+
+// ReadHeaderTimeout, ReadTimeout and IdleTimeout bound how long a client
+// may take to send a request's headers, the whole request, and the next
+// request on a kept-alive connection, so slow or silent clients can't pile
+// up connections. WebSockets are exempt from ReadTimeout once upgraded,
+// since net/http clears a hijacked connection's deadlines and keepAlive
+// sets its own. There is no write timeout, since streamed replies take as long as they
+// take. Zero disables one. They are set once in main.
+var (
+	ReadHeaderTimeout = 10 * time.Second
+	ReadTimeout       = time.Minute
+	IdleTimeout       = 2 * time.Minute
+)
+
+// newServer returns a server for handler with the timeouts above.
+func newServer(handler http.Handler) *http.Server {
+	return &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: ReadHeaderTimeout,
+		ReadTimeout:       ReadTimeout,
+		IdleTimeout:       IdleTimeout,
+	}
+}
+
+// wsConns tracks every open WebSocket so shutdown can close them properly.
+var wsConns = newConnTracker()
+
+// connTracker records open WebSocket connections. http.Server.Shutdown does
+// not wait for hijacked connections, so we do it ourselves.
+type connTracker struct {
+	mu    sync.Mutex
+	conns map[*websocket.Conn]struct{}
+	wg    sync.WaitGroup
+}
+
+func newConnTracker() *connTracker {
+	return &connTracker{conns: make(map[*websocket.Conn]struct{})}
+}
+
+// Add registers conn. Every Add must be paired with a Remove.
+func (t *connTracker) Add(conn *websocket.Conn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.conns[conn] = struct{}{}
+	t.wg.Add(1)
+}
+
+// Remove unregisters conn once its handler has returned.
+func (t *connTracker) Remove(conn *websocket.Conn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.conns[conn]; ok {
+		delete(t.conns, conn)
+		t.wg.Done()
+	}
+}
+
+// CloseAll sends a close frame to every open connection. Handlers notice
+// the close on their next read and return.
+func (t *connTracker) CloseAll(code int, text string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	msg := websocket.FormatCloseMessage(code, text)
+	for conn := range t.conns {
+		conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+	}
+}
+
+// frameConn is a FrameWriter for a WebSocket connection that more than one
+// goroutine writes frames to, which the connection itself doesn't allow.
+type frameConn struct {
+	mu   sync.Mutex
+	conn *websocket.Conn
+}
+
+func (c *frameConn) WriteJSON(v interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn.WriteJSON(v)
+}
+
+// closeWebSocket tells the client why conn is closing with a close frame
+// carrying code and text, then closes it. If a close frame already went
+// out, as on shutdown or in answer to the client's own, only the
+// connection is closed.
+func closeWebSocket(conn *websocket.Conn, code int, text string) {
+	conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, text), time.Now().Add(time.Second))
+	conn.Close()
+}
+
+// disconnectReason sorts the error that ended a WebSocket's read loop into
+// a log level and message: a client that closed properly, or whose
+// connection this server closed, is routine; one that vanished or sent
+// garbage is worth a warning; anything else is an error.
+func disconnectReason(err error) (slog.Level, string) {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var netErr net.Error
+	switch {
+	case websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway):
+		return slog.LevelInfo, "👋 Client disconnected"
+	case errors.Is(err, net.ErrClosed):
+		return slog.LevelInfo, "👋 Client connection closed"
+	case websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway):
+		return slog.LevelWarn, "Client connection dropped"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return slog.LevelWarn, "Client stopped answering pings"
+	case errors.As(err, &syntaxErr), errors.As(err, &typeErr):
+		return slog.LevelWarn, "Client sent invalid JSON, closing the connection"
+	default:
+		return slog.LevelError, "WebSocket read failed"
+	}
+}
+
+// logDisconnect logs err, which ended a WebSocket's read loop, at the
+// level disconnectReason picks.
+func logDisconnect(err error) {
+	level, msg := disconnectReason(err)
+	slog.Log(context.Background(), level, msg, "error", err)
+}
+
+// Wait blocks until every connection has been removed or ctx is done. On
+// timeout, the remaining connections are closed forcefully.
+func (t *connTracker) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		t.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		t.mu.Lock()
+		for conn := range t.conns {
+			conn.Close()
+		}
+		t.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// waitForShutdown blocks until SIGINT or SIGTERM, then stops server and
+// gives open WebSocket streams up to ShutdownTimeout to finish.
+func waitForShutdown(server *http.Server) {
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	<-stop
+
+	log.Println("🛑 Shutting down...")
+	ctx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		log.Println("Shutdown error:", err)
+	}
+	if err := wsConns.Wait(ctx); err != nil {
+		log.Println("Closed lingering WebSocket connections:", err)
+	}
+	log.Println("👋 Server stopped.")
+}
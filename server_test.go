@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// TestCloseAllSendsGoingAway verifies that shutdown sends open WebSocket
+// clients a close frame and waits for their handlers to exit.
+func TestCloseAllSendsGoingAway(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(handleWebSocket))
+	defer server.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+	ws, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("could not open websocket connection: %v", err)
+	}
+	defer ws.Close()
+
+	// Give the handler a moment to register the connection.
+	time.Sleep(50 * time.Millisecond)
+	wsConns.CloseAll(websocket.CloseGoingAway, "server shutting down")
+
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, _, err = ws.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Fatalf("got %v, want a going-away close error", err)
+	}
+
+	// Reading the close frame makes the client echo it, so the handler exits.
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := wsConns.Wait(ctx); err != nil {
+		t.Errorf("handler did not exit after close: %v", err)
+	}
+}
+
+// TestServerTimeouts verifies that a client dribbling its headers is cut
+// off, while a WebSocket outlives the read timeout.
+func TestServerTimeouts(t *testing.T) {
+	oldHeader, oldRead, oldPing := ReadHeaderTimeout, ReadTimeout, PingInterval
+	ReadHeaderTimeout, ReadTimeout, PingInterval = 100*time.Millisecond, 200*time.Millisecond, 0
+	defer func() { ReadHeaderTimeout, ReadTimeout, PingInterval = oldHeader, oldRead, oldPing }()
+
+	server := httptest.NewUnstartedServer(nil)
+	server.Config = newServer(http.HandlerFunc(handleWebSocket))
+	server.Start()
+	defer server.Close()
+
+	slow, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer slow.Close()
+	slow.Write([]byte("GET / HTTP/1.1\r\nHost: x\r\n"))
+	slow.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadAll(slow); err != nil {
+		t.Errorf("slow client was not disconnected: %v", err)
+	}
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	time.Sleep(3 * ReadTimeout)
+	if err := ws.WriteJSON(ChatRequest{Type: MessageTypeReset}); err != nil {
+		t.Fatal(err)
+	}
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var frame StreamResponse
+	if err := ws.ReadJSON(&frame); err != nil || frame.Status != StatusReset {
+		t.Errorf("WebSocket idle past the read timeout: got %+v, %v", frame, err)
+	}
+}
+
+// TestDisconnectReason verifies that clients closing properly are logged
+// quietly, and vanished or misbehaving ones louder.
+func TestDisconnectReason(t *testing.T) {
+	badJSON := json.Unmarshal([]byte("{"), &ChatRequest{})
+	cases := []struct {
+		err  error
+		want slog.Level
+	}{
+		{&websocket.CloseError{Code: websocket.CloseNormalClosure}, slog.LevelInfo},
+		{&websocket.CloseError{Code: websocket.CloseGoingAway}, slog.LevelInfo},
+		{fmt.Errorf("read: %w", net.ErrClosed), slog.LevelInfo},
+		{&websocket.CloseError{Code: websocket.CloseAbnormalClosure}, slog.LevelWarn},
+		{&net.OpError{Op: "read", Net: "tcp", Err: os.ErrDeadlineExceeded}, slog.LevelWarn},
+		{badJSON, slog.LevelWarn},
+		{errors.New("something odd"), slog.LevelError},
+	}
+	for _, tc := range cases {
+		if got, msg := disconnectReason(tc.err); got != tc.want {
+			t.Errorf("%v: got %v (%q), want %v", tc.err, got, msg, tc.want)
+		}
+	}
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"regexp"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Session lifecycle
+//
+// A client opts into a server-side session by sending a session_id with its
+// ChatRequest. The first message carrying an unknown id creates an empty
+// history for it. Every turn loads the history, runs the chat, and saves the
+// updated history back, refreshing the session's last-seen time. If the
+// WebSocket drops, the client can reconnect and keep sending the same id to
+// continue where it left off.
+//
+// Sessions that receive no messages for longer than the store's TTL are
+// evicted by a background janitor, so abandoned conversations don't grow
+// memory forever. Clients that never send a session_id keep a private history
+// that lives only as long as their connection.
+//
+// When persistence is enabled, every save is also written to disk. Saved
+// sessions are restored on startup, and a session evicted from memory is
+// read back from disk the next time its id is used.
+//
+// Only the latest MaxHistory messages of a session are kept in memory. With
+// persistence the older ones stay on disk, also when later messages are
+// rewritten, as by a regenerate.
+
+// DefaultSessionTTL is how long an idle session is kept before eviction.
+const DefaultSessionTTL = 30 * time.Minute
+
+// sessions holds the histories of every client that sent a session_id.
+var sessions = NewSessionStore(DefaultSessionTTL, nil)
+
+// sessionIDPattern limits session ids to characters that are safe in file names.
+var sessionIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,128}$`)
+
+// MaxHistory caps the messages of a history kept in memory, since only the
+// latest WindowSize are sent to the model anyway. Zero keeps them all. It
+// is set once in main.
+var MaxHistory = 1000
+
+// capHistory drops the oldest messages of history beyond MaxHistory,
+// keeping a leading system message, which applies to the whole
+// conversation. The result doesn't share memory with history once
+// anything was dropped, so the dropped messages can be freed.
+func capHistory(history []OllamaMessage) []OllamaMessage {
+	if MaxHistory <= 0 || len(history) <= MaxHistory {
+		return history
+	}
+	drop := len(history) - MaxHistory
+	if history[0].Role == "system" {
+		return append([]OllamaMessage{history[0]}, history[drop+1:]...)
+	}
+	return slices.Clone(history[drop:])
+}
+
+// extends reports whether history starts with every message of previous,
+// so saving it only adds messages.
+func extends(history, previous []OllamaMessage) bool {
+	return commonPrefix(history, previous) == len(previous)
+}
+
+// commonPrefix returns how many leading messages a and b share.
+func commonPrefix(a, b []OllamaMessage) int {
+	n := 0
+	for n < len(a) && n < len(b) && sameMessage(a[n], b[n]) {
+		n++
+	}
+	return n
+}
+
+// sameMessage reports whether a and b are the same message of a history.
+func sameMessage(a, b OllamaMessage) bool {
+	return a.Role == b.Role && a.Content == b.Content && a.Time.Equal(b.Time) &&
+		a.ToolName == b.ToolName && slices.EqualFunc(a.ToolCalls, b.ToolCalls, sameToolCall)
+}
+
+// sameToolCall reports whether a and b call the same function with the
+// same arguments.
+func sameToolCall(a, b ToolCall) bool {
+	return a.Function.Name == b.Function.Name && bytes.Equal(a.Function.Arguments, b.Function.Arguments)
+}
+
+// validSessionID reports whether id can be used as a session id.
+func validSessionID(id string) bool {
+	return sessionIDPattern.MatchString(id)
+}
+
+// SessionStore maps session ids to conversation histories.
+type SessionStore struct {
+	mu       sync.Mutex
+	messages map[string][]OllamaMessage
+	lastSeen map[string]time.Time
+	titles   map[string]string // Only kept in memory; see titleSession
+	ttl      time.Duration
+	persist  Storage // nil keeps sessions in memory only
+}
+
+// NewSessionStore returns an empty store that evicts sessions idle for
+// longer than ttl. If persist is non-nil, histories are also saved there.
+func NewSessionStore(ttl time.Duration, persist Storage) *SessionStore {
+	return &SessionStore{
+		messages: make(map[string][]OllamaMessage),
+		lastSeen: make(map[string]time.Time),
+		titles:   make(map[string]string),
+		ttl:      ttl,
+		persist:  persist,
+	}
+}
+
+// Restore loads every persisted session into memory and returns how many
+// were found.
+func (s *SessionStore) Restore() (int, error) {
+	if s.persist == nil {
+		return 0, nil
+	}
+	all, err := s.persist.LoadAll()
+	if err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for id, history := range all {
+		s.messages[id] = capHistory(history)
+		s.lastSeen[id] = now
+	}
+	return len(all), nil
+}
+
+// Load returns a copy of the history for id, creating the session if needed.
+func (s *SessionStore) Load(id string) []OllamaMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastSeen[id] = time.Now()
+	history, ok := s.messages[id]
+	if !ok && s.persist != nil {
+		// Evicted from memory, or never seen: fall back to storage
+		var err error
+		if history, err = s.persist.Load(id); err != nil {
+			log.Printf("Could not load session %s: %v\n", id, err)
+		} else if history != nil {
+			history = capHistory(history)
+			s.messages[id] = history
+		}
+	}
+	return append(make([]OllamaMessage, 0, len(history)), history...)
+}
+
+// Full returns the whole history of session id for reading, such as an
+// export: the stored one when there is storage, which keeps the messages
+// capHistory dropped from memory, otherwise the one in memory.
+func (s *SessionStore) Full(id string) []OllamaMessage {
+	history := s.Load(id)
+	if s.persist == nil {
+		return history
+	}
+	stored, err := s.persist.Load(id)
+	if err != nil {
+		log.Printf("Could not load session %s: %v\n", id, err)
+		return history
+	}
+	// Memory is ahead if saving to storage failed
+	if len(stored) < len(history) {
+		return history
+	}
+	return stored
+}
+
+// Save replaces the history for id, keeping the latest MaxHistory messages
+// in memory. Storage only receives what changed since the last save: the
+// messages added, or, when later messages were replaced, as when a reply is
+// regenerated, those from the first that differs. Older messages that are
+// no longer in memory stay stored.
+func (s *SessionStore) Save(id string, history []OllamaMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastSeen[id] = time.Now()
+	previous := s.messages[id]
+	s.messages[id] = capHistory(history)
+
+	if s.persist == nil {
+		return
+	}
+	// Storage ends with previous, so the messages after those both share
+	// are the last ones stored
+	var err error
+	switch keep := commonPrefix(history, previous); {
+	case keep == len(previous) && keep == len(history):
+		// Nothing new
+	case keep == len(previous):
+		err = s.persist.Append(id, history[keep:]...)
+	default:
+		if err = s.persist.DropLast(id, len(previous)-keep); err == nil {
+			err = s.persist.Append(id, history[keep:]...)
+		}
+	}
+	if err != nil {
+		log.Printf("Could not persist session %s: %v\n", id, err)
+	}
+}
+
+// Clear empties the history for id, in memory and in storage.
+func (s *SessionStore) Clear(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastSeen[id] = time.Now()
+	s.messages[id] = []OllamaMessage{}
+	delete(s.titles, id) // The next conversation gets its own
+	if s.persist == nil {
+		return
+	}
+	if err := s.persist.Delete(id); err != nil {
+		log.Printf("Could not clear session %s: %v\n", id, err)
+	}
+}
+
+// Delete forgets session id altogether, in memory and in storage. Unlike
+// Clear it reports a failure to delete the stored history, since the
+// caller asked for the data to be gone.
+func (s *SessionStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.messages, id)
+	delete(s.lastSeen, id)
+	delete(s.titles, id)
+	if s.persist == nil {
+		return nil
+	}
+	return s.persist.Delete(id)
+}
+
+// Title returns the title of session id, or "" if it has none.
+func (s *SessionStore) Title(id string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.titles[id]
+}
+
+// SetTitle names session id.
+func (s *SessionStore) SetTitle(id, title string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.titles[id] = title
+}
+
+// Len returns the number of live sessions.
+func (s *SessionStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.messages)
+}
+
+// evictIdle drops every session last seen before now minus the TTL and
+// returns how many were removed.
+func (s *SessionStore) evictIdle(now time.Time) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	evicted := 0
+	for id, seen := range s.lastSeen {
+		if now.Sub(seen) > s.ttl {
+			delete(s.lastSeen, id)
+			delete(s.messages, id)
+			delete(s.titles, id)
+			evicted++
+		}
+	}
+	return evicted
+}
+
+// RunJanitor evicts idle sessions periodically. It never returns, so call it
+// in its own goroutine.
+func (s *SessionStore) RunJanitor() {
+	ticker := time.NewTicker(s.ttl / 2)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		if n := s.evictIdle(now); n > 0 {
+			log.Printf("🧹 Evicted %d idle session(s)\n", n)
+		}
+	}
+}
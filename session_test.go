@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// TestSessionStoreRoundTrip verifies that a saved history is returned on the next load.
+func TestSessionStoreRoundTrip(t *testing.T) {
+	store := NewSessionStore(time.Minute, nil)
+
+	history := store.Load("abc")
+	if len(history) != 0 {
+		t.Fatalf("new session should be empty, got %d messages", len(history))
+	}
+
+	history = append(history, OllamaMessage{Role: "user", Content: "hi"})
+	store.Save("abc", history)
+
+	if got := store.Load("abc"); len(got) != 1 || got[0].Content != "hi" {
+		t.Errorf("Load returned %+v, want the saved message", got)
+	}
+}
+
+// TestSessionStoreEvictsIdle verifies that only sessions idle past the TTL are evicted.
+func TestSessionStoreEvictsIdle(t *testing.T) {
+	store := NewSessionStore(time.Minute, nil)
+	store.Save("old", []OllamaMessage{{Role: "user", Content: "old"}})
+	store.Save("fresh", []OllamaMessage{{Role: "user", Content: "fresh"}})
+
+	// Pretend "old" was last used two minutes ago.
+	store.lastSeen["old"] = time.Now().Add(-2 * time.Minute)
+
+	if n := store.evictIdle(time.Now()); n != 1 {
+		t.Errorf("evictIdle removed %d sessions, want 1", n)
+	}
+	if store.Len() != 1 {
+		t.Errorf("store has %d sessions, want 1", store.Len())
+	}
+	if got := store.Load("fresh"); len(got) != 1 {
+		t.Error("fresh session should survive eviction")
+	}
+}
+
+// TestResetClearsHistory verifies that a reset message forgets the
+// conversation, and that the next turn starts with just the system prompt.
+func TestResetClearsHistory(t *testing.T) {
+	var mu sync.Mutex
+	var requests []OllamaRequest
+	mockOllama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req OllamaRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		requests = append(requests, req)
+		mu.Unlock()
+		w.Write([]byte(`{"message": {"content": "Sure"}, "done": true}` + "\n"))
+	}))
+	defer mockOllama.Close()
+
+	oldURL, oldSessions := OllamaAPIURL, sessions
+	OllamaAPIURL = mockOllama.URL
+	sessions = NewSessionStore(time.Minute, nil)
+	defer func() { OllamaAPIURL, sessions = oldURL, oldSessions }()
+
+	server := httptest.NewServer(http.HandlerFunc(handleWebSocket))
+	defer server.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+	ws, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("could not open websocket connection: %v", err)
+	}
+	defer ws.Close()
+
+	// send writes req and returns its final frame.
+	send := func(req ChatRequest) StreamResponse {
+		if err := ws.WriteJSON(req); err != nil {
+			t.Fatalf("could not write json: %v", err)
+		}
+		ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+		var resp StreamResponse
+		for !resp.Done {
+			resp = StreamResponse{}
+			if err := ws.ReadJSON(&resp); err != nil {
+				t.Fatalf("Read failed or timed out: %v", err)
+			}
+		}
+		return resp
+	}
+
+	for _, id := range []string{"", "reset-test"} {
+		mu.Lock()
+		requests = nil
+		mu.Unlock()
+
+		send(ChatRequest{Message: "Remember 42", SessionID: id})
+		if resp := send(ChatRequest{Type: MessageTypeReset, SessionID: id}); resp.Status != StatusReset {
+			t.Errorf("session %q: got %+v, want a %q frame", id, resp, StatusReset)
+		}
+		send(ChatRequest{Message: "What did I say?", SessionID: id})
+
+		mu.Lock()
+		last := requests[len(requests)-1]
+		mu.Unlock()
+		if len(last.Messages) != 2 || last.Messages[0].Role != "system" || last.Messages[1].Content != "What did I say?" {
+			t.Errorf("session %q: after reset Ollama got %+v, want the system prompt and the new message", id, last.Messages)
+		}
+	}
+	if history := sessions.Load("reset-test"); len(history) != 2 {
+		t.Errorf("got session history %+v, want only the turn after the reset", history)
+	}
+}
+
+// TestMaxHistory verifies that memory keeps only the latest MaxHistory
+// messages and a leading system message, that the window still sends the
+// latest of them, and that storage keeps every message.
+func TestMaxHistory(t *testing.T) {
+	oldMax, oldWindow := MaxHistory, WindowSize
+	MaxHistory, WindowSize = 4, 2
+	defer func() { MaxHistory, WindowSize = oldMax, oldWindow }()
+
+	persist, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewSessionStore(time.Minute, persist)
+	for i := range 5 {
+		history := store.Load("abc")
+		history = append(history,
+			OllamaMessage{Role: "user", Content: fmt.Sprint("question ", i)},
+			OllamaMessage{Role: "assistant", Content: fmt.Sprint("answer ", i)})
+		store.Save("abc", history)
+	}
+
+	history := store.Load("abc")
+	if len(history) != 4 || history[0].Content != "question 3" || history[3].Content != "answer 4" {
+		t.Errorf("kept %+v, want the last 4 messages", history)
+	}
+	req := buildOllamaRequest(history, "m", Sampling, false)
+	if got := req.Messages[len(req.Messages)-2:]; got[0].Content != "question 4" || got[1].Content != "answer 4" {
+		t.Errorf("window sent %+v, want the last exchange", req.Messages)
+	}
+	if stored, err := persist.Load("abc"); err != nil || len(stored) != 10 {
+		t.Errorf("storage has %d messages (%v), want all 10", len(stored), err)
+	}
+
+	withSystem := append([]OllamaMessage{{Role: "system", Content: "Be brief"}}, history...)
+	withSystem = append(withSystem, OllamaMessage{Role: "user", Content: "question 5"})
+	if got := capHistory(withSystem); len(got) != 4 || got[0].Role != "system" || got[1].Content != "question 4" {
+		t.Errorf("capped %+v, want the system message and the last 3", got)
+	}
+}
+
+// TestSaveRewritesChangedHistory verifies that a history that changed
+// without growing, as when a reply is regenerated, is rewritten in storage.
+func TestSaveRewritesChangedHistory(t *testing.T) {
+	persist, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewSessionStore(time.Minute, persist)
+	store.Save("abc", []OllamaMessage{{Role: "user", Content: "Hi"}, {Role: "assistant", Content: "Hello"}})
+	store.Save("abc", []OllamaMessage{{Role: "user", Content: "Hi"}, {Role: "assistant", Content: "Hey there"}})
+
+	if stored, err := persist.Load("abc"); err != nil || len(stored) != 2 || stored[1].Content != "Hey there" {
+		t.Errorf("storage has %+v (%v), want the regenerated reply", stored, err)
+	}
+}
+
+// TestRegenerateKeepsStoredHistory verifies that regenerating the last
+// reply of a session longer than MaxHistory replaces only that reply in
+// storage, keeping the older messages memory no longer has.
+func TestRegenerateKeepsStoredHistory(t *testing.T) {
+	oldMax := MaxHistory
+	MaxHistory = 4
+	defer func() { MaxHistory = oldMax }()
+
+	files, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := NewSQLiteStore(filepath.Join(t.TempDir(), "chat.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for name, persist := range map[string]Storage{"files": files, "sqlite": db} {
+		store := NewSessionStore(time.Minute, persist)
+		for i := range 5 {
+			store.Save("abc", append(store.Load("abc"),
+				OllamaMessage{Role: "user", Content: fmt.Sprint("question ", i)},
+				OllamaMessage{Role: "assistant", Content: fmt.Sprint("answer ", i)}))
+		}
+
+		history := store.Load("abc")
+		history[len(history)-1].Content = "a better answer 4"
+		store.Save("abc", history)
+
+		full := store.Full("abc")
+		if len(full) != 10 || full[0].Content != "question 0" || full[9].Content != "a better answer 4" {
+			t.Errorf("%s: Full returned %+v, want all 10 messages with the new reply", name, full)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import "strings"
+
+// MessageTypeConfigure sets the model, temperature and system prompt for
+// the rest of the connection, so clients needn't repeat them with every
+// message. Each one replaces the last; fields left out fall back to the
+// server's defaults. It is confirmed with a StatusConfigured frame.
+const MessageTypeConfigure = "configure"
+
+// StatusConfigured confirms a MessageTypeConfigure.
+const StatusConfigured = "configured"
+
+// connSettings are what a connection's last MessageTypeConfigure set. They
+// apply to each of its messages that doesn't set its own.
+type connSettings struct {
+	Model       string
+	Temperature *float64
+	System      string
+}
+
+// configure validates the settings carried by a MessageTypeConfigure
+// request, so a bad one is refused then rather than on every message.
+func (req ChatRequest) configure() (connSettings, error) {
+	settings := connSettings{Model: strings.TrimSpace(req.Model), Temperature: req.Temperature, System: req.System}
+	if _, err := resolveModel(settings.Model); err != nil {
+		return connSettings{}, err
+	}
+	if _, err := req.options(); err != nil {
+		return connSettings{}, err
+	}
+	return settings, nil
+}
+
+// apply fills in the settings req leaves unset. A persona counts as
+// setting the system prompt.
+func (s connSettings) apply(req ChatRequest) ChatRequest {
+	if strings.TrimSpace(req.Model) == "" {
+		req.Model = s.Model
+	}
+	if req.Temperature == nil {
+		req.Temperature = s.Temperature
+	}
+	if req.System == "" && req.Persona == "" {
+		req.System = s.System
+	}
+	return req
+}
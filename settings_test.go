@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// TestConfigureSettings verifies that a configure message sets the model,
+// temperature and system prompt of the connection's later messages, that
+// messages can still override them, and that a bad one changes nothing.
+func TestConfigureSettings(t *testing.T) {
+	var mu sync.Mutex
+	var last OllamaRequest
+	mockOllama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/tags" {
+			http.NotFound(w, r)
+			return
+		}
+		mu.Lock()
+		json.NewDecoder(r.Body).Decode(&last)
+		mu.Unlock()
+		w.Write([]byte(`{"message": {"content": "OK"}, "done": true}` + "\n"))
+	}))
+	defer mockOllama.Close()
+
+	oldURL := OllamaAPIURL
+	OllamaAPIURL = mockOllama.URL
+	defer func() { OllamaAPIURL = oldURL }()
+
+	server := httptest.NewServer(http.HandlerFunc(handleWebSocket))
+	defer server.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+	ws, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("could not open websocket connection: %v", err)
+	}
+	defer ws.Close()
+
+	// send writes req and returns its final frame.
+	send := func(req ChatRequest) StreamResponse {
+		if err := ws.WriteJSON(req); err != nil {
+			t.Fatalf("could not write json: %v", err)
+		}
+		ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+		for {
+			var resp StreamResponse
+			if err := ws.ReadJSON(&resp); err != nil {
+				t.Fatalf("Read failed or timed out: %v", err)
+			}
+			if resp.Done || resp.Code != "" {
+				return resp
+			}
+		}
+	}
+	// sent returns the model, temperature and system prompt Ollama last got.
+	sent := func() (string, interface{}, string) {
+		mu.Lock()
+		defer mu.Unlock()
+		return last.Model, last.Options["temperature"], last.Messages[0].Content
+	}
+
+	temp := 1.2
+	if resp := send(ChatRequest{Type: MessageTypeConfigure, Model: "llama3.2", Temperature: &temp, System: "Answer in French."}); resp.Status != StatusConfigured {
+		t.Fatalf("got %+v, want a %q frame", resp, StatusConfigured)
+	}
+	send(ChatRequest{Message: "Hello"})
+	if model, temperature, system := sent(); model != "llama3.2" || temperature != 1.2 || system != "Answer in French." {
+		t.Errorf("configured: Ollama got model %q, temperature %v, system %q", model, temperature, system)
+	}
+
+	send(ChatRequest{Message: "Hello", Model: "qwen3"})
+	if model, temperature, _ := sent(); model != "qwen3" || temperature != 1.2 {
+		t.Errorf("per message: Ollama got model %q, temperature %v", model, temperature)
+	}
+
+	bad := 3.0
+	if resp := send(ChatRequest{Type: MessageTypeConfigure, Temperature: &bad}); resp.Code != CodeInvalidRequest {
+		t.Errorf("invalid temperature: got %+v, want an error frame", resp)
+	}
+	send(ChatRequest{Message: "Hello"})
+	if model, temperature, _ := sent(); model != "llama3.2" || temperature != 1.2 {
+		t.Errorf("after a bad configure: Ollama got model %q, temperature %v", model, temperature)
+	}
+
+	send(ChatRequest{Type: MessageTypeConfigure})
+	send(ChatRequest{Message: "Hello"})
+	if model, temperature, system := sent(); model != OllamaModel || temperature != Sampling.Temperature || system != SystemPrompt {
+		t.Errorf("cleared: Ollama got model %q, temperature %v, system %q", model, temperature, system)
+	}
+}
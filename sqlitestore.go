@@ -0,0 +1,197 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	_ "modernc.org/sqlite" // Pure Go driver, so no cgo toolchain is needed
+)
+
+// sqliteMigrations are applied in order on startup. The database's
+// user_version records how many have run, so append new steps to the end
+// and never edit old ones.
+var sqliteMigrations = []string{
+	`CREATE TABLE messages (
+		id         INTEGER PRIMARY KEY AUTOINCREMENT,
+		session_id TEXT    NOT NULL,
+		role       TEXT    NOT NULL,
+		content    TEXT    NOT NULL,
+		created_at TIMESTAMP NOT NULL
+	);
+	CREATE INDEX idx_messages_session ON messages (session_id, id);`,
+	// JSON array of base64 images, empty for text-only messages
+	`ALTER TABLE messages ADD COLUMN images TEXT NOT NULL DEFAULT '';`,
+	// JSON array of an assistant message's tool calls, and the tool a
+	// RoleTool message answers for; empty for other messages
+	`ALTER TABLE messages ADD COLUMN tool_calls TEXT NOT NULL DEFAULT '';
+	ALTER TABLE messages ADD COLUMN tool_name TEXT NOT NULL DEFAULT '';`,
+}
+
+// SQLiteStore persists every message as a row with its session id, role and
+// the time it was stored. It suits long-running self-hosted servers better
+// than one JSON file per session.
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+// NewSQLiteStore opens or creates the database at path and migrates it to
+// the latest schema.
+func NewSQLiteStore(path string) (*SQLiteStore, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows one writer at a time; sharing a single connection avoids "database is locked".
+	db.SetMaxOpenConns(1)
+
+	store := &SQLiteStore{db: db}
+	if err := store.migrate(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("migrating %s: %w", path, err)
+	}
+	return store, nil
+}
+
+// migrate runs every migration the database has not seen yet.
+func (s *SQLiteStore) migrate() error {
+	var version int
+	if err := s.db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+
+	for i := version; i < len(sqliteMigrations); i++ {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(sqliteMigrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+		// PRAGMA does not accept placeholders.
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", i+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close releases the database.
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
+
+// Load returns the messages of session id in the order they were stored.
+func (s *SQLiteStore) Load(id string) ([]OllamaMessage, error) {
+	rows, err := s.db.Query(`SELECT role, content, images, tool_calls, tool_name, created_at FROM messages WHERE session_id = ? ORDER BY id`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var history []OllamaMessage
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, msg)
+	}
+	return history, rows.Err()
+}
+
+// LoadAll returns every stored session, keyed by session id.
+func (s *SQLiteStore) LoadAll() (map[string][]OllamaMessage, error) {
+	rows, err := s.db.Query(`SELECT session_id, role, content, images, tool_calls, tool_name, created_at FROM messages ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	all := make(map[string][]OllamaMessage)
+	for rows.Next() {
+		var id string
+		msg, err := scanMessage(rows, &id)
+		if err != nil {
+			return nil, err
+		}
+		all[id] = append(all[id], msg)
+	}
+	return all, rows.Err()
+}
+
+// scanMessage reads a message from a row of role, content, images, tool
+// calls, tool name and creation time, after scanning any leading columns
+// into dest.
+func scanMessage(rows *sql.Rows, dest ...any) (OllamaMessage, error) {
+	var msg OllamaMessage
+	var images, toolCalls string
+	if err := rows.Scan(append(dest, &msg.Role, &msg.Content, &images, &toolCalls, &msg.ToolName, &msg.Time)...); err != nil {
+		return msg, err
+	}
+	if images != "" {
+		if err := json.Unmarshal([]byte(images), &msg.Images); err != nil {
+			return msg, fmt.Errorf("decoding images: %w", err)
+		}
+	}
+	if toolCalls != "" {
+		if err := json.Unmarshal([]byte(toolCalls), &msg.ToolCalls); err != nil {
+			return msg, fmt.Errorf("decoding tool calls: %w", err)
+		}
+	}
+	return msg, nil
+}
+
+// Append inserts msgs for session id in a single transaction.
+func (s *SQLiteStore) Append(id string, msgs ...OllamaMessage) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() // No-op after Commit
+
+	now := time.Now().UTC()
+	for _, msg := range msgs {
+		created := now
+		if !msg.Time.IsZero() {
+			created = msg.Time.UTC()
+		}
+		var images, toolCalls string
+		if len(msg.Images) > 0 {
+			data, _ := json.Marshal(msg.Images)
+			images = string(data)
+		}
+		if len(msg.ToolCalls) > 0 {
+			data, err := json.Marshal(msg.ToolCalls)
+			if err != nil {
+				return fmt.Errorf("encoding tool calls: %w", err)
+			}
+			toolCalls = string(data)
+		}
+		_, err := tx.Exec(`INSERT INTO messages (session_id, role, content, images, tool_calls, tool_name, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			id, msg.Role, msg.Content, images, toolCalls, msg.ToolName, created)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// DropLast deletes the last n messages of session id.
+func (s *SQLiteStore) DropLast(id string, n int) error {
+	_, err := s.db.Exec(`DELETE FROM messages WHERE id IN (
+		SELECT id FROM messages WHERE session_id = ? ORDER BY id DESC LIMIT ?)`, id, n)
+	return err
+}
+
+// Delete removes every message of session id.
+func (s *SQLiteStore) Delete(id string) error {
+	_, err := s.db.Exec(`DELETE FROM messages WHERE session_id = ?`, id)
+	return err
+}
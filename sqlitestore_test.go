@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// TestSQLiteStoreRoundTrip verifies that appended messages are stored per
+// session, in order, and survive reopening the database.
+func TestSQLiteStoreRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chat.db")
+
+	store, err := NewSQLiteStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Append("a", OllamaMessage{Role: "user", Content: "hi", Images: []string{pixelPNG}}, OllamaMessage{Role: "assistant", Content: "yo"})
+	store.Append("b", OllamaMessage{Role: "user", Content: "other"})
+	store.Close()
+
+	// Reopening runs the migrations again, which must be a no-op.
+	store, err = NewSQLiteStore(path)
+	if err != nil {
+		t.Fatalf("reopening: %v", err)
+	}
+	defer store.Close()
+
+	history, err := store.Load("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 || history[0].Role != "user" || history[1].Content != "yo" ||
+		len(history[0].Images) != 1 || history[1].Images != nil {
+		t.Errorf("got history %+v", history)
+	}
+
+	all, err := store.LoadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 || len(all["b"]) != 1 {
+		t.Errorf("LoadAll returned %+v", all)
+	}
+
+	if err := store.Delete("a"); err != nil {
+		t.Fatal(err)
+	}
+	if history, _ := store.Load("a"); len(history) != 0 {
+		t.Errorf("session still has %d messages after Delete", len(history))
+	}
+}
+
+// TestSessionStoreAppendsToSQLite verifies that each turn only appends the
+// new messages, and a shrunken history is rewritten.
+func TestSessionStoreAppendsToSQLite(t *testing.T) {
+	store, err := NewSQLiteStore(filepath.Join(t.TempDir(), "chat.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	sessions := NewSessionStore(time.Minute, store)
+	history := sessions.Load("abc")
+	history = append(history, OllamaMessage{Role: "user", Content: "1"}, OllamaMessage{Role: "assistant", Content: "2"})
+	sessions.Save("abc", history)
+	history = append(history, OllamaMessage{Role: "user", Content: "3"})
+	sessions.Save("abc", history)
+
+	var rows int
+	store.db.QueryRow(`SELECT COUNT(*) FROM messages WHERE session_id = ?`, "abc").Scan(&rows)
+	if rows != 3 {
+		t.Errorf("got %d rows, want 3", rows)
+	}
+
+	sessions.Save("abc", history[:1])
+	if stored, _ := store.Load("abc"); len(stored) != 1 {
+		t.Errorf("got %d stored messages after shrinking, want 1", len(stored))
+	}
+}
+
+// TestSQLiteStoreToolTurn verifies that an assistant's tool calls and the
+// name on a tool's result survive the database, and that changing a stored
+// tool call rewrites the history rather than appending to it.
+func TestSQLiteStoreToolTurn(t *testing.T) {
+	store, err := NewSQLiteStore(filepath.Join(t.TempDir(), "chat.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	var call ToolCall
+	call.Function.Name = "get_weather"
+	call.Function.Arguments = json.RawMessage(`{"city":"Oslo"}`)
+	history := []OllamaMessage{
+		{Role: "user", Content: "Weather in Oslo?"},
+		{Role: "assistant", ToolCalls: []ToolCall{call}},
+		{Role: RoleTool, Content: "4°C", ToolName: "get_weather"},
+		{Role: "assistant", Content: "It's 4°C."},
+	}
+	sessions := NewSessionStore(time.Minute, store)
+	sessions.Save("abc", history)
+
+	stored, err := store.Load("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 4 || len(stored[1].ToolCalls) != 1 || !sameToolCall(stored[1].ToolCalls[0], call) ||
+		stored[2].ToolName != "get_weather" || stored[3].ToolCalls != nil {
+		t.Fatalf("got history %+v", stored)
+	}
+
+	changed := slices.Clone(history)
+	changed[1].ToolCalls = []ToolCall{call}
+	changed[1].ToolCalls[0].Function.Arguments = json.RawMessage(`{"city":"Bergen"}`)
+	sessions.Save("abc", changed)
+	if stored, _ := store.Load("abc"); len(stored) != 4 || string(stored[1].ToolCalls[0].Function.Arguments) != `{"city":"Bergen"}` {
+		t.Errorf("after changing the tool call, stored %+v", stored)
+	}
+}
@@ -0,0 +1,17 @@
+package main
+
+// Storage is a durable backend for session histories. SessionStore keeps
+// the working copy in memory and mirrors every change into its Storage, so
+// a nil Storage means sessions live in memory only, which is the default.
+type Storage interface {
+	// Load returns the history of session id, or nil if it was never saved.
+	Load(id string) ([]OllamaMessage, error)
+	// LoadAll returns every saved history, keyed by session id.
+	LoadAll() (map[string][]OllamaMessage, error)
+	// Append adds msgs to the end of session id's history.
+	Append(id string, msgs ...OllamaMessage) error
+	// DropLast removes the last n messages of session id's history.
+	DropLast(id string, n int) error
+	// Delete removes session id's history entirely.
+	Delete(id string) error
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+)
+
+// sseWriter is a FrameWriter that sends each frame as a Server-Sent Event.
+type sseWriter struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+}
+
+func (s sseWriter) WriteJSON(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(s.w, "data: %s\n\n", data); err != nil {
+		return err
+	}
+	if s.flusher != nil {
+		s.flusher.Flush()
+	}
+	return nil
+}
+
+// handleStream streams the reply to a ChatRequest as Server-Sent Events,
+// one StreamResponse frame per event, for browsers' EventSource and curl.
+// POST takes the request as JSON; GET takes message, session_id and model
+// as query parameters, since EventSource can only GET. Once the stream has
+// started, failures arrive as an error frame rather than an HTTP status.
+func handleStream(w http.ResponseWriter, r *http.Request) {
+	var req ChatRequest
+	switch r.Method {
+	case http.MethodGet:
+		q := r.URL.Query()
+		req = ChatRequest{Message: q.Get("message"), SessionID: q.Get("session_id"), Model: q.Get("model")}
+	case http.MethodPost:
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if req.Message == "" {
+		http.Error(w, "message is required", http.StatusBadRequest)
+		return
+	}
+
+	if req.SessionID != "" && !validSessionID(req.SessionID) {
+		http.Error(w, "invalid session_id", http.StatusBadRequest)
+		return
+	}
+	model, err := resolveModel(req.Model)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validateImages(r.Context(), model, req.Images); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	opts, err := req.options()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	flusher, _ := w.(http.Flusher)
+	out := sseWriter{w: w, flusher: flusher}
+
+	var history []OllamaMessage
+	if req.SessionID != "" {
+		history = sessions.Load(req.SessionID)
+	}
+
+	// A client that disconnects cancels r.Context(), which stops the generation
+	err = streamOllama(r.Context(), out, req.prompt(), &history, model, opts)
+	if errors.Is(err, ErrClientGone) || r.Context().Err() != nil {
+		// Nobody is left to read the reply, so it isn't saved or titled either
+		return
+	}
+	if err != nil {
+		ollamaFailures.WithLabelValues(model).Inc()
+		slog.Error("Ollama error", "session", req.SessionID, "model", model, "error", err)
+		out.WriteJSON(ollamaErrorFrame(err))
+		return
+	}
+
+	if req.SessionID != "" {
+		sessions.Save(req.SessionID, history)
+	}
+	if title := titleSession(r.Context(), req.SessionID, model, history); title != "" {
+		out.WriteJSON(StreamResponse{Status: StatusTitled, Title: title})
+	}
+}
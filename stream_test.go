@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestStreamSSE verifies that /api/stream sends the reply as data: events
+// ending with a done frame and saves it to the session.
+func TestStreamSSE(t *testing.T) {
+	mockOllama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"message": {"content": "Hello "}}` + "\n"))
+		w.Write([]byte(`{"message": {"content": "there"}}` + "\n"))
+		w.Write([]byte(`{"message": {"content": ""}, "done": true, "eval_count": 2}` + "\n"))
+	}))
+	defer mockOllama.Close()
+
+	oldURL, oldSessions := OllamaAPIURL, sessions
+	OllamaAPIURL = mockOllama.URL
+	sessions = NewSessionStore(time.Minute, nil)
+	defer func() { OllamaAPIURL, sessions = oldURL, oldSessions }()
+
+	query := url.Values{"message": {"Hi"}, "session_id": {"sse"}}
+	rr := httptest.NewRecorder()
+	handleStream(rr, httptest.NewRequest(http.MethodGet, "/api/stream?"+query.Encode(), nil))
+
+	if ct := rr.Header().Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("got Content-Type %q, want text/event-stream", ct)
+	}
+
+	var text strings.Builder
+	var last StreamResponse
+	scanner := bufio.NewScanner(rr.Body)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		last = StreamResponse{}
+		if err := json.Unmarshal([]byte(data), &last); err != nil {
+			t.Fatalf("invalid event %q: %v", data, err)
+		}
+		text.WriteString(last.Chunk)
+	}
+	if text.String() != "Hello there" {
+		t.Errorf("got text %q", text.String())
+	}
+	if !last.Done || last.Stats == nil || last.Stats.EvalCount != 2 {
+		t.Errorf("got final frame %+v", last)
+	}
+
+	history := sessions.Load("sse")
+	if len(history) != 2 || history[1].Content != "Hello there" {
+		t.Errorf("got history %+v", history)
+	}
+}
+
+// TestStreamDisconnectCancelsOllama verifies that a client closing the
+// event stream cancels the Ollama request.
+func TestStreamDisconnectCancelsOllama(t *testing.T) {
+	cancelled := make(chan struct{})
+	mockOllama := stallingOllamaServer(cancelled)
+	defer mockOllama.Close()
+
+	oldURL := OllamaAPIURL
+	OllamaAPIURL = mockOllama.URL
+	defer func() { OllamaAPIURL = oldURL }()
+
+	server := httptest.NewServer(http.HandlerFunc(handleStream))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, server.URL, strings.NewReader(`{"message": "Hi"}`))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	// Wait for the first event, then hang up
+	if _, err := bufio.NewReader(resp.Body).ReadString('\n'); err != nil {
+		t.Fatalf("reading first event: %v", err)
+	}
+	cancel()
+
+	select {
+	case <-cancelled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Ollama request was not cancelled after the client disconnected")
+	}
+}
+
+// TestStreamDisconnectSavesNothing verifies that a reply cut short by the
+// client hanging up is neither saved to the session nor titled.
+func TestStreamDisconnectSavesNothing(t *testing.T) {
+	ctx, hangUp := context.WithCancel(context.Background())
+	var chats atomic.Int32
+	mockOllama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/chat" {
+			http.NotFound(w, r)
+			return
+		}
+		chats.Add(1)
+		w.Write([]byte(`{"message": {"content": "Once upon"}}` + "\n"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer mockOllama.Close()
+
+	oldURL, oldSessions, oldTitles := OllamaAPIURL, sessions, Titles
+	OllamaAPIURL, Titles = mockOllama.URL+"/api/chat", true
+	sessions = NewSessionStore(time.Minute, nil)
+	defer func() { OllamaAPIURL, sessions, Titles = oldURL, oldSessions, oldTitles }()
+
+	query := url.Values{"message": {"Hi"}, "session_id": {"gone"}}
+	// The client hangs up once it has the first chunk
+	rr := hangUpWriter{httptest.NewRecorder(), hangUp}
+	handleStream(rr, httptest.NewRequest(http.MethodGet, "/api/stream?"+query.Encode(), nil).WithContext(ctx))
+
+	if history := sessions.Load("gone"); len(history) != 0 {
+		t.Errorf("saved %+v for a client that hung up", history)
+	}
+	if n := chats.Load(); n != 1 {
+		t.Errorf("Ollama got %d chat requests, want 1 without a title request", n)
+	}
+}
+
+// hangUpWriter calls hangUp once it has been sent some text.
+type hangUpWriter struct {
+	*httptest.ResponseRecorder
+	hangUp context.CancelFunc
+}
+
+func (h hangUpWriter) Write(p []byte) (int, error) {
+	if strings.Contains(string(p), "Once upon") {
+		defer h.hangUp()
+	}
+	return h.ResponseRecorder.Write(p)
+}
+
+// TestStreamErrors verifies the status codes for bad input.
+func TestStreamErrors(t *testing.T) {
+	cases := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		want   int
+	}{
+		{"wrong method", http.MethodPut, "/api/stream", "", http.StatusMethodNotAllowed},
+		{"bad json", http.MethodPost, "/api/stream", `{`, http.StatusBadRequest},
+		{"no message", http.MethodGet, "/api/stream", "", http.StatusBadRequest},
+		{"bad session", http.MethodGet, "/api/stream?message=hi&session_id=../x", "", http.StatusBadRequest},
+	}
+	for _, tc := range cases {
+		rr := httptest.NewRecorder()
+		handleStream(rr, httptest.NewRequest(tc.method, tc.target, strings.NewReader(tc.body)))
+		if rr.Code != tc.want {
+			t.Errorf("%s: got status %d, want %d", tc.name, rr.Code, tc.want)
+		}
+	}
+}
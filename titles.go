@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// Titles turns on naming sessions after their first exchange, for UIs that
+// list past chats. It is set once in main.
+var Titles bool
+
+// TitleTimeout bounds the extra request that names a session.
+var TitleTimeout = 30 * time.Second
+
+// MaxTitleLength caps a title, in bytes, in case the model rambles.
+const MaxTitleLength = 80
+
+// StatusTitled marks the frame carrying a session's new Title, sent after
+// the first reply's final frame.
+const StatusTitled = "titled"
+
+// titlePrompt asks the model for a title of the conversation before it.
+const titlePrompt = "Give this conversation a title of 3 to 5 words. Answer with the title only, without quotes or punctuation at the end."
+
+// titleSession names session id after its first exchange and returns the
+// title, or "" when there is nothing new to tell: titling is off, the
+// session already has a title or more than one exchange, or titling
+// failed, which is only logged so the chat carries on.
+func titleSession(ctx context.Context, id, model string, history []OllamaMessage) string {
+	if id == "" || !firstExchange(history) || !Titles || sessions.Title(id) != "" {
+		return ""
+	}
+	title, err := generateTitle(ctx, model, history)
+	if err != nil {
+		slog.Warn("🏷️  Could not title the session", "session", id, "model", model, "error", err)
+		return ""
+	}
+	sessions.SetTitle(id, title)
+	return title
+}
+
+// firstExchange reports whether history is one user message and its reply.
+func firstExchange(history []OllamaMessage) bool {
+	users := 0
+	for _, m := range history {
+		if m.Role == "user" {
+			users++
+		}
+	}
+	n := len(history)
+	return users == 1 && n >= 2 && history[n-1].Role == "assistant" && history[n-1].Content != ""
+}
+
+// generateTitle asks model, without streaming, for a short title of history.
+func generateTitle(ctx context.Context, model string, history []OllamaMessage) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, TitleTimeout)
+	defer cancel()
+	release, err := acquireGeneration(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
+	messages := append(stripImages(history), OllamaMessage{Role: "user", Content: titlePrompt})
+	resp, err := postOllama(ctx, OllamaRequest{
+		Model:     model,
+		Messages:  messages,
+		Options:   map[string]interface{}{"temperature": 0.2, "num_predict": 24},
+		KeepAlive: KeepAlive,
+	})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if err := checkOllamaStatus(resp); err != nil {
+		return "", err
+	}
+
+	var chunk OllamaStreamChunk
+	if err := json.NewDecoder(resp.Body).Decode(&chunk); err != nil {
+		return "", fmt.Errorf("decoding title: %w", err)
+	}
+	title := cleanTitle(chunk.Message.Content)
+	if title == "" {
+		return "", fmt.Errorf("the model answered with an empty title")
+	}
+	return title, nil
+}
+
+// stripImages returns history without its images, which a title doesn't
+// need and which would only slow the request down.
+func stripImages(history []OllamaMessage) []OllamaMessage {
+	stripped := make([]OllamaMessage, len(history))
+	for i, m := range history {
+		m.Images = nil
+		stripped[i] = m
+	}
+	return stripped
+}
+
+// cleanTitle keeps the first line of a model's answer, without the quotes,
+// "Title:" prefix and trailing punctuation models like to add.
+func cleanTitle(s string) string {
+	s, _, _ = strings.Cut(strings.TrimSpace(s), "\n")
+	s = strings.TrimSpace(s)
+	if prefix := "title:"; len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix) {
+		s = s[len(prefix):]
+	}
+	s = strings.Trim(s, " \t\"'*#`“”‘’.!")
+	if len(s) > MaxTitleLength {
+		cut := strings.LastIndexByte(s[:MaxTitleLength+1], ' ')
+		if cut <= 0 {
+			cut = MaxTitleLength
+		}
+		s = strings.ToValidUTF8(s[:cut], "")
+	}
+	return s
+}
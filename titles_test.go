@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// TestCleanTitle verifies that the decoration models add to titles is
+// stripped and long ones are cut between words.
+func TestCleanTitle(t *testing.T) {
+	cases := map[string]string{
+		"Planning a Paris Trip":                   "Planning a Paris Trip",
+		`"Planning a Paris Trip."`:                "Planning a Paris Trip",
+		"Title: **Go Error Handling**\n\nBecause": "Go Error Handling",
+		"  ":                        "",
+		strings.Repeat("word ", 30): strings.TrimSpace(strings.Repeat("word ", 16)),
+	}
+	for in, want := range cases {
+		if got := cleanTitle(in); got != want {
+			t.Errorf("cleanTitle(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+// titleOllamaServer answers chats with "Sure" and title requests with
+// title, or a 500 if title is empty, counting the title requests.
+func titleOllamaServer(title string, titled *atomic.Int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/tags" {
+			http.NotFound(w, r)
+			return
+		}
+		var req OllamaRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Messages[len(req.Messages)-1].Content != titlePrompt {
+			w.Write([]byte(`{"message": {"content": "Sure"}, "done": true}` + "\n"))
+			return
+		}
+		titled.Add(1)
+		if title == "" {
+			http.Error(w, `{"error": "model crashed"}`, http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"message": OllamaMessage{Role: "assistant", Content: title}, "done": true})
+	}))
+}
+
+// TestTitleAfterFirstExchange verifies that with -titles a session's first
+// reply is followed by a frame naming it, and that later replies aren't.
+func TestTitleAfterFirstExchange(t *testing.T) {
+	var titled atomic.Int32
+	mockOllama := titleOllamaServer(`"Paris Trip Planning."`, &titled)
+	defer mockOllama.Close()
+
+	oldURL, oldTitles, oldSessions := OllamaAPIURL, Titles, sessions
+	OllamaAPIURL, Titles, sessions = mockOllama.URL, true, NewSessionStore(time.Minute, nil)
+	defer func() { OllamaAPIURL, Titles, sessions = oldURL, oldTitles, oldSessions }()
+
+	server := httptest.NewServer(http.HandlerFunc(handleWebSocket))
+	defer server.Close()
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+	ws, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("could not open websocket connection: %v", err)
+	}
+	defer ws.Close()
+
+	// send writes a message and reads its reply up to the final frame.
+	send := func(message string) {
+		if err := ws.WriteJSON(ChatRequest{Message: message, SessionID: "title-test"}); err != nil {
+			t.Fatalf("could not write json: %v", err)
+		}
+		ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+		for {
+			var resp StreamResponse
+			if err := ws.ReadJSON(&resp); err != nil {
+				t.Fatalf("Read failed or timed out: %v", err)
+			}
+			if resp.Done {
+				return
+			}
+		}
+	}
+
+	send("Help me plan a trip to Paris")
+	var resp StreamResponse
+	if err := ws.ReadJSON(&resp); err != nil || resp.Status != StatusTitled || resp.Title != "Paris Trip Planning" {
+		t.Fatalf("got %+v, err %v, want the title frame", resp, err)
+	}
+	send("What about museums?")
+	// A round trip makes sure the handler is done with the reply
+	if err := ws.WriteJSON(ChatRequest{Type: MessageTypeConfigure}); err != nil {
+		t.Fatalf("could not write json: %v", err)
+	}
+	if err := ws.ReadJSON(&resp); err != nil || resp.Status != StatusConfigured {
+		t.Fatalf("got %+v, err %v, want the configure confirmation, not a title", resp, err)
+	}
+	if n := titled.Load(); n != 1 {
+		t.Errorf("asked for a title %d times, want once", n)
+	}
+	if got := sessions.Title("title-test"); got != "Paris Trip Planning" {
+		t.Errorf("stored title %q", got)
+	}
+}
+
+// TestTitleFailureKeepsChatWorking verifies that a failed titling request
+// leaves the reply intact and just goes without a title.
+func TestTitleFailureKeepsChatWorking(t *testing.T) {
+	var titled atomic.Int32
+	mockOllama := titleOllamaServer("", &titled)
+	defer mockOllama.Close()
+
+	oldURL, oldTitles, oldSessions := OllamaAPIURL, Titles, sessions
+	OllamaAPIURL, Titles, sessions = mockOllama.URL, true, NewSessionStore(time.Minute, nil)
+	defer func() { OllamaAPIURL, Titles, sessions = oldURL, oldTitles, oldSessions }()
+
+	rr := httptest.NewRecorder()
+	handleChatAPI(rr, httptest.NewRequest(http.MethodPost, "/api/chat", strings.NewReader(`{"message": "Hi", "session_id": "untitled"}`)))
+	var reply ChatReply
+	json.NewDecoder(rr.Body).Decode(&reply)
+	if rr.Code != http.StatusOK || reply.Reply != "Sure" || reply.Title != "" {
+		t.Errorf("got %d %+v", rr.Code, reply)
+	}
+	if titled.Load() != 1 {
+		t.Error("no title was asked for")
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// TokenBudget caps the estimated tokens sent to Ollama per request, system
+// prompt included, so long messages can't overflow the model's context. Zero
+// disables the cap. It is set once in main.
+var TokenBudget = 4096
+
+// messageOverhead approximates the tokens a chat template adds around each
+// message for its role markers.
+const messageOverhead = 4
+
+// CountTokens estimates how many tokens text uses. Replace it to plug in a
+// real tokenizer.
+var CountTokens = estimateTokens
+
+// estimateTokens approximates one token per four characters, which is close
+// enough for English text with most tokenizers.
+func estimateTokens(text string) int {
+	return (utf8.RuneCountInString(text) + 3) / 4
+}
+
+// messageTokens is the estimated cost of msg including its role overhead.
+func messageTokens(msg OllamaMessage) int {
+	return CountTokens(msg.Content) + messageOverhead
+}
+
+// trimToBudget drops the oldest messages of history until it fits in budget
+// alongside system. The newest message is always kept, even if it alone is
+// over budget.
+func trimToBudget(system OllamaMessage, history []OllamaMessage, budget int) []OllamaMessage {
+	if budget <= 0 {
+		return history
+	}
+
+	used := messageTokens(system)
+	start := len(history)
+	for start > 0 {
+		cost := messageTokens(history[start-1])
+		if used+cost > budget && start < len(history) {
+			break
+		}
+		used += cost
+		start--
+	}
+	return history[start:]
+}
+
+// replyShare is the part of a num_ctx window left for the reply when the
+// request doesn't cap it with max_tokens, as 1/replyShare of the window.
+const replyShare = 4
+
+// contextBudget is the token budget of a request: the context window it
+// asked for with num_ctx, less room for the reply, or else TokenBudget.
+// The reply gets its max_tokens, or a quarter of the window, but at most
+// half, so a prompt trimmed to fit doesn't overflow the window as soon as
+// Ollama starts generating.
+func contextBudget(opts SamplingOptions) int {
+	if opts.NumCtx > 0 {
+		reserve := opts.NumCtx / replyShare
+		if opts.NumPredict > 0 {
+			reserve = opts.NumPredict
+		}
+		return opts.NumCtx - min(reserve, opts.NumCtx/2)
+	}
+	return TokenBudget
+}
+
+// trimMarker stands in for the text fitPrompt cut out of a message.
+const trimMarker = "\n\n[…]\n\n"
+
+// fitPrompt cuts the middle out of prompt if it alone, alongside system,
+// is over budget, which trimToBudget can't help with. The start and end
+// are kept since that is where a pasted document's question tends to be.
+// The warning describes what was cut, or is "" if prompt already fits.
+func fitPrompt(system, prompt OllamaMessage, budget int) (fitted OllamaMessage, warning string) {
+	room := budget - messageTokens(system) - messageOverhead
+	tokens := CountTokens(prompt.Content)
+	if budget <= 0 || room <= 0 || tokens <= room {
+		return prompt, "" // Nothing to gain if the system prompt fills the budget by itself
+	}
+	runes := []rune(prompt.Content)
+	keep := len(runes) * room / tokens
+	cut := func(keep int) string {
+		return string(runes[:keep/2]) + trimMarker + string(runes[len(runes)-(keep-keep/2):])
+	}
+	for keep > 0 && CountTokens(cut(keep)) > room {
+		keep -= keep/10 + 1
+	}
+	if keep <= 0 {
+		prompt.Content = ""
+	} else {
+		prompt.Content = cut(keep)
+	}
+	return prompt, fmt.Sprintf("Your message is about %d tokens, more than the %d that fit in the model's context, so its middle was left out. Send a shorter message or ask for a larger num_ctx.", tokens, room)
+}
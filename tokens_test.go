@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestTokenBudgetTrimsOversizedHistory verifies that long messages are dropped
+// oldest first so the payload stays under the token budget.
+func TestTokenBudgetTrimsOversizedHistory(t *testing.T) {
+	oldBudget := TokenBudget
+	TokenBudget = 1000
+	defer func() { TokenBudget = oldBudget }()
+
+	// Each message is ~300 tokens, so only a few fit in the budget.
+	history := make([]OllamaMessage, 0)
+	for i := 0; i < 8; i++ {
+		history = append(history, OllamaMessage{Role: "user", Content: strings.Repeat("word ", 240)})
+	}
+	history = append(history, OllamaMessage{Role: "user", Content: "latest"})
+
+	messages := buildOllamaRequest(history, "test", Sampling, true).Messages
+
+	total := 0
+	for _, msg := range messages {
+		total += messageTokens(msg)
+	}
+	if total > TokenBudget {
+		t.Errorf("payload uses ~%d tokens, want at most %d", total, TokenBudget)
+	}
+	if messages[0].Role != "system" {
+		t.Error("system prompt should always be kept")
+	}
+	if last := messages[len(messages)-1]; last.Content != "latest" {
+		t.Errorf("newest message should be kept, got %q", last.Content)
+	}
+	if len(messages) >= len(history)+1 {
+		t.Error("expected some history to be trimmed")
+	}
+}
+
+// TestTrimToBudgetKeepsNewestMessage verifies that a single message over
+// budget is still sent rather than leaving the model with no prompt.
+func TestTrimToBudgetKeepsNewestMessage(t *testing.T) {
+	system := OllamaMessage{Role: "system", Content: "Sys"}
+	history := []OllamaMessage{
+		{Role: "user", Content: "old"},
+		{Role: "user", Content: strings.Repeat("x", 1000)},
+	}
+
+	got := trimToBudget(system, history, 50)
+	if len(got) != 1 || got[0].Content != history[1].Content {
+		t.Errorf("got %d messages, want only the newest", len(got))
+	}
+}
+
+// TestGiantMessageIsTrimmed verifies that a single message too long for the
+// context has its middle cut out before it reaches Ollama, keeping both
+// ends, and that the client is warned.
+func TestGiantMessageIsTrimmed(t *testing.T) {
+	var mu sync.Mutex
+	var sent string
+	mockOllama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/tags" {
+			http.NotFound(w, r)
+			return
+		}
+		var req OllamaRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		sent = req.Messages[len(req.Messages)-1].Content
+		mu.Unlock()
+		w.Write([]byte(`{"message": {"content": "OK"}, "done": true}` + "\n"))
+	}))
+	defer mockOllama.Close()
+
+	oldURL, oldBudget := OllamaAPIURL, TokenBudget
+	OllamaAPIURL, TokenBudget = mockOllama.URL, 500
+	defer func() { OllamaAPIURL, TokenBudget = oldURL, oldBudget }()
+
+	giant := "BEGIN " + strings.Repeat("lorem ipsum ", 10000) + " What is this about?"
+	var warning string
+	out := frameFunc(func(v interface{}) error {
+		if frame := v.(StreamResponse); frame.Status == StatusTrimmed {
+			warning = frame.Warning
+		}
+		return nil
+	})
+	var history []OllamaMessage
+	if err := streamOllama(context.Background(), out, OllamaMessage{Role: "user", Content: giant}, &history, "m", Sampling); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	system, _ := splitSystem(nil, Sampling)
+	if used := messageTokens(system) + messageTokens(OllamaMessage{Content: sent}); used > TokenBudget {
+		t.Errorf("sent ~%d tokens, want at most %d", used, TokenBudget)
+	}
+	if !strings.HasPrefix(sent, "BEGIN ") || !strings.HasSuffix(sent, "What is this about?") || !strings.Contains(sent, trimMarker) {
+		t.Errorf("trimmed message lost its ends: %.40q…", sent)
+	}
+	if !strings.Contains(warning, "left out") {
+		t.Errorf("got warning %q", warning)
+	}
+	if history[0].Content != sent {
+		t.Error("history should keep the message as the model saw it")
+	}
+
+	// A message that fits goes through untouched
+	if fitted, warning := fitPrompt(system, OllamaMessage{Content: "short"}, TokenBudget); fitted.Content != "short" || warning != "" {
+		t.Errorf("short message: got %q, warning %q", fitted.Content, warning)
+	}
+}
+
+// TestContextBudgetLeavesRoomForReply verifies that a num_ctx window isn't
+// all given to the prompt: the reply keeps its max_tokens, or a quarter of
+// the window, but never more than half.
+func TestContextBudgetLeavesRoomForReply(t *testing.T) {
+	cases := []struct {
+		numCtx, numPredict, want int
+	}{
+		{8192, 0, 6144},
+		{8192, 1000, 7192},
+		{8192, 6000, 4096},
+		{0, 1000, TokenBudget},
+	}
+	for _, tc := range cases {
+		got := contextBudget(SamplingOptions{NumCtx: tc.numCtx, NumPredict: tc.numPredict})
+		if got != tc.want {
+			t.Errorf("num_ctx %d, num_predict %d: got budget %d, want %d", tc.numCtx, tc.numPredict, got, tc.want)
+		}
+		if tc.numCtx > 0 && got >= tc.numCtx {
+			t.Errorf("num_ctx %d: budget %d leaves no room for the reply", tc.numCtx, got)
+		}
+	}
+}
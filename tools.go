@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+)
+
+// RoleTool is the role of a message that answers one of the model's tool
+// calls with the tool's output.
+const RoleTool = "tool"
+
+// MaxTools is how many tools a request may offer the model.
+const MaxTools = 32
+
+// Tool is a function the model may ask the client to call, in the format
+// Ollama takes in a chat request's tools.
+type Tool struct {
+	Type     string       `json:"type"` // Always "function"
+	Function ToolFunction `json:"function"`
+}
+
+// ToolFunction describes a tool's name and arguments. Parameters is a JSON
+// Schema object, passed to Ollama as is once its shape has been checked.
+type ToolFunction struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters,omitempty"`
+}
+
+// ToolCall is the model asking for a tool to be called with Arguments.
+type ToolCall struct {
+	Function struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	} `json:"function"`
+}
+
+var toolNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// toolSchema is the part of a JSON Schema Ollama needs to describe a tool's
+// arguments to the model.
+type toolSchema struct {
+	Type       string                     `json:"type"`
+	Properties map[string]json.RawMessage `json:"properties"`
+	Required   []string                   `json:"required"`
+}
+
+// validateTools checks that every tool is a uniquely named function whose
+// parameters, if any, are an object schema requiring only properties it has.
+func validateTools(tools []Tool) error {
+	if len(tools) > MaxTools {
+		return fmt.Errorf("at most %d tools per request, got %d", MaxTools, len(tools))
+	}
+	seen := make(map[string]bool)
+	for i, tool := range tools {
+		name := tool.Function.Name
+		switch {
+		case tool.Type != "function":
+			return fmt.Errorf("tool %d: type must be \"function\", got %q", i, tool.Type)
+		case !toolNamePattern.MatchString(name):
+			return fmt.Errorf("tool %d: name must be 1-64 letters, digits, _ or -, got %q", i, name)
+		case seen[name]:
+			return fmt.Errorf("tool %q is defined twice", name)
+		}
+		seen[name] = true
+
+		if len(tool.Function.Parameters) == 0 {
+			continue
+		}
+		var schema toolSchema
+		if err := json.Unmarshal(tool.Function.Parameters, &schema); err != nil {
+			return fmt.Errorf("tool %q: parameters must be a JSON Schema object: %v", name, err)
+		}
+		if schema.Type != "object" {
+			return fmt.Errorf("tool %q: parameters must have type \"object\", got %q", name, schema.Type)
+		}
+		for _, required := range schema.Required {
+			if _, ok := schema.Properties[required]; !ok {
+				return fmt.Errorf("tool %q: required parameter %q is not in properties", name, required)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// weatherTool is a well-formed tool with one required argument.
+var weatherTool = Tool{Type: "function", Function: ToolFunction{
+	Name:        "get_weather",
+	Description: "Current weather in a city",
+	Parameters:  json.RawMessage(`{"type": "object", "properties": {"city": {"type": "string"}}, "required": ["city"]}`),
+}}
+
+// TestValidateTools verifies that malformed tool definitions are rejected
+// before they reach Ollama.
+func TestValidateTools(t *testing.T) {
+	if err := validateTools([]Tool{weatherTool, {Type: "function", Function: ToolFunction{Name: "now"}}}); err != nil {
+		t.Errorf("valid tools: got error %v", err)
+	}
+
+	withParams := func(params string) Tool {
+		return Tool{Type: "function", Function: ToolFunction{Name: "f", Parameters: json.RawMessage(params)}}
+	}
+	cases := map[string][]Tool{
+		"wrong type":         {{Type: "retrieval", Function: ToolFunction{Name: "f"}}},
+		"no name":            {{Type: "function"}},
+		"bad name":           {{Type: "function", Function: ToolFunction{Name: "get weather"}}},
+		"duplicate":          {weatherTool, weatherTool},
+		"parameters array":   {withParams(`[]`)},
+		"parameters string":  {withParams(`{"type": "string"}`)},
+		"unknown required":   {withParams(`{"type": "object", "properties": {}, "required": ["city"]}`)},
+		"properties not map": {withParams(`{"type": "object", "properties": []}`)},
+	}
+	for name, tools := range cases {
+		if err := validateTools(tools); err == nil {
+			t.Errorf("%s: got no error", name)
+		}
+	}
+}
+
+// TestToolCallRoundTrip verifies that tools reach Ollama, that the model's
+// tool calls reach the client, and that the client's tool result is sent
+// back after the assistant message that asked for it.
+func TestToolCallRoundTrip(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		got  []OllamaRequest
+		call = `{"message": {"role": "assistant", "content": "", "tool_calls": [{"function": {"name": "get_weather", "arguments": {"city": "Paris"}}}]}, "done": false}`
+	)
+	mockOllama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/tags" {
+			http.NotFound(w, r) // Skips the installed model check
+			return
+		}
+		var req OllamaRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		got = append(got, req)
+		first := len(got) == 1
+		mu.Unlock()
+		if first {
+			w.Write([]byte(call + "\n"))
+		} else {
+			w.Write([]byte(`{"message": {"role": "assistant", "content": "Sunny"}, "done": false}` + "\n"))
+		}
+		w.Write([]byte(`{"message": {"role": "assistant", "content": ""}, "done": true}` + "\n"))
+	}))
+	defer mockOllama.Close()
+
+	oldURL := OllamaAPIURL
+	OllamaAPIURL = mockOllama.URL
+	defer func() { OllamaAPIURL = oldURL }()
+
+	server := httptest.NewServer(http.HandlerFunc(handleWebSocket))
+	defer server.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+	ws, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("could not open websocket connection: %v", err)
+	}
+	defer ws.Close()
+
+	readReply := func() []StreamResponse {
+		var frames []StreamResponse
+		ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+		for {
+			var resp StreamResponse
+			if err := ws.ReadJSON(&resp); err != nil {
+				t.Fatalf("Read failed or timed out: %v", err)
+			}
+			frames = append(frames, resp)
+			if resp.Done {
+				return frames
+			}
+		}
+	}
+
+	if err := ws.WriteJSON(ChatRequest{Message: "Weather in Paris?", Tools: []Tool{weatherTool}}); err != nil {
+		t.Fatalf("could not write json: %v", err)
+	}
+	var calls []ToolCall
+	for _, f := range readReply() {
+		calls = append(calls, f.ToolCalls...)
+	}
+	if len(calls) != 1 || calls[0].Function.Name != "get_weather" || string(calls[0].Function.Arguments) != `{"city":"Paris"}` {
+		t.Fatalf("got tool calls %+v", calls)
+	}
+
+	if err := ws.WriteJSON(ChatRequest{Message: "18°C, sunny", Role: RoleTool, ToolName: "get_weather", Tools: []Tool{weatherTool}}); err != nil {
+		t.Fatalf("could not write json: %v", err)
+	}
+	readReply()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(got) != 2 || len(got[0].Tools) != 1 || got[0].Tools[0].Function.Name != "get_weather" {
+		t.Fatalf("Ollama got requests %+v, want two offering get_weather", got)
+	}
+	msgs := got[1].Messages
+	assistant, result := msgs[len(msgs)-2], msgs[len(msgs)-1]
+	if assistant.Role != "assistant" || len(assistant.ToolCalls) != 1 {
+		t.Errorf("got %+v before the tool result, want the assistant's tool call", assistant)
+	}
+	if result.Role != RoleTool || result.ToolName != "get_weather" || result.Content != "18°C, sunny" {
+		t.Errorf("got tool result %+v", result)
+	}
+}
+
+// TestInvalidToolsRejected verifies that a chat with a malformed tool gets an
+// invalid_request frame instead of reaching Ollama.
+func TestInvalidToolsRejected(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(handleWebSocket))
+	defer server.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+	ws, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("could not open websocket connection: %v", err)
+	}
+	defer ws.Close()
+
+	bad := Tool{Type: "function", Function: ToolFunction{Name: "f", Parameters: json.RawMessage(`{"type": "string"}`)}}
+	if err := ws.WriteJSON(ChatRequest{Message: "Hi", Tools: []Tool{bad}}); err != nil {
+		t.Fatalf("could not write json: %v", err)
+	}
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var resp StreamResponse
+	if err := ws.ReadJSON(&resp); err != nil {
+		t.Fatalf("Read failed or timed out: %v", err)
+	}
+	if resp.Code != CodeInvalidRequest {
+		t.Errorf("got frame %+v, want code %q", resp, CodeInvalidRequest)
+	}
+}
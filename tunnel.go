@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// tunnelRetryDelay is how long serveTunnels waits before reopening a
+// dropped tunnel. It doubles after every failed attempt, up to
+// maxTunnelRetryDelay, and starts over once a tunnel stays up that long.
+var tunnelRetryDelay = time.Second
+
+const maxTunnelRetryDelay = time.Minute
+
+// tunnel is a listener with a public URL, such as an ngrok tunnel.
+type tunnel interface {
+	net.Listener
+	URL() string
+}
+
+// serveTunnels serves server on a tunnel from open, and opens a new one
+// whenever it drops, until ctx is done or server is shut down. Failing to
+// open the first tunnel is returned at once, since retrying won't fix a bad
+// token or a domain in use.
+func serveTunnels(ctx context.Context, server *http.Server, open func(context.Context) (tunnel, error)) error {
+	t, err := open(ctx)
+	if err != nil {
+		return err
+	}
+	slog.Info("✅ Ingress established")
+	setTunnelURL(t.URL())
+	log.Printf("🌍 Your chat is live at %s\n", t.URL())
+	printQR(os.Stdout, t.URL())
+
+	delay := tunnelRetryDelay
+	for {
+		up := time.Now()
+		err := server.Serve(t) // Closes t when it returns
+		setTunnelURL("")
+		if errors.Is(err, http.ErrServerClosed) || ctx.Err() != nil {
+			return nil
+		}
+		if time.Since(up) >= maxTunnelRetryDelay {
+			delay = tunnelRetryDelay
+		}
+		slog.Warn("🔌 Tunnel dropped, reconnecting", "error", err, "retry_in", delay)
+
+		for t = nil; t == nil; {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(delay):
+			}
+			delay = min(2*delay, maxTunnelRetryDelay)
+			if t, err = open(ctx); err != nil {
+				slog.Warn("🔌 Could not reopen the tunnel", "error", err, "retry_in", delay)
+			}
+		}
+		setTunnelURL(t.URL())
+		log.Printf("🌍 Tunnel reconnected, your chat is live at %s\n", t.URL())
+	}
+}
+
+// TunnelStatus is the response body of /api/tunnel.
+type TunnelStatus struct {
+	URL         string    `json:"url,omitempty"`
+	ConnectedAt time.Time `json:"connected_at,omitzero"`
+}
+
+// currentTunnel is the public URL serveTunnels is serving on, if any.
+var currentTunnel struct {
+	mu     sync.Mutex
+	status TunnelStatus
+}
+
+// setTunnelURL records the current tunnel's URL, or "" while there is none.
+func setTunnelURL(url string) {
+	currentTunnel.mu.Lock()
+	defer currentTunnel.mu.Unlock()
+	currentTunnel.status = TunnelStatus{URL: url}
+	if url != "" {
+		currentTunnel.status.ConnectedAt = time.Now()
+	}
+}
+
+// handleTunnel reports the public URL of the tunnel, or 503 while it is
+// down and being reopened.
+func handleTunnel(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	currentTunnel.mu.Lock()
+	status := currentTunnel.status
+	currentTunnel.mu.Unlock()
+	if status.URL == "" {
+		writeJSON(w, http.StatusServiceUnavailable, status)
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+// serveTunnelStatus serves /api/tunnel on addr, a localhost address, so
+// scripts on this machine can find out where the tunnel is. It stops when
+// server shuts down.
+func serveTunnelStatus(server *http.Server, addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/tunnel", handleTunnel)
+	local := newServer(mux)
+	local.Addr = addr
+	server.RegisterOnShutdown(func() { local.Close() })
+	go func() {
+		slog.Info("🔗 Public URL available locally", "url", "http://"+addr+"/api/tunnel")
+		if err := local.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Warn("Could not serve /api/tunnel", "addr", addr, "error", err)
+		}
+	}()
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// localTunnel stands in for an ngrok tunnel with a plain local listener.
+type localTunnel struct{ net.Listener }
+
+func (t localTunnel) URL() string { return "http://" + t.Addr().String() }
+
+// TestServeTunnelsReconnects verifies that a dropped tunnel is replaced,
+// after a failed attempt, and that cancelling stops the loop.
+func TestServeTunnelsReconnects(t *testing.T) {
+	oldDelay := tunnelRetryDelay
+	tunnelRetryDelay = time.Millisecond
+	defer func() { tunnelRetryDelay = oldDelay }()
+
+	opened := make(chan localTunnel, 1)
+	attempts := 0
+	open := func(ctx context.Context) (tunnel, error) {
+		attempts++
+		if attempts == 2 {
+			return nil, errors.New("network is down")
+		}
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return nil, err
+		}
+		opened <- localTunnel{l}
+		return localTunnel{l}, nil
+	}
+
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	})}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- serveTunnels(ctx, server, open) }()
+
+	get := func(tun localTunnel) error {
+		resp, err := http.Get(tun.URL())
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+	first := <-opened
+	if err := get(first); err != nil {
+		t.Fatalf("first tunnel: %v", err)
+	}
+	first.Close() // The tunnel drops
+
+	select {
+	case second := <-opened:
+		if err := get(second); err != nil {
+			t.Errorf("second tunnel: %v", err)
+		}
+		rr := httptest.NewRecorder()
+		handleTunnel(rr, httptest.NewRequest(http.MethodGet, "/api/tunnel", nil))
+		var status TunnelStatus
+		json.NewDecoder(rr.Body).Decode(&status)
+		if rr.Code != http.StatusOK || status.URL != second.URL() {
+			t.Errorf("/api/tunnel: got %d %+v, want the new URL %s", rr.Code, status, second.URL())
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the tunnel was not reopened")
+	}
+	if attempts != 3 {
+		t.Errorf("opened %d times, want 3 with the failed attempt", attempts)
+	}
+
+	cancel()
+	server.Close()
+	defer setTunnelURL("")
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("got %v after cancelling, want nil", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("serveTunnels did not return after cancelling")
+	}
+}
+
+// TestServeTunnelsFirstFailure verifies that failing to open the first
+// tunnel is returned rather than retried.
+func TestServeTunnelsFirstFailure(t *testing.T) {
+	err := serveTunnels(context.Background(), &http.Server{}, func(context.Context) (tunnel, error) {
+		return nil, errors.New("invalid authtoken")
+	})
+	if err == nil || err.Error() != "invalid authtoken" {
+		t.Errorf("got %v", err)
+	}
+}
+
+// TestHandleTunnel verifies that /api/tunnel reports the URL, and 503
+// while the tunnel is down.
+func TestHandleTunnel(t *testing.T) {
+	defer setTunnelURL("")
+
+	setTunnelURL("https://chat.example.ngrok.app")
+	rr := httptest.NewRecorder()
+	handleTunnel(rr, httptest.NewRequest(http.MethodGet, "/api/tunnel", nil))
+	var status TunnelStatus
+	json.NewDecoder(rr.Body).Decode(&status)
+	if rr.Code != http.StatusOK || status.URL != "https://chat.example.ngrok.app" || status.ConnectedAt.IsZero() {
+		t.Errorf("got %d %+v", rr.Code, status)
+	}
+
+	setTunnelURL("")
+	rr = httptest.NewRecorder()
+	handleTunnel(rr, httptest.NewRequest(http.MethodGet, "/api/tunnel", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("while down: got %d, want 503", rr.Code)
+	}
+}
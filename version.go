@@ -0,0 +1,106 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+)
+
+// Version and Commit identify this build. Release builds set them with
+//
+//	go build -ldflags "-X main.Version=v1.2.0 -X main.Commit=$(git rev-parse HEAD)"
+//
+// Otherwise they are taken from the module and VCS information Go embeds.
+var (
+	Version string
+	Commit  string
+)
+
+// VersionInfo is the response body of the version endpoint.
+type VersionInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	GoVersion string `json:"go_version"`
+	// Ollama is the version of the Ollama server, or empty with OllamaError
+	// saying why when it couldn't be asked
+	Ollama      string `json:"ollama,omitempty"`
+	OllamaError string `json:"ollama_error,omitempty"`
+}
+
+// buildVersion returns Version and Commit, falling back to the build info
+// embedded by the go command. A commit with uncommitted changes ends in
+// "-dirty".
+func buildVersion() (version, commit string) {
+	version, commit = Version, Commit
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return cmp.Or(version, "dev"), commit
+	}
+	if version == "" && info.Main.Version != "(devel)" {
+		version = info.Main.Version
+	}
+	if commit == "" {
+		var dirty bool
+		for _, s := range info.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				commit = s.Value
+			case "vcs.modified":
+				dirty = s.Value == "true"
+			}
+		}
+		if commit != "" && dirty {
+			commit += "-dirty"
+		}
+	}
+	return cmp.Or(version, "dev"), commit
+}
+
+// fetchOllamaVersion asks Ollama's /api/version endpoint which version it is.
+func fetchOllamaVersion(ctx context.Context) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, HealthCheckTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", ollamaEndpoint("/api/version"), nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := ollamaClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("ollama returned %s", resp.Status)
+	}
+	var body struct {
+		Version string `json:"version"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", err
+	}
+	return body.Version, nil
+}
+
+// handleVersion reports the versions of this server and of Ollama, for bug
+// reports. It answers 200 even when Ollama is down, saying so.
+func handleVersion(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	info := VersionInfo{GoVersion: runtime.Version()}
+	info.Version, info.Commit = buildVersion()
+	ollama, err := fetchOllamaVersion(r.Context())
+	if err != nil {
+		info.OllamaError = err.Error()
+	}
+	info.Ollama = ollama
+	writeJSON(w, http.StatusOK, info)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestHandleVersion verifies that the version endpoint reports the build
+// set with -ldflags and Ollama's version, and still answers when Ollama is
+// down.
+func TestHandleVersion(t *testing.T) {
+	mockOllama := httptest.NewServer(mockOllamaHandler())
+	defer mockOllama.Close()
+
+	oldURL, oldVersion, oldCommit := OllamaAPIURL, Version, Commit
+	OllamaAPIURL, Version, Commit = mockOllama.URL+"/api/chat", "v1.2.0", "abc123"
+	defer func() { OllamaAPIURL, Version, Commit = oldURL, oldVersion, oldCommit }()
+
+	get := func() VersionInfo {
+		rr := httptest.NewRecorder()
+		handleVersion(rr, httptest.NewRequest(http.MethodGet, "/api/version", nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("got status %d", rr.Code)
+		}
+		var info VersionInfo
+		if err := json.NewDecoder(rr.Body).Decode(&info); err != nil {
+			t.Fatal(err)
+		}
+		return info
+	}
+
+	info := get()
+	if info.Version != "v1.2.0" || info.Commit != "abc123" || info.Ollama != "mock" || info.GoVersion == "" {
+		t.Errorf("got %+v", info)
+	}
+
+	mockOllama.Close()
+	if info = get(); info.Ollama != "" || info.OllamaError == "" || info.Version != "v1.2.0" {
+		t.Errorf("with Ollama down: got %+v", info)
+	}
+}
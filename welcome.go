@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Welcome is sent to every WebSocket client as soon as it connects, before
+// it says anything. Empty, the default, sends nothing. It is set once in main.
+var Welcome = ""
+
+// StatusWelcome marks the frame carrying Welcome. The chat UI shows it in
+// place of its own greeting.
+const StatusWelcome = "welcome"
+
+// loadWelcome reads the welcome message from path.
+func loadWelcome(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	welcome := strings.TrimSpace(string(data))
+	if welcome == "" {
+		return "", fmt.Errorf("%s is empty", path)
+	}
+	return welcome, nil
+}
+
+// welcomeFrame is the first frame of a new connection, or false if there
+// is no Welcome to send.
+func welcomeFrame() (StreamResponse, bool) {
+	if Welcome == "" {
+		return StreamResponse{}, false
+	}
+	return StreamResponse{Status: StatusWelcome, Chunk: Welcome, Done: true}, true
+}
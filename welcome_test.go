@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// TestWelcomeFrame verifies that a connecting client gets the welcome
+// message first, and nothing when there is none.
+func TestWelcomeFrame(t *testing.T) {
+	oldWelcome := Welcome
+	defer func() { Welcome = oldWelcome }()
+
+	server := httptest.NewServer(http.HandlerFunc(handleWebSocket))
+	defer server.Close()
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	for _, welcome := range []string{"Ahoy! Ask me anything.", ""} {
+		Welcome = welcome
+		ws, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+		if err != nil {
+			t.Fatalf("could not open websocket connection: %v", err)
+		}
+		// A reset is answered at once, so it shows what came before it
+		if err := ws.WriteJSON(ChatRequest{Type: MessageTypeReset}); err != nil {
+			t.Fatalf("could not write json: %v", err)
+		}
+		ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+		var resp StreamResponse
+		if err := ws.ReadJSON(&resp); err != nil {
+			t.Fatalf("Read failed or timed out: %v", err)
+		}
+		if welcome == "" {
+			if resp.Status != StatusReset {
+				t.Errorf("without a welcome: got %+v first, want the reset confirmation", resp)
+			}
+		} else if resp.Status != StatusWelcome || resp.Chunk != welcome || !resp.Done {
+			t.Errorf("got %+v first, want the welcome", resp)
+		}
+		ws.Close()
+	}
+}
+
+// TestLoadWelcome verifies that the welcome file is read and trimmed, and
+// that an empty one is refused.
+func TestLoadWelcome(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "welcome.txt")
+	os.WriteFile(path, []byte("\nWelcome to the lab's chat.\n"), 0o600)
+	if got, err := loadWelcome(path); err != nil || got != "Welcome to the lab's chat." {
+		t.Errorf("got %q, %v", got, err)
+	}
+
+	empty := filepath.Join(dir, "empty.txt")
+	os.WriteFile(empty, []byte("  \n"), 0o600)
+	if _, err := loadWelcome(empty); err == nil {
+		t.Error("an empty welcome file should be refused")
+	}
+	if _, err := loadWelcome(filepath.Join(dir, "missing.txt")); err == nil {
+		t.Error("a missing welcome file should be refused")
+	}
+}